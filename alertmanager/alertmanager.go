@@ -0,0 +1,194 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package alertmanager implements the alert and silence operations of the
+// Alertmanager v2 API.
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
+)
+
+// A Client manages communication with the Alertmanager API.
+type Client struct {
+	// BaseURL is the Alertmanager URL, e.g. http://localhost:9093/
+	BaseURL *url.URL
+	// HTTPClient is used for all requests to the Alertmanager API.
+	HTTPClient *http.Client
+}
+
+// Matcher matches alerts with the given label value.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+// Silence mutes all alerts that match every matcher between StartsAt and
+// EndsAt.
+type Silence struct {
+	ID        string         `json:"id,omitempty"`
+	Matchers  []Matcher      `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	Status    *SilenceStatus `json:"status,omitempty"`
+}
+
+// SilenceStatus is the status of a silence, set by Alertmanager.
+type SilenceStatus struct {
+	// State is one of "expired", "active" or "pending".
+	State string `json:"state"`
+}
+
+// Alert is an alert known to Alertmanager.
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Labels      map[string]string `json:"labels"`
+	Receivers   []Receiver        `json:"receivers"`
+	StartsAt    time.Time         `json:"startsAt"`
+	Status      AlertStatus       `json:"status"`
+}
+
+// Receiver is the name of a receiver that an alert is routed to.
+type Receiver struct {
+	Name string `json:"name"`
+}
+
+// AlertStatus is the status of an alert.
+type AlertStatus struct {
+	// State is one of "unprocessed", "active" or "suppressed".
+	State string `json:"state"`
+}
+
+// NewClient creates a Client for the Alertmanager at baseURL.
+func NewClient(baseURL string) (*Client, error) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{BaseURL: u, HTTPClient: &http.Client{Transport: &tracing.Transport{}}}, nil
+}
+
+// EqualMatchers returns the matchers for alerts with exactly the given labels,
+// sorted by label name.
+func EqualMatchers(labels map[string]string) []Matcher {
+	matchers := make([]Matcher, 0, len(labels))
+	for name, value := range labels {
+		matchers = append(matchers, Matcher{Name: name, Value: value, IsEqual: true})
+	}
+	sort.Slice(matchers, func(i, j int) bool { return matchers[i].Name < matchers[j].Name })
+	return matchers
+}
+
+// CreateSilence creates the silence and returns its ID.
+func (c *Client) CreateSilence(ctx context.Context, s *Silence) (string, error) {
+	// See also: https://github.com/prometheus/alertmanager/blob/main/api/v2/openapi.yaml
+	result := &struct {
+		SilenceID string `json:"silenceID"`
+	}{}
+	if err := c.do(ctx, http.MethodPost, "api/v2/silences", s, result); err != nil {
+		return "", err
+	}
+	return result.SilenceID, nil
+}
+
+// ListSilences returns all silences, including expired ones.
+func (c *Client) ListSilences(ctx context.Context) ([]*Silence, error) {
+	var silences []*Silence
+	if err := c.do(ctx, http.MethodGet, "api/v2/silences", nil, &silences); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+// ExpireSilence expires the silence with the given ID.
+func (c *Client) ExpireSilence(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "api/v2/silence/"+url.PathEscape(id), nil, nil)
+}
+
+// ListAlerts returns all alerts that are not resolved, including silenced and
+// inhibited alerts.
+func (c *Client) ListAlerts(ctx context.Context) ([]*Alert, error) {
+	var alerts []*Alert
+	if err := c.do(ctx, http.MethodGet, "api/v2/alerts", nil, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// SilenceURL returns the URL of the silence in the Alertmanager UI.
+func (c *Client) SilenceURL(id string) string {
+	return c.BaseURL.String() + "#/silences/" + id
+}
+
+// do sends an API request with the JSON encoded body and decodes the JSON
+// response into result. Either body or result may be nil.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	u, err := c.BaseURL.Parse(path)
+	if err != nil {
+		return err
+	}
+	var buf io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		buf = bytes.NewReader(b)
+	}
+
+	// Enforce a timeout on every API operation.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %d %s", method, u.Path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package alertmanager_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
+)
+
+// Global vars for tests.
+//
+// Tests should register handlers on testMux which provide mock responses for
+// the Alertmanager API method used by the method under test.
+var (
+	// testMux is the HTTP request multiplexer used with the test server.
+	testMux *http.ServeMux
+
+	// testServer is a test HTTP server used to provide mock API responses.
+	testServer *httptest.Server
+)
+
+// setupServer starts a new http test server and returns a client for it.
+func setupServer(t *testing.T) *alertmanager.Client {
+	testMux = http.NewServeMux()
+	testServer = httptest.NewServer(testMux)
+	c, err := alertmanager.NewClient(testServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// teardownServer stops the test server.
+func teardownServer() {
+	testServer.Close()
+}
+
+func TestEqualMatchers(t *testing.T) {
+	got := alertmanager.EqualMatchers(map[string]string{"instance": "a", "alertname": "b"})
+	want := []alertmanager.Matcher{
+		{Name: "alertname", Value: "b", IsEqual: true},
+		{Name: "instance", Value: "a", IsEqual: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EqualMatchers() = %v, want %v", got, want)
+	}
+}
+
+func TestClient_CreateSilence(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			want:   "fake-silence-id",
+		},
+		{
+			name:    "failure-bad-request",
+			status:  http.StatusBadRequest,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := setupServer(t)
+			defer teardownServer()
+			now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+			s := &alertmanager.Silence{
+				Matchers:  alertmanager.EqualMatchers(map[string]string{"alertname": "DiskRunningFull"}),
+				StartsAt:  now,
+				EndsAt:    now.Add(time.Hour),
+				CreatedBy: "octocat",
+				Comment:   "fake comment",
+			}
+			testMux.HandleFunc("/api/v2/silences", func(w http.ResponseWriter, r *http.Request) {
+				got := &alertmanager.Silence{}
+				if r.Method != http.MethodPost {
+					t.Errorf("wrong method; got %s, want POST", r.Method)
+				}
+				if err := json.NewDecoder(r.Body).Decode(got); err != nil || !reflect.DeepEqual(got, s) {
+					t.Errorf("wrong silence; got %#v, want %#v", got, s)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, `{"silenceID": "fake-silence-id"}`)
+			})
+			got, err := c.CreateSilence(context.Background(), s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateSilence() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CreateSilence() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_ListSilences(t *testing.T) {
+	c := setupServer(t)
+	defer teardownServer()
+	testMux.HandleFunc("/api/v2/silences", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": "a", "comment": "foo", "status": {"state": "active"}}]`)
+	})
+	got, err := c.ListSilences(context.Background())
+	if err != nil {
+		t.Fatalf("ListSilences() error = %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "a" || got[0].Status.State != "active" {
+		t.Errorf("ListSilences() = %v, want one active silence", got)
+	}
+}
+
+func TestClient_ExpireSilence(t *testing.T) {
+	c := setupServer(t)
+	defer teardownServer()
+	testMux.HandleFunc("/api/v2/silence/a", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			t.Errorf("wrong method; got %s, want DELETE", r.Method)
+		}
+	})
+	if err := c.ExpireSilence(context.Background(), "a"); err != nil {
+		t.Errorf("ExpireSilence() error = %v", err)
+	}
+	if err := c.ExpireSilence(context.Background(), "missing"); err == nil {
+		t.Errorf("ExpireSilence(missing) error = nil, want error")
+	}
+	if got, want := c.SilenceURL("a"), testServer.URL+"/#/silences/a"; got != want {
+		t.Errorf("SilenceURL() = %q, want %q", got, want)
+	}
+}
+
+func TestClient_ListSilences_canceled(t *testing.T) {
+	c := setupServer(t)
+	defer teardownServer()
+	testMux.HandleFunc("/api/v2/silences", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+	// Requests stop with the context of the caller, e.g. a webhook request.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.ListSilences(ctx); err == nil {
+		t.Errorf("ListSilences() error = nil with canceled context, want error")
+	}
+}
+
+func TestClient_ListAlerts(t *testing.T) {
+	c := setupServer(t)
+	defer teardownServer()
+	testMux.HandleFunc("/api/v2/alerts", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"fingerprint": "a", "labels": {"alertname": "DiskRunningFull"},
+			"receivers": [{"name": "github"}], "status": {"state": "suppressed"}}]`)
+	})
+	got, err := c.ListAlerts(context.Background())
+	if err != nil {
+		t.Fatalf("ListAlerts() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Labels["alertname"] != "DiskRunningFull" ||
+		got[0].Receivers[0].Name != "github" || got[0].Status.State != "suppressed" {
+		t.Errorf("ListAlerts() = %v, want one suppressed alert", got)
+	}
+}
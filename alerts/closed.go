@@ -0,0 +1,205 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+)
+
+// ClosedPolicy determines what the ReceiverHandler does when an alert is still
+// firing but its issue was closed by someone other than the receiver. Closed
+// issues are only detected with clients that implement IssueGetter.
+type ClosedPolicy string
+
+// Supported closed issue policies.
+const (
+	// ClosedRecreate creates a new issue immediately. This is the default.
+	ClosedRecreate ClosedPolicy = "recreate"
+	// ClosedRespect creates no issue until the alert resolves and fires again.
+	ClosedRespect ClosedPolicy = "respect"
+	// ClosedReopen reopens the closed issue with a comment. Clients that do not
+	// implement IssueReopener fall back to ClosedLink.
+	ClosedReopen ClosedPolicy = "reopen"
+	// ClosedLink creates a new issue that links to the closed issue.
+	ClosedLink ClosedPolicy = "link"
+)
+
+// DefaultClosedTTL is how long closed issues are remembered by default.
+const DefaultClosedTTL = 24 * time.Hour
+
+// IssueGetter is implemented by clients that can read the current state of an
+// issue. Clients that wrap other clients, e.g. to audit their changes, may
+// implement the optional client interfaces and return errors.ErrUnsupported
+// when the wrapped client does not; they are treated like clients that do not
+// implement the interface.
+type IssueGetter interface {
+	GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error)
+}
+
+// IssueReopener is implemented by clients that can reopen closed issues.
+type IssueReopener interface {
+	ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error)
+}
+
+// IssueCommenter is implemented by clients that can comment on issues.
+type IssueCommenter interface {
+	CommentIssue(ctx context.Context, issue *github.Issue, body string) error
+}
+
+// closedIssue is an issue closed while its alert was firing.
+type closedIssue struct {
+	issue *github.Issue
+	at    time.Time
+}
+
+// seenOpen records that the issue with the given title is open.
+func (rh *ReceiverHandler) seenOpen(title string, issue *github.Issue) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	if rh.open == nil {
+		rh.open = make(map[string]*github.Issue)
+	}
+	rh.open[title] = issue
+	delete(rh.closed, title)
+}
+
+// seenResolved forgets the issue with the given title, because its alert is
+// resolved. seenResolved reports whether the alert was known to be firing.
+func (rh *ReceiverHandler) seenResolved(title string) bool {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	_, firing := rh.open[title]
+	delete(rh.open, title)
+	delete(rh.closed, title)
+	return firing
+}
+
+// closedWhileFiring returns the issue with the given title if it was closed by
+// someone else while its alert was firing, or nil. Called when there is no
+// open issue for a firing alert. Since searches may lag behind changes, the
+// issue is read again to confirm that it is closed. An issue that is still
+// open is returned as open instead.
+func (rh *ReceiverHandler) closedWhileFiring(ctx context.Context, title string) (closed, open *github.Issue, err error) {
+	rh.mu.Lock()
+	issue, firing := rh.open[title]
+	c, ok := rh.closed[title]
+	rh.mu.Unlock()
+	now := time.Now()
+	// Issues without a number were never created, e.g. in a dry run.
+	if firing && issue.GetNumber() != 0 {
+		// The issue was open and the alert has not resolved since.
+		getter, canGet := rh.Client.(IssueGetter)
+		if !canGet {
+			return nil, nil, nil
+		}
+		current, err := getter.GetIssue(ctx, issue)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if current.GetState() != "closed" {
+			return nil, current, nil
+		}
+		logging.FromContext(ctx).Info("Issue was closed while its alert was firing",
+			"title", title, "issue", current.GetNumber(), "closed_by", current.GetClosedBy().GetLogin())
+		c, ok = closedIssue{issue: current, at: now}, true
+		rh.mu.Lock()
+		delete(rh.open, title)
+		if rh.closed == nil {
+			rh.closed = make(map[string]closedIssue)
+		}
+		rh.closed[title] = c
+		rh.mu.Unlock()
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+	if now.Sub(c.at) > rh.closedTTL() {
+		rh.mu.Lock()
+		delete(rh.closed, title)
+		rh.mu.Unlock()
+		return nil, nil, nil
+	}
+	return c.issue, nil, nil
+}
+
+// closedTTL returns how long closed issues are remembered.
+func (rh *ReceiverHandler) closedTTL() time.Duration {
+	if rh.ClosedTTL > 0 {
+		return rh.ClosedTTL
+	}
+	return DefaultClosedTTL
+}
+
+// handleClosed applies the ClosedPolicy to a firing alert whose issue was
+// closed. handleClosed returns true if no new issue should be created, and the
+// body of a new issue otherwise.
+func (rh *ReceiverHandler) handleClosed(ctx context.Context, res *Result, old *github.Issue, body string) (bool, string, error) {
+	title := res.Title
+	policy := rh.ClosedPolicy
+	if _, ok := rh.Client.(IssueReopener); policy == ClosedReopen && !ok {
+		policy = ClosedLink
+	}
+	switch policy {
+	case ClosedRespect:
+		logging.FromContext(ctx).Info("Not recreating closed issue until the alert resolves", "title", title)
+		return true, "", nil
+	case ClosedReopen:
+		reopened, err := rh.Client.(IssueReopener).ReopenIssue(ctx, old)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return false, linkClosed(body, old), nil
+		}
+		if err != nil {
+			return true, "", res.fail(ReasonReopenFailed, err)
+		}
+		logging.FromContext(ctx).Info("Reopened closed issue", "title", title, "issue", reopened.GetNumber())
+		rh.seenOpen(title, reopened)
+		res.Action = ActionReopened
+		res.setIssue(reopened)
+		if commenter, ok := rh.Client.(IssueCommenter); ok {
+			err := commenter.CommentIssue(ctx, reopened, "Reopened because the alert is still firing.")
+			if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+				return true, "", res.fail(ReasonCommentFailed, err)
+			}
+		}
+		return true, "", nil
+	case ClosedLink:
+		return false, linkClosed(body, old), nil
+	default:
+		return false, body, nil
+	}
+}
+
+// linkClosed returns the issue body with a link to the closed issue.
+func linkClosed(body string, old *github.Issue) string {
+	return body + fmt.Sprintf("\nPreviously closed issue: %s\n", issueRef(old))
+}
+
+// issueRef returns a Markdown reference to the issue.
+func issueRef(issue *github.Issue) string {
+	if issue.GetHTMLURL() != "" {
+		return issue.GetHTMLURL()
+	}
+	return fmt.Sprintf("#%d", issue.GetNumber())
+}
@@ -0,0 +1,291 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+)
+
+// commentClient is a local client that records comments.
+type commentClient struct {
+	*local.Client
+	comments []string
+}
+
+func (c *commentClient) CommentIssue(ctx context.Context, issue *github.Issue, body string) error {
+	c.comments = append(c.comments, body)
+	return nil
+}
+
+// noGetClient is a local client that cannot read or reopen single issues.
+type noGetClient struct {
+	c *local.Client
+}
+
+func (n *noGetClient) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	return n.c.CloseIssue(ctx, issue)
+}
+
+func (n *noGetClient) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	return n.c.CreateIssue(ctx, repo, title, body, extra)
+}
+
+func (n *noGetClient) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	return n.c.LabelIssue(ctx, issue, label, add)
+}
+
+func (n *noGetClient) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	return n.c.ListOpenIssues(ctx)
+}
+
+// noReopenClient is a local client that cannot reopen issues.
+type noReopenClient struct {
+	noGetClient
+}
+
+func (n *noReopenClient) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	return n.c.GetIssue(ctx, issue)
+}
+
+// wrappedNoGetClient wraps a client that cannot read or reopen single issues,
+// and reports it with errors.ErrUnsupported.
+type wrappedNoGetClient struct {
+	noGetClient
+}
+
+func (w *wrappedNoGetClient) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// wrappedNoReopenClient wraps a client that cannot reopen or comment on
+// issues, and reports it with errors.ErrUnsupported.
+type wrappedNoReopenClient struct {
+	noReopenClient
+}
+
+func (w *wrappedNoReopenClient) ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (w *wrappedNoReopenClient) CommentIssue(ctx context.Context, issue *github.Issue, body string) error {
+	return errors.ErrUnsupported
+}
+
+func TestReceiverHandler_closedPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    ClosedPolicy
+		ttl       time.Duration
+		noReopen  bool
+		noGet     bool
+		wrapped   bool
+		wantOpen  int
+		wantTotal int
+		wantLink  bool
+		wantNote  bool
+	}{
+		{
+			name:      "recreate-default",
+			wantOpen:  1,
+			wantTotal: 2,
+		},
+		{
+			name:      "respect",
+			policy:    ClosedRespect,
+			wantOpen:  0,
+			wantTotal: 1,
+		},
+		{
+			name:      "respect-expired",
+			policy:    ClosedRespect,
+			ttl:       time.Nanosecond,
+			wantOpen:  1,
+			wantTotal: 2,
+		},
+		{
+			name:      "respect-unconfirmed-recreates",
+			policy:    ClosedRespect,
+			noGet:     true,
+			wantOpen:  1,
+			wantTotal: 2,
+		},
+		{
+			name:      "respect-wrapped-unconfirmed-recreates",
+			policy:    ClosedRespect,
+			noGet:     true,
+			wrapped:   true,
+			wantOpen:  1,
+			wantTotal: 2,
+		},
+		{
+			name:      "reopen",
+			policy:    ClosedReopen,
+			wantOpen:  1,
+			wantTotal: 1,
+			wantNote:  true,
+		},
+		{
+			name:      "reopen-unsupported-links",
+			policy:    ClosedReopen,
+			noReopen:  true,
+			wantOpen:  1,
+			wantTotal: 2,
+			wantLink:  true,
+		},
+		{
+			name:      "reopen-wrapped-unsupported-links",
+			policy:    ClosedReopen,
+			noReopen:  true,
+			wrapped:   true,
+			wantOpen:  1,
+			wantTotal: 2,
+			wantLink:  true,
+		},
+		{
+			name:      "link",
+			policy:    ClosedLink,
+			wantOpen:  1,
+			wantTotal: 2,
+			wantLink:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := local.NewClient()
+			cc := &commentClient{Client: lc}
+			var client ReceiverClient = cc
+			switch {
+			case tt.noReopen && tt.wrapped:
+				client = &wrappedNoReopenClient{noReopenClient{noGetClient{c: lc}}}
+			case tt.noReopen:
+				client = &noReopenClient{noGetClient{c: lc}}
+			case tt.noGet && tt.wrapped:
+				client = &wrappedNoGetClient{noGetClient{c: lc}}
+			case tt.noGet:
+				client = &noGetClient{c: lc}
+			}
+			rh, err := NewReceiver(client, "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rh.ClosedPolicy = tt.policy
+			rh.ClosedTTL = tt.ttl
+			firing := createWebhookMessage("DiskRunningFull", "firing", "")
+
+			// The alert fires and someone closes its issue.
+			if _, err := rh.processAlert(context.Background(), firing); err != nil {
+				t.Fatal(err)
+			}
+			open, _ := lc.ListOpenIssues(context.Background())
+			if _, err := lc.CloseIssue(context.Background(), open[0]); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+
+			// The alert is still firing.
+			for i := 0; i < 2; i++ {
+				if _, err := rh.processAlert(context.Background(), firing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			open, _ = lc.ListOpenIssues(context.Background())
+			if len(open) != tt.wantOpen {
+				t.Errorf("got %d open issues, want %d", len(open), tt.wantOpen)
+			}
+			if n := issueCount(t, lc); n != tt.wantTotal {
+				t.Errorf("got %d issues, want %d", n, tt.wantTotal)
+			}
+			if tt.wantLink && (len(open) != 1 || !strings.Contains(open[0].GetBody(), "Previously closed issue: #1")) {
+				t.Errorf("new issue %v does not link to the closed issue", open)
+			}
+			if tt.wantNote != (len(cc.comments) == 1) {
+				t.Errorf("got comments %q, want reopen comment %v", cc.comments, tt.wantNote)
+			}
+
+			// After the alert resolves, firing again always creates an issue.
+			if _, err := rh.processAlert(context.Background(), createWebhookMessage("DiskRunningFull", "resolved", "")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rh.processAlert(context.Background(), firing); err != nil {
+				t.Fatal(err)
+			}
+			if open, _ = lc.ListOpenIssues(context.Background()); len(open) != 1 {
+				t.Errorf("got %d open issues after resolve and fire, want 1", len(open))
+			}
+		})
+	}
+}
+
+// laggyClient is a local client whose issue searches do not yet include new
+// issues while lag is set.
+type laggyClient struct {
+	*commentClient
+	lag bool
+}
+
+func (l *laggyClient) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	if l.lag {
+		return nil, nil
+	}
+	return l.Client.ListOpenIssues(ctx)
+}
+
+func TestReceiverHandler_closedSearchLag(t *testing.T) {
+	lc := local.NewClient()
+	client := &laggyClient{commentClient: &commentClient{Client: lc}}
+	rh, err := NewReceiver(client, "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh.ClosedPolicy = ClosedReopen
+	firing := createWebhookMessage("DiskRunningFull", "firing", "")
+	if _, err := rh.processAlert(context.Background(), firing); err != nil {
+		t.Fatal(err)
+	}
+
+	// The search does not find the open issue yet.
+	client.lag = true
+	res, err := rh.processAlert(context.Background(), firing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Action != ActionNone || res.Issue != 1 {
+		t.Errorf("processAlert() = %s #%d, want %s #1", res.Action, res.Issue, ActionNone)
+	}
+	if n := issueCount(t, lc); n != 1 {
+		t.Errorf("got %d issues, want 1", n)
+	}
+	if len(client.comments) != 0 {
+		t.Errorf("got comments %q, want none", client.comments)
+	}
+}
+
+// issueCount returns the number of issues ever created by the client.
+func issueCount(t *testing.T, c *local.Client) int {
+	issue, err := c.CreateIssue(context.Background(), "default", "count", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CloseIssue(context.Background(), issue)
+	return issue.GetNumber() - 1
+}
@@ -16,17 +16,24 @@
 package alerts
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
 	"github.com/prometheus/alertmanager/notify/webhook"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 var (
@@ -49,11 +56,13 @@ var (
 )
 
 // ReceiverClient defines all issue operations needed by the ReceiverHandler.
+// CreateIssue returns an issue without a number when no issue was actually
+// created, e.g. in a dry run.
 type ReceiverClient interface {
-	CloseIssue(issue *github.Issue) (*github.Issue, error)
-	CreateIssue(repo, title, body string, extra []string) (*github.Issue, error)
-	LabelIssue(issue *github.Issue, label string, add bool) error
-	ListOpenIssues() ([]*github.Issue, error)
+	CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error)
+	CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error)
+	LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error
+	ListOpenIssues(ctx context.Context) ([]*github.Issue, error)
 }
 
 // ReceiverHandler contains data needed for HTTP handlers.
@@ -77,6 +86,25 @@ type ReceiverHandler struct {
 	// ExtraLabels values will be added to new issues as additional labels.
 	ExtraLabels []string
 
+	// ClosedPolicy determines what happens when an alert is still firing but
+	// its issue was closed by someone else. The default is ClosedRecreate.
+	ClosedPolicy ClosedPolicy
+
+	// ClosedTTL is how long issues closed while their alert was firing are
+	// remembered. The default is DefaultClosedTTL.
+	ClosedTTL time.Duration
+
+	// Recorder records every accepted webhook message when not nil.
+	Recorder *Recorder
+
+	// mu protects open and closed.
+	mu sync.Mutex
+	// open contains the last known open issue of every firing alert, by title.
+	open map[string]*github.Issue
+	// closed contains issues closed by someone else while their alert was
+	// firing, by title.
+	closed map[string]closedIssue
+
 	// titleTmpl is used to format the title of the new issue.
 	titleTmpl *template.Template
 
@@ -110,87 +138,181 @@ func NewReceiver(client ReceiverClient, githubRepo string, autoClose bool, resol
 
 // ServeHTTP receives and processes alertmanager notifications. If the alert
 // is firing and a github issue does not yet exist, one is created. If the
-// alert is resolved and a github issue exists, then it is closed.
+// alert is resolved and a github issue exists, then it is closed. The response
+// is a JSON Result.
 func (rh *ReceiverHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	ctx, span := tracing.StartRequest(req, "alerts.ServeHTTP")
+	defer span.End()
+	ctx, reqID := logging.WithRequestID(ctx)
+	rw.Header().Set(logging.RequestIDHeader, reqID)
+	span.SetAttributes(attribute.String("request_id", reqID))
+	logger := logging.FromContext(ctx)
+
 	// Verify that request is a POST.
 	if req.Method != http.MethodPost {
-		log.Printf("Client used unsupported method: %s: %s", req.Method, req.RemoteAddr)
-		rw.WriteHeader(http.StatusMethodNotAllowed)
+		logger.Warn("Client used unsupported method", "method", req.Method, "remote", req.RemoteAddr)
+		writeResult(rw, http.StatusMethodNotAllowed, &Result{Action: ActionNone,
+			Reason: ReasonMethodNotAllowed, Error: "unsupported method " + req.Method})
 		return
 	}
 
 	// Read request body.
 	alertBytes, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		log.Printf("Failed to read request body: %s", err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		logger.Error("Failed to read request body", "error", err)
+		writeResult(rw, http.StatusInternalServerError,
+			&Result{Action: ActionNone, Reason: ReasonReadFailed, Error: err.Error()})
 		return
 	}
 
 	// The WebhookMessage is dependent on alertmanager version. Parse it.
 	msg := &webhook.Message{}
 	if err := json.Unmarshal(alertBytes, msg); err != nil {
-		log.Printf("Failed to parse webhook message from %s: %s", req.RemoteAddr, err)
-		log.Printf("%s", string(alertBytes))
-		rw.WriteHeader(http.StatusBadRequest)
+		logger.Warn("Failed to parse webhook message", "remote", req.RemoteAddr, "error", err, "body", string(alertBytes))
+		writeResult(rw, http.StatusBadRequest,
+			&Result{Action: ActionNone, Reason: ReasonInvalidMessage, Error: err.Error()})
+		return
+	}
+	if msg.Data == nil {
+		logger.Warn("Webhook message has no alert data", "remote", req.RemoteAddr, "body", string(alertBytes))
+		writeResult(rw, http.StatusBadRequest,
+			&Result{Action: ActionNone, Reason: ReasonInvalidMessage, Error: "message has no alert data"})
 		return
 	}
 	// log.Print(pretty.Sprint(msg))
+	if rh.Recorder != nil {
+		if err := rh.Recorder.Record(time.Now(), alertBytes); err != nil {
+			logger.Error("Failed to record webhook message", "error", err)
+		}
+	}
 
 	// Handle the webhook message.
-	log.Printf("Handling alert: %s", id(msg))
-	if err := rh.processAlert(msg); err != nil {
-		log.Printf("Failed to handle alert: %s: %s", id(msg), err)
-		rw.WriteHeader(http.StatusInternalServerError)
+	logger = logger.With("alert", id(msg))
+	ctx = logging.WithLogger(ctx, logger)
+	logger.Info("Handling alert", "status", msg.Status)
+	span.SetAttributes(attribute.String("alert", id(msg)))
+	res, err := rh.processAlert(ctx, msg)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error("Failed to handle alert", "reason", res.Reason, "error", err)
+		res.Error = err.Error()
+		writeResult(rw, http.StatusInternalServerError, res)
 		return
 	}
-	log.Printf("Completed alert: %s", id(msg))
-	rw.WriteHeader(http.StatusOK)
-	// Empty response.
+	logger.Info("Completed alert", "action", res.Action, "issue", res.Issue)
+	writeResult(rw, http.StatusOK, res)
 }
 
-// processAlert processes an alertmanager webhook message.
-func (rh *ReceiverHandler) processAlert(msg *webhook.Message) error {
+// processAlert processes an alertmanager webhook message. The result is never
+// nil, and describes the failure when the error is not nil.
+func (rh *ReceiverHandler) processAlert(ctx context.Context, msg *webhook.Message) (res *Result, err error) {
+	res = &Result{Action: ActionNone, Repo: rh.getTargetRepo(msg)}
+	ctx = WithAlertGroup(ctx, AlertGroup{Key: msg.GroupKey, Status: msg.Status})
+	ctx, span := tracing.Start(ctx, "alerts.processAlert",
+		attribute.String("alert.status", msg.Status),
+		attribute.String("alert.repo", res.Repo))
+	defer func() {
+		span.SetAttributes(attribute.String("alert.action", res.Action), attribute.Int("alert.issue", res.Issue))
+		tracing.End(span, err)
+	}()
+
 	// TODO(dev): Cache list results.
 	// List known issues from github.
-	issues, err := rh.Client.ListOpenIssues()
+	issues, err := rh.Client.ListOpenIssues(ctx)
 	if err != nil {
-		return err
+		return res, res.fail(ReasonListFailed, err)
 	}
+	updateOpenIssues(issues)
 
 	// Search for an issue that matches the notification message from AM.
-	msgTitle, err := rh.formatTitle(msg)
+	msgTitle, err := rh.formatTitle(ctx, msg)
 	if err != nil {
-		return fmt.Errorf("format title for %q: %s", msg.GroupKey, err)
+		return res, res.fail(ReasonTemplateFailed, fmt.Errorf("format title for %q: %s", msg.GroupKey, err))
 	}
+	res.Title = msgTitle
 	var foundIssue *github.Issue
 	for _, issue := range issues {
 		if msgTitle == *issue.Title {
-			log.Printf("Found matching issue: %s\n", msgTitle)
+			logging.FromContext(ctx).Debug("Found matching issue", "title", msgTitle)
 			foundIssue = issue
+			res.setIssue(issue)
 			break
 		}
 	}
 
 	var alertName = msg.Data.GroupLabels["alertname"]
 	receivedAlerts.WithLabelValues(alertName, msg.Data.Status).Inc()
+	// The lifecycle metrics use the same alertname as the issue metadata.
+	lifecycleName := metadata.New(msg).AlertName()
 
 	// The message is currently firing and we did not find a matching
 	// issue from github, so create a new issue.
 	if msg.Data.Status == "firing" {
+		var closed *github.Issue
 		if foundIssue == nil {
-			msgBody, err := rh.formatIssueBody(msg)
+			// The issue may have been closed by someone while the alert was
+			// firing, or may be missing from the search results.
+			var open *github.Issue
+			closed, open, err = rh.closedWhileFiring(ctx, msgTitle)
 			if err != nil {
-				return fmt.Errorf("format body for %q: %s", msg.GroupKey, err)
+				return res, res.fail(ReasonGetFailed, err)
 			}
-			_, err = rh.Client.CreateIssue(rh.getTargetRepo(msg), msgTitle, msgBody, rh.ExtraLabels)
-			if err == nil {
-				createdIssues.WithLabelValues(alertName).Inc()
+			if open != nil {
+				foundIssue = open
+				res.setIssue(open)
 			}
-		} else {
-			err = rh.Client.LabelIssue(foundIssue, rh.ResolvedLabel, false)
 		}
-		return err
+		if foundIssue == nil {
+			msgBody, err := rh.formatIssueBody(ctx, msg)
+			if err != nil {
+				return res, res.fail(ReasonTemplateFailed, fmt.Errorf("format body for %q: %s", msg.GroupKey, err))
+			}
+			if closed != nil {
+				var skip bool
+				skip, msgBody, err = rh.handleClosed(ctx, res, closed, msgBody)
+				if skip || err != nil {
+					return res, err
+				}
+			}
+			meta, err := metadata.Format(metadata.New(msg))
+			if err != nil {
+				return res, res.fail(ReasonTemplateFailed, fmt.Errorf("format metadata for %q: %s", msg.GroupKey, err))
+			}
+			msgBody += meta
+			created, err := rh.Client.CreateIssue(ctx, res.Repo, msgTitle, msgBody, rh.ExtraLabels)
+			if err != nil {
+				return res, res.fail(ReasonCreateFailed, err)
+			}
+			res.Action = ActionCreated
+			res.setIssue(created)
+			if created.GetNumber() == 0 {
+				// No issue was created, e.g. in a dry run, so there is no
+				// issue to track.
+				return res, nil
+			}
+			createdIssues.WithLabelValues(alertName).Inc()
+			openIssues.WithLabelValues(res.Repo, lifecycleName).Inc()
+			rh.seenOpen(msgTitle, created)
+			return res, nil
+		}
+		rh.seenOpen(msgTitle, foundIssue)
+		// The alert fires again before its issue was closed.
+		refired := rh.ResolvedLabel != "" && hasLabel(foundIssue, rh.ResolvedLabel)
+		if err := rh.Client.LabelIssue(ctx, foundIssue, rh.ResolvedLabel, false); err != nil {
+			return res, res.fail(ReasonLabelFailed, err)
+		}
+		if refired {
+			// Only count the refire once the label is removed, since
+			// Alertmanager retries failed notifications.
+			refiredIssues.WithLabelValues(lifecycleName).Inc()
+			res.Action = ActionLabeled
+		}
+		return res, nil
+	}
+
+	var wasFiring bool
+	if msg.Data.Status == "resolved" {
+		wasFiring = rh.seenResolved(msgTitle)
 	}
 
 	// The message is resolved and we found a matching open issue from github.
@@ -200,17 +322,40 @@ func (rh *ReceiverHandler) processAlert(msg *webhook.Message) error {
 		// alert. Prometheus evaluates rules every `evaluation_interval`.
 		// And, alertmanager preserves an alert until `resolve_timeout`. So
 		// expect (resolve_timeout / evaluation_interval) messages.
-		err := rh.Client.LabelIssue(foundIssue, rh.ResolvedLabel, true)
-		if err != nil {
-			return err
+		// Only the first of them is observed.
+		first := wasFiring || rh.AutoClose || (rh.ResolvedLabel != "" && !hasLabel(foundIssue, rh.ResolvedLabel))
+		if err := rh.resolveIssue(ctx, res, foundIssue); err != nil {
+			return res, err
 		}
-		if rh.AutoClose {
-			_, err := rh.Client.CloseIssue(foundIssue)
-			return err
+		if first {
+			observeAge(resolveDuration, lifecycleName, foundIssue, time.Now())
 		}
+		return res, nil
 	}
 
 	// log.Printf("Unsupported WebhookMessage.Data.Status: %s", msg.Data.Status)
+	return res, nil
+}
+
+// resolveIssue applies the ResolvedLabel to the issue of a resolved alert, and
+// closes the issue if AutoClose is true. The action is recorded in res.
+func (rh *ReceiverHandler) resolveIssue(ctx context.Context, res *Result, issue *github.Issue) error {
+	err := rh.Client.LabelIssue(ctx, issue, rh.ResolvedLabel, true)
+	if err != nil {
+		return res.fail(ReasonLabelFailed, err)
+	}
+	if rh.ResolvedLabel != "" {
+		res.Action = ActionLabeled
+	}
+	if rh.AutoClose {
+		if _, err := rh.Client.CloseIssue(ctx, issue); err != nil {
+			return res.fail(ReasonCloseFailed, err)
+		}
+		name := issueAlertName(issue)
+		observeAge(closeDuration, name, issue, time.Now())
+		openIssues.WithLabelValues(issueRepo(issue), name).Dec()
+		res.Action = ActionClosed
+	}
 	return nil
 }
 
@@ -224,3 +369,26 @@ func (rh *ReceiverHandler) getTargetRepo(msg *webhook.Message) string {
 	}
 	return rh.DefaultRepo
 }
+
+// AlertGroup identifies the alert group that an issue change is made for, and
+// its status, e.g. for audit records.
+type AlertGroup struct {
+	Key    string
+	Status string
+}
+
+// alertGroupKey is the context key of the AlertGroup.
+type alertGroupKey struct{}
+
+// WithAlertGroup returns a copy of ctx with the alert group.
+func WithAlertGroup(ctx context.Context, g AlertGroup) context.Context {
+	return context.WithValue(ctx, alertGroupKey{}, g)
+}
+
+// AlertGroupFromContext returns the alert group of ctx, or the zero
+// AlertGroup if the change is not made for an alert, e.g. by a ChatOps
+// command.
+func AlertGroupFromContext(ctx context.Context) AlertGroup {
+	g, _ := ctx.Value(alertGroupKey{}).(AlertGroup)
+	return g
+}
@@ -16,21 +16,31 @@ package alerts
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/m-lab/alertmanager-github-receiver/logging"
 	"github.com/m-lab/go/prometheusx/promtest"
 
 	"github.com/google/go-github/github"
 	"github.com/prometheus/alertmanager/notify/webhook"
 	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type fakeClient struct {
@@ -41,7 +51,7 @@ type fakeClient struct {
 	labelError   error
 }
 
-func (f *fakeClient) ListOpenIssues() ([]*github.Issue, error) {
+func (f *fakeClient) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
 	fmt.Println("list open issues")
 	if f.listError != nil {
 		return nil, f.listError
@@ -49,7 +59,7 @@ func (f *fakeClient) ListOpenIssues() ([]*github.Issue, error) {
 	return f.listIssues, nil
 }
 
-func (f *fakeClient) LabelIssue(issue *github.Issue, label string, add bool) error {
+func (f *fakeClient) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
 	fmt.Println("label issue")
 	if f.labelError != nil {
 		return f.labelError
@@ -57,13 +67,13 @@ func (f *fakeClient) LabelIssue(issue *github.Issue, label string, add bool) err
 	return nil
 }
 
-func (f *fakeClient) CreateIssue(repo, title, body string, extra []string) (*github.Issue, error) {
+func (f *fakeClient) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
 	fmt.Println("create issue")
 	f.createdIssue = createIssue(title, body, repo)
 	return f.createdIssue, nil
 }
 
-func (f *fakeClient) CloseIssue(issue *github.Issue) (*github.Issue, error) {
+func (f *fakeClient) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
 	fmt.Println("close issue")
 	f.closedIssue = issue
 	return issue, nil
@@ -128,8 +138,12 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 		alertTmpl         string
 		httpStatus        int
 		expectReceiverErr bool
+		wantAction        string
+		wantReason        string
 		wantMessageErr    bool
 		wantReadErr       bool
+		// body replaces the webhook message when not empty.
+		body string
 	}{
 		{
 			name:           "successful-close",
@@ -144,6 +158,7 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  DefaultTitleTmpl,
 			alertTmpl:  DefaultAlertTmpl,
 			httpStatus: http.StatusOK,
+			wantAction: ActionClosed,
 		},
 		{
 			name:           "successful-resolved-after-closed",
@@ -154,6 +169,7 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:      DefaultTitleTmpl,
 			alertTmpl:      DefaultAlertTmpl,
 			httpStatus:     http.StatusOK,
+			wantAction:     ActionNone,
 		},
 		{
 			name:           "successful-create",
@@ -164,6 +180,7 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:      DefaultTitleTmpl,
 			alertTmpl:      DefaultAlertTmpl,
 			httpStatus:     http.StatusOK,
+			wantAction:     ActionCreated,
 		},
 		{
 			name:           "successful-create-with-explicit-repo",
@@ -175,6 +192,7 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:      DefaultTitleTmpl,
 			alertTmpl:      DefaultAlertTmpl,
 			httpStatus:     http.StatusOK,
+			wantAction:     ActionCreated,
 		},
 		{
 			name:           "successful-ignore-existing-issue-for-firing-alert",
@@ -189,6 +207,7 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  DefaultTitleTmpl,
 			alertTmpl:  DefaultAlertTmpl,
 			httpStatus: http.StatusOK,
+			wantAction: ActionNone,
 		},
 		{
 			name:           "successful-title-template",
@@ -203,6 +222,7 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  `{{ (index .Data.Alerts 0).Labels.alertname }}`,
 			alertTmpl:  `Disk is running full on {{ (index .Data.Alerts 0).Labels.instance }}`,
 			httpStatus: http.StatusOK,
+			wantAction: ActionNone,
 		},
 		{
 			name:           "failure-resolved-label",
@@ -218,6 +238,8 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  DefaultTitleTmpl,
 			alertTmpl:  DefaultAlertTmpl,
 			httpStatus: http.StatusInternalServerError,
+			wantAction: ActionNone,
+			wantReason: ReasonLabelFailed,
 		},
 		{
 			name:           "failure-title-template-bad-index",
@@ -232,6 +254,8 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  `{{ (index .Data.Alerts 1).Status }}`,
 			alertTmpl:  DefaultAlertTmpl,
 			httpStatus: http.StatusInternalServerError,
+			wantAction: ActionNone,
+			wantReason: ReasonTemplateFailed,
 		},
 		{
 			name:           "failure-title-template-bad-syntax",
@@ -269,14 +293,29 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:      DefaultTitleTmpl,
 			alertTmpl:      DefaultAlertTmpl,
 			httpStatus:     http.StatusBadRequest,
+			wantAction:     ActionNone,
+			wantReason:     ReasonInvalidMessage,
 			wantMessageErr: true,
 		},
+		{
+			name:       "failure-no-data",
+			method:     http.MethodPost,
+			body:       "{}",
+			titleTmpl:  DefaultTitleTmpl,
+			alertTmpl:  DefaultAlertTmpl,
+			fakeClient: &fakeClient{},
+			httpStatus: http.StatusBadRequest,
+			wantAction: ActionNone,
+			wantReason: ReasonInvalidMessage,
+		},
 		{
 			name:        "failure-reader-error",
 			method:      http.MethodPost,
 			titleTmpl:   DefaultTitleTmpl,
 			alertTmpl:   DefaultAlertTmpl,
 			httpStatus:  http.StatusInternalServerError,
+			wantAction:  ActionNone,
+			wantReason:  ReasonReadFailed,
 			wantReadErr: true,
 		},
 		{
@@ -288,6 +327,8 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  DefaultTitleTmpl,
 			alertTmpl:  DefaultAlertTmpl,
 			httpStatus: http.StatusInternalServerError,
+			wantAction: ActionNone,
+			wantReason: ReasonListFailed,
 		},
 		{
 			name:       "failure-wrong-method",
@@ -295,6 +336,8 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:  DefaultTitleTmpl,
 			alertTmpl:  DefaultAlertTmpl,
 			httpStatus: http.StatusMethodNotAllowed,
+			wantAction: ActionNone,
+			wantReason: ReasonMethodNotAllowed,
 		},
 		{
 			name:           "failure-body-template",
@@ -305,6 +348,8 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 			titleTmpl:      DefaultTitleTmpl,
 			alertTmpl:      `{{ .NOTAREAL_FIELD }}`,
 			httpStatus:     http.StatusInternalServerError,
+			wantAction:     ActionNone,
+			wantReason:     ReasonTemplateFailed,
 		},
 	}
 	for _, tt := range tests {
@@ -315,6 +360,9 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 				// Deliberately corrupt the json content by adding extra braces.
 				msg.Write([]byte{'}', '{'})
 			}
+			if tt.body != "" {
+				msg = bytes.NewBufferString(tt.body)
+			}
 
 			// Convert the webhook message into an io.Reader.
 			var msgReader io.Reader
@@ -366,8 +414,22 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 						*tt.fakeClient.createdIssue.RepositoryURL, tt.msgRepo)
 				}
 			}
-			if string(body) != "" {
-				t.Errorf("ReceiverHandler got %q; want empty body", string(body))
+			if len(resp.Header.Get(logging.RequestIDHeader)) != 16 {
+				t.Errorf("ReceiverHandler got request ID %q; want 16 hex digits", resp.Header.Get(logging.RequestIDHeader))
+			}
+			res := &Result{}
+			if err := json.Unmarshal(body, res); err != nil {
+				t.Fatalf("ReceiverHandler got invalid result %q: %s", string(body), err)
+			}
+			if res.Action != tt.wantAction || res.Reason != tt.wantReason {
+				t.Errorf("ReceiverHandler got action %q reason %q; want %q %q",
+					res.Action, res.Reason, tt.wantAction, tt.wantReason)
+			}
+			if (res.Reason != "") != (res.Error != "") {
+				t.Errorf("ReceiverHandler got reason %q with error %q", res.Reason, res.Error)
+			}
+			if tt.wantAction == ActionCreated && (res.Title != tt.msgAlert || res.Repo != tt.fakeClient.createdIssue.GetRepositoryURL()) {
+				t.Errorf("ReceiverHandler got title %q repo %q for created issue", res.Title, res.Repo)
 			}
 		})
 	}
@@ -375,5 +437,151 @@ func TestReceiverHandler_ServeHTTP(t *testing.T) {
 
 func TestMetrics(t *testing.T) {
 	receivedAlerts.WithLabelValues("x", "y")
+	openIssues.WithLabelValues("x", "y")
+	resolveDuration.WithLabelValues("x")
+	closeDuration.WithLabelValues("x")
+	refiredIssues.WithLabelValues("x")
 	promtest.LintMetrics(t)
 }
+
+func TestReceiverHandler_refiredAction(t *testing.T) {
+	resolved := createIssue("DiskRunningFull", "body1", "")
+	resolved.Labels = []github.Label{{Name: github.String("resolved")}}
+	tests := []struct {
+		name       string
+		issue      *github.Issue
+		wantAction string
+	}{
+		{
+			name:       "resolved-label-removed",
+			issue:      resolved,
+			wantAction: ActionLabeled,
+		},
+		{
+			name:       "already-up-to-date",
+			issue:      createIssue("DiskRunningFull", "body1", ""),
+			wantAction: ActionNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{listIssues: []*github.Issue{tt.issue}}
+			rh, err := NewReceiver(client, "default", false, "resolved", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := rh.processAlert(context.Background(), createWebhookMessage("DiskRunningFull", "firing", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Action != tt.wantAction {
+				t.Errorf("processAlert() action = %q, want %q", res.Action, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestReceiverHandler_unnumberedIssue(t *testing.T) {
+	const name = "UnnumberedTest"
+	// Like a dry run, the fake client creates issues without a number.
+	rh, err := NewReceiver(&fakeClient{}, "default", false, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := rh.processAlert(context.Background(), createWebhookMessage(name, "firing", ""))
+	if err != nil || res.Action != ActionCreated {
+		t.Fatalf("processAlert() = %+v, %v; want %s", res, err, ActionCreated)
+	}
+	if _, ok := rh.open[name]; ok {
+		t.Errorf("processAlert() tracks the unnumbered issue as open")
+	}
+	if got := testutil.ToFloat64(openIssues.WithLabelValues("default", name)); got != 0 {
+		t.Errorf("open issues = %v, want 0", got)
+	}
+}
+
+func TestReceiverHandler_RequestID(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := logging.WithLogger(context.Background(), logging.New(&buf, slog.LevelDebug))
+	client := &fakeClient{
+		listIssues: []*github.Issue{createIssue("DiskRunningFull", "body1", "")},
+	}
+	rh, err := NewReceiver(client, "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := marshalWebhookMessage(createWebhookMessage("DiskRunningFull", "resolved", ""))
+	req := httptest.NewRequest(http.MethodPost, "/v1/receiver", msg).WithContext(ctx)
+	rw := httptest.NewRecorder()
+	rh.ServeHTTP(rw, req)
+
+	// Every record of the request, including those of processAlert, has the
+	// request ID of the response.
+	id := rw.Header().Get(logging.RequestIDHeader)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("got %d log records, want at least 3: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		rec := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["request_id"] != id {
+			t.Errorf("got record %s; want request_id %q", line, id)
+		}
+	}
+	if !strings.Contains(buf.String(), `"msg":"Found matching issue"`) {
+		t.Errorf("got no debug record from processAlert: %s", buf.String())
+	}
+}
+
+func TestReceiverHandler_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	rh, err := NewReceiver(&fakeClient{}, "default", false, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := marshalWebhookMessage(createWebhookMessage("DiskRunningFull", "firing", ""))
+	req := httptest.NewRequest(http.MethodPost, "/v1/receiver", msg)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rh.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+		// All spans continue the trace of the request.
+		if got := s.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s has trace ID %s, want the trace ID of the request", s.Name, got)
+		}
+	}
+	parents := map[string]string{
+		"alerts.processAlert": "alerts.ServeHTTP",
+		"template.title":      "alerts.processAlert",
+		"template.body":       "alerts.processAlert",
+	}
+	for name, parent := range parents {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("got no span %s: %v", name, spans)
+		}
+		if spans[name].Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Errorf("span %s is not a child of %s: got %v", name, parent, spans)
+		}
+	}
+	if got := spans["alerts.ServeHTTP"].Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("alerts.ServeHTTP parent = %s, want the span of the request", got)
+	}
+	var action string
+	for _, kv := range spans["alerts.processAlert"].Attributes {
+		if kv.Key == "alert.action" {
+			action = kv.Value.AsString()
+		}
+	}
+	if action != ActionCreated {
+		t.Errorf("alerts.processAlert action = %q, want %q", action, ActionCreated)
+	}
+}
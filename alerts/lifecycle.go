@@ -0,0 +1,102 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"path"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// lifecycleBuckets range from one minute to about half a year.
+	lifecycleBuckets = prometheus.ExponentialBuckets(60, 4, 10)
+
+	openIssues = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "githubreceiver_open_issues",
+			Help: "Number of open alert issues as of the last issue listing.",
+		},
+		[]string{"repo", "alertname"},
+	)
+	resolveDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "githubreceiver_issue_resolve_seconds",
+			Help:    "A histogram of the time from issue creation until its alert resolved.",
+			Buckets: lifecycleBuckets,
+		},
+		[]string{"alertname"},
+	)
+	closeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "githubreceiver_issue_close_seconds",
+			Help:    "A histogram of the time from issue creation until the receiver closed it.",
+			Buckets: lifecycleBuckets,
+		},
+		[]string{"alertname"},
+	)
+	refiredIssues = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "githubreceiver_issue_refires_total",
+			Help: "Number of times an alert fired again while its resolved issue was still open.",
+		},
+		[]string{"alertname"},
+	)
+)
+
+// updateOpenIssues replaces the open issue counts with those of the listed
+// open issues.
+func updateOpenIssues(issues []*github.Issue) {
+	counts := make(map[[2]string]int)
+	for _, issue := range issues {
+		counts[[2]string{issueRepo(issue), issueAlertName(issue)}]++
+	}
+	openIssues.Reset()
+	for k, n := range counts {
+		openIssues.WithLabelValues(k[0], k[1]).Set(float64(n))
+	}
+}
+
+// observeAge observes the time since the issue was created, if known.
+func observeAge(h *prometheus.HistogramVec, alertName string, issue *github.Issue, now time.Time) {
+	created := issue.GetCreatedAt()
+	if created.IsZero() {
+		return
+	}
+	h.WithLabelValues(alertName).Observe(now.Sub(created).Seconds())
+}
+
+// issueRepo returns the repository name of the issue, if known.
+func issueRepo(issue *github.Issue) string {
+	if issue.GetRepositoryURL() == "" {
+		return ""
+	}
+	return path.Base(issue.GetRepositoryURL())
+}
+
+// issueAlertName returns the alertname of the alert group of the issue, from
+// the issue metadata, if any.
+func issueAlertName(issue *github.Issue) string {
+	meta, err := metadata.Parse(issue.GetBody())
+	if err != nil {
+		return ""
+	}
+	return meta.AlertName()
+}
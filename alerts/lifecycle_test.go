@@ -0,0 +1,90 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package alerts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sampleCount returns the number of observations of the histogram.
+func sampleCount(t *testing.T, h *prometheus.HistogramVec, alertName string) uint64 {
+	m := &dto.Metric{}
+	if err := h.WithLabelValues(alertName).(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestReceiverHandler_lifecycleMetrics(t *testing.T) {
+	const name = "LifecycleTest"
+	firing := createWebhookMessage(name, "firing", "")
+	meta, err := metadata.Format(metadata.New(firing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := createIssue(name, "body"+meta, "https://api.github.com/repos/fake-org/lifecycle")
+	created := time.Now().Add(-2 * time.Hour)
+	issue.CreatedAt = &created
+	issue.Labels = []github.Label{{Name: github.String("resolved")}}
+
+	client := &fakeClient{listIssues: []*github.Issue{issue}}
+	rh, err := NewReceiver(client, "default", true, "resolved", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The alert fires again while its resolved issue is open, but the label
+	// cannot be removed, so Alertmanager retries the notification.
+	client.labelError = errors.New("label failed")
+	if _, err := rh.processAlert(context.Background(), firing); err == nil {
+		t.Fatal("processAlert() got nil error, want label error")
+	}
+	if got := testutil.ToFloat64(refiredIssues.WithLabelValues(name)); got != 0 {
+		t.Errorf("refires = %v after failed label removal, want 0", got)
+	}
+	client.labelError = nil
+	if _, err := rh.processAlert(context.Background(), firing); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(openIssues.WithLabelValues("lifecycle", name)); got != 1 {
+		t.Errorf("open issues = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(refiredIssues.WithLabelValues(name)); got != 1 {
+		t.Errorf("refires = %v, want 1", got)
+	}
+
+	// The alert resolves, and its issue is closed.
+	if _, err := rh.processAlert(context.Background(), createWebhookMessage(name, "resolved", "")); err != nil {
+		t.Fatal(err)
+	}
+	if got := sampleCount(t, resolveDuration, name); got != 1 {
+		t.Errorf("time to resolve has %d samples, want 1", got)
+	}
+	if got := sampleCount(t, closeDuration, name); got != 1 {
+		t.Errorf("time to close has %d samples, want 1", got)
+	}
+	if got := testutil.ToFloat64(openIssues.WithLabelValues("lifecycle", name)); got != 0 {
+		t.Errorf("open issues = %v, want 0", got)
+	}
+}
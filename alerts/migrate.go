@@ -0,0 +1,196 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+// IssueRetitler is implemented by clients that can change issue titles.
+type IssueRetitler interface {
+	RetitleIssue(ctx context.Context, issue *github.Issue, title string) (*github.Issue, error)
+}
+
+// Retitle is the planned title change of an open issue.
+type Retitle struct {
+	Issue *github.Issue
+	// Title is the new title, or empty if the issue is skipped.
+	Title string
+	// Source is how the alert of the issue was found: "metadata" or
+	// "recording". For skipped issues, Source is the reason.
+	Source string
+}
+
+// TitleMigrator retitles open issues when the title template changes, so that
+// later notifications find the existing issues instead of creating new ones.
+// The alert of each issue is reconstructed from the issue metadata, or from
+// recorded webhook messages, and is only trusted if the old template renders
+// the current title of the issue.
+type TitleMigrator struct {
+	// Client lists and retitles the issues.
+	Client ReceiverClient
+
+	oldTmpl *template.Template
+	newTmpl *template.Template
+	// recorded contains the last recorded message of every old title.
+	recorded map[string]*webhook.Message
+}
+
+// NewTitleMigrator creates a TitleMigrator from the old and new title
+// templates.
+func NewTitleMigrator(client ReceiverClient, oldTmplStr, newTmplStr string) (*TitleMigrator, error) {
+	oldTmpl, err := template.New("old").Parse(oldTmplStr)
+	if err != nil {
+		return nil, fmt.Errorf("old title template: %s", err)
+	}
+	newTmpl, err := template.New("new").Parse(newTmplStr)
+	if err != nil {
+		return nil, fmt.Errorf("new title template: %s", err)
+	}
+	return &TitleMigrator{
+		Client:   client,
+		oldTmpl:  oldTmpl,
+		newTmpl:  newTmpl,
+		recorded: make(map[string]*webhook.Message),
+	}, nil
+}
+
+// LoadRecordings reads webhook messages recorded by a Recorder, for issues
+// without metadata.
+func (m *TitleMigrator) LoadRecordings(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxRecordingSize)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		rec := &Recording{}
+		if err := json.Unmarshal(s.Bytes(), rec); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		msg := &webhook.Message{}
+		if err := json.Unmarshal(rec.Message, msg); err != nil || msg.Data == nil {
+			return fmt.Errorf("line %d: invalid webhook message", line)
+		}
+		if title, err := render(m.oldTmpl, msg); err == nil {
+			m.recorded[title] = msg
+		}
+	}
+	return s.Err()
+}
+
+// Plan returns the title changes of all open issues. Issues whose alert is
+// unknown, whose title is unchanged, or whose new title conflicts with another
+// issue are skipped.
+func (m *TitleMigrator) Plan(ctx context.Context) ([]Retitle, error) {
+	issues, err := m.Client.ListOpenIssues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan := make([]Retitle, 0, len(issues))
+	titles := make(map[string]int)
+	for _, issue := range issues {
+		titles[issue.GetTitle()]++
+		r := Retitle{Issue: issue}
+		msg, source := m.message(issue)
+		if msg == nil {
+			r.Source = source
+			plan = append(plan, r)
+			continue
+		}
+		title, err := render(m.newTmpl, msg)
+		switch {
+		case err != nil:
+			r.Source = fmt.Sprintf("new title: %s", err)
+		case title == issue.GetTitle():
+			r.Source = "unchanged"
+		default:
+			r.Title = title
+			r.Source = source
+		}
+		plan = append(plan, r)
+	}
+	// Two issues with the same title would be merged by later notifications.
+	for _, r := range plan {
+		titles[r.Title]++
+	}
+	for i := range plan {
+		if plan[i].Title != "" && titles[plan[i].Title] > 1 {
+			plan[i].Source = fmt.Sprintf("new title %q conflicts with another issue", plan[i].Title)
+			plan[i].Title = ""
+		}
+	}
+	return plan, nil
+}
+
+// Apply retitles the issues of the plan, and returns the number of retitled
+// issues.
+func (m *TitleMigrator) Apply(ctx context.Context, plan []Retitle) (int, error) {
+	rt, ok := m.Client.(IssueRetitler)
+	if !ok {
+		return 0, fmt.Errorf("the issue tracker cannot retitle issues")
+	}
+	n := 0
+	for _, r := range plan {
+		if r.Title == "" {
+			continue
+		}
+		_, err := rt.RetitleIssue(ctx, r.Issue, r.Title)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return n, fmt.Errorf("the issue tracker cannot retitle issues")
+		}
+		if err != nil {
+			return n, fmt.Errorf("retitle %q: %s", r.Issue.GetTitle(), err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// message returns the webhook message of the issue alert and its source, or
+// nil and the reason why the alert is unknown.
+func (m *TitleMigrator) message(issue *github.Issue) (*webhook.Message, string) {
+	if meta, err := metadata.Parse(issue.GetBody()); err == nil {
+		msg := meta.Message()
+		if title, err := render(m.oldTmpl, msg); err == nil && title == issue.GetTitle() {
+			return msg, "metadata"
+		}
+	}
+	if msg, ok := m.recorded[issue.GetTitle()]; ok {
+		return msg, "recording"
+	}
+	return nil, "no matching metadata or recording"
+}
+
+// render executes the title template for the message.
+func render(tmpl *template.Template, msg *webhook.Message) (string, error) {
+	var title bytes.Buffer
+	if err := tmpl.Execute(&title, msg); err != nil {
+		return "", err
+	}
+	return title.String(), nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+)
+
+const newTitleTmpl = `{{ .Data.GroupLabels.alertname }} ({{ .Data.Receiver }})`
+
+// onlyListClient is a client that cannot retitle issues.
+type onlyListClient struct {
+	ReceiverClient
+}
+
+func TestTitleMigrator(t *testing.T) {
+	c := local.NewClient()
+	rh, err := NewReceiver(c, "default", false, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An issue with metadata.
+	if _, err := rh.processAlert(context.Background(), createWebhookMessage("DiskRunningFull", "firing", "")); err != nil {
+		t.Fatal(err)
+	}
+	// An issue without metadata, with a recorded message.
+	var buf bytes.Buffer
+	b := marshalWebhookMessage(createWebhookMessage("CPUHigh", "firing", "")).Bytes()
+	if err := NewRecorder(&buf).Record(time.Now(), b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIssue(context.Background(), "default", "CPUHigh", "body", nil); err != nil {
+		t.Fatal(err)
+	}
+	// An issue without metadata or recording.
+	if _, err := c.CreateIssue(context.Background(), "default", "Manual", "body", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewTitleMigrator(c, DefaultTitleTmpl, newTitleTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadRecordings(&buf); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ title, source string }{
+		{"DiskRunningFull (webhook)", "metadata"},
+		{"CPUHigh (webhook)", "recording"},
+		{"", "no matching metadata or recording"},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("Plan() = %d changes, want %d", len(plan), len(want))
+	}
+	for i, w := range want {
+		if plan[i].Title != w.title || plan[i].Source != w.source {
+			t.Errorf("Plan()[%d] = %q from %q, want %q from %q", i, plan[i].Title, plan[i].Source, w.title, w.source)
+		}
+	}
+
+	if _, err := (&TitleMigrator{Client: &onlyListClient{c}}).Apply(context.Background(), plan); err == nil {
+		t.Errorf("Apply() got nil error for client without RetitleIssue, want error")
+	}
+	n, err := m.Apply(context.Background(), plan)
+	if err != nil || n != 2 {
+		t.Fatalf("Apply() = %d, %v; want 2 retitled issues", n, err)
+	}
+	// Notifications now find the retitled issue.
+	rh, err = NewReceiver(c, "default", false, "", nil, newTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.processAlert(context.Background(), createWebhookMessage("DiskRunningFull", "firing", "")); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := c.ListOpenIssues(context.Background()); len(open) != 3 {
+		t.Errorf("got %d open issues after migration, want 3", len(open))
+	}
+	// A second migration has nothing to do.
+	plan, err = m.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range plan {
+		if r.Title != "" {
+			t.Errorf("Plan() after migration = %q, want no changes", r.Title)
+		}
+	}
+}
+
+func TestTitleMigrator_conflicts(t *testing.T) {
+	c := local.NewClient()
+	rh, err := NewReceiver(c, "default", false, "", nil, `{{ .Data.GroupLabels.alertname }} {{ .Data.Receiver }}`, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, receiver := range []string{"a", "b"} {
+		msg := createWebhookMessage("DiskRunningFull", "firing", "")
+		msg.Receiver = receiver
+		if _, err := rh.processAlert(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewTitleMigrator(c, `{{ .Data.GroupLabels.alertname }} {{ .Data.Receiver }}`, DefaultTitleTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range plan {
+		if r.Title != "" || !strings.Contains(r.Source, "conflicts") {
+			t.Errorf("Plan() = %q from %q, want conflict", r.Title, r.Source)
+		}
+	}
+}
+
+func TestTitleMigrator_errors(t *testing.T) {
+	if _, err := NewTitleMigrator(nil, "{{ x }}", DefaultTitleTmpl); err == nil {
+		t.Errorf("NewTitleMigrator() got nil error for bad old template, want error")
+	}
+	if _, err := NewTitleMigrator(nil, DefaultTitleTmpl, "{{ x }}"); err == nil {
+		t.Errorf("NewTitleMigrator() got nil error for bad new template, want error")
+	}
+	m, err := NewTitleMigrator(&fakeClient{listError: fmt.Errorf("Fake error")}, DefaultTitleTmpl, DefaultTitleTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadRecordings(strings.NewReader("{\n")); err == nil {
+		t.Errorf("LoadRecordings() got nil error for malformed line, want error")
+	}
+	if err := m.LoadRecordings(strings.NewReader(`{"message": {}}`)); err == nil {
+		t.Errorf("LoadRecordings() got nil error for message without data, want error")
+	}
+	if _, err := m.Plan(context.Background()); err == nil {
+		t.Errorf("Plan() got nil error for list error, want error")
+	}
+}
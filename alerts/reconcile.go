@@ -0,0 +1,216 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	reconcileRuns = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "githubreceiver_reconcile_runs_total",
+			Help: "Number of reconciliations of open issues against Alertmanager.",
+		},
+		// "status" is one of "ok" or "error".
+		[]string{"status"},
+	)
+
+	reconciledIssues = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "githubreceiver_reconciled_issues_total",
+			Help: "Number of stale issues found by reconciliation.",
+		},
+		// "action" is one of "resolved", "dryrun" or "error".
+		[]string{"action"},
+	)
+
+	reconcileLastSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "githubreceiver_reconcile_last_success_timestamp_seconds",
+			Help: "Time of the last successful reconciliation.",
+		},
+	)
+)
+
+// StatusStale is the alert status of issues resolved by reconciliation in
+// audit records.
+const StatusStale = "stale"
+
+// AlertLister lists the alerts known to Alertmanager.
+type AlertLister interface {
+	ListAlerts(ctx context.Context) ([]*alertmanager.Alert, error)
+}
+
+// Reconciler resolves open issues whose alerts are no longer known to
+// Alertmanager, e.g. because the "resolved" notification was lost while the
+// receiver was down. Only issues with alert metadata are considered.
+type Reconciler struct {
+	// Receiver resolves stale issues in the same way as resolved alerts, i.e.
+	// by applying the ResolvedLabel and closing them when AutoClose is true.
+	Receiver *ReceiverHandler
+
+	// Alerts lists the alerts known to Alertmanager.
+	Alerts AlertLister
+
+	// DryRun logs stale issues without changing them.
+	DryRun bool
+}
+
+// NewReconciler creates a new Reconciler.
+func NewReconciler(receiver *ReceiverHandler, alerts AlertLister, dryRun bool) *Reconciler {
+	return &Reconciler{
+		Receiver: receiver,
+		Alerts:   alerts,
+		DryRun:   dryRun,
+	}
+}
+
+// Run reconciles open issues every interval until the context is canceled.
+// A reconciliation in progress when the context is canceled is finished, so
+// that no issue is left half resolved.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	if !r.Receiver.AutoClose && r.Receiver.ResolvedLabel == "" {
+		logging.FromContext(ctx).Warn("Reconciliation cannot resolve stale issues without a resolved label or auto-close")
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		// Every run has its own request ID, to correlate its issue changes.
+		rctx, _ := logging.WithRequestID(context.WithoutCancel(ctx))
+		if n, err := r.Reconcile(rctx); err != nil {
+			logging.FromContext(rctx).Error("Failed to reconcile open issues", "error", err)
+		} else if n > 0 {
+			logging.FromContext(rctx).Info("Reconciled stale issues", "count", n)
+		}
+	}
+}
+
+// Reconcile resolves the open issues whose alerts are no longer known to
+// Alertmanager and returns the number of stale issues found.
+func (r *Reconciler) Reconcile(ctx context.Context) (int, error) {
+	n, err := r.reconcile(ctx)
+	if err != nil {
+		reconcileRuns.WithLabelValues("error").Inc()
+		return n, err
+	}
+	reconcileRuns.WithLabelValues("ok").Inc()
+	reconcileLastSuccess.SetToCurrentTime()
+	return n, nil
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) (int, error) {
+	// List alerts after issues, so that issues created in between have alerts.
+	issues, err := r.Receiver.Client.ListOpenIssues(ctx)
+	if err != nil {
+		return 0, err
+	}
+	alerts, err := r.Alerts.ListAlerts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rh := r.Receiver
+	logger := logging.FromContext(ctx)
+	n := 0
+	for _, issue := range issues {
+		meta, err := metadata.Parse(issue.GetBody())
+		if err != nil {
+			// Not an alert issue, or created before metadata was recorded.
+			continue
+		}
+		if hasAlert(alerts, meta) {
+			continue
+		}
+		if !rh.AutoClose && (rh.ResolvedLabel == "" || hasLabel(issue, rh.ResolvedLabel)) {
+			// Already resolved, or cannot be resolved, and remains open on
+			// purpose.
+			continue
+		}
+		n++
+		if r.DryRun {
+			logger.Info("Dry run: found stale issue", "title", issue.GetTitle())
+			reconciledIssues.WithLabelValues("dryrun").Inc()
+			continue
+		}
+		logger.Info("Resolving stale issue", "title", issue.GetTitle())
+		res := &Result{Repo: issueRepo(issue)}
+		groupCtx := WithAlertGroup(ctx, AlertGroup{Key: meta.GroupKey, Status: StatusStale})
+		if err := rh.resolveIssue(groupCtx, res, issue); err != nil {
+			logger.Error("Failed to resolve stale issue", "title", issue.GetTitle(), "error", err)
+			reconciledIssues.WithLabelValues("error").Inc()
+			continue
+		}
+		rh.seenResolved(issue.GetTitle())
+		observeAge(resolveDuration, meta.AlertName(), issue, time.Now())
+		reconciledIssues.WithLabelValues("resolved").Inc()
+	}
+	return n, nil
+}
+
+// hasAlert reports whether any alert belongs to the alert group described by
+// the metadata.
+func hasAlert(alerts []*alertmanager.Alert, meta *metadata.Metadata) bool {
+	matchers := meta.Matchers()
+	for _, a := range alerts {
+		if meta.Receiver != "" && !hasReceiver(a, meta.Receiver) {
+			continue
+		}
+		matches := true
+		for k, v := range matchers {
+			if a.Labels[k] != v {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// hasReceiver reports whether the alert is routed to the named receiver.
+func hasReceiver(a *alertmanager.Alert, name string) bool {
+	for _, r := range a.Receivers {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabel reports whether the issue has the named label.
+func hasLabel(issue *github.Issue, name string) bool {
+	for _, l := range issue.Labels {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
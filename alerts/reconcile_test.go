@@ -0,0 +1,213 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+)
+
+// fakeAlertLister returns a fixed list of alerts.
+type fakeAlertLister struct {
+	alerts []*alertmanager.Alert
+	err    error
+}
+
+func (f *fakeAlertLister) ListAlerts(ctx context.Context) ([]*alertmanager.Alert, error) {
+	return f.alerts, f.err
+}
+
+// createAlertIssue creates an issue with metadata for the given group labels.
+func createAlertIssue(t *testing.T, c *local.Client, title string, groupLabels map[string]string) {
+	meta, err := metadata.Format(&metadata.Metadata{Receiver: "github", GroupLabels: groupLabels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIssue(context.Background(), "default", title, "body"+meta, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	active := &alertmanager.Alert{
+		Labels:    map[string]string{"alertname": "Active", "instance": "a"},
+		Receivers: []alertmanager.Receiver{{Name: "github"}},
+	}
+	otherReceiver := &alertmanager.Alert{
+		Labels:    map[string]string{"alertname": "Stale"},
+		Receivers: []alertmanager.Receiver{{Name: "slack"}},
+	}
+	tests := []struct {
+		name       string
+		autoClose  bool
+		noLabel    bool
+		dryRun     bool
+		resolved   bool
+		listErr    error
+		want       int
+		wantOpen   int
+		wantLabels int
+		wantErr    bool
+	}{
+		{
+			name:      "success-close-stale",
+			autoClose: true,
+			want:      1,
+			wantOpen:  2,
+		},
+		{
+			name:       "success-label-stale",
+			want:       1,
+			wantOpen:   3,
+			wantLabels: 1,
+		},
+		{
+			name:     "success-skip-already-resolved",
+			resolved: true,
+			want:     0,
+			wantOpen: 3,
+		},
+		{
+			name:     "success-skip-unresolvable",
+			noLabel:  true,
+			want:     0,
+			wantOpen: 3,
+		},
+		{
+			name:      "success-dry-run",
+			autoClose: true,
+			dryRun:    true,
+			want:      1,
+			wantOpen:  3,
+		},
+		{
+			name:     "failure-list-alerts",
+			listErr:  fmt.Errorf("fake error"),
+			wantOpen: 3,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := local.NewClient()
+			resolvedLabel := "resolved"
+			if tt.noLabel {
+				resolvedLabel = ""
+			}
+			rh, err := NewReceiver(c, "default", tt.autoClose, resolvedLabel, nil, DefaultTitleTmpl, DefaultAlertTmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			createAlertIssue(t, c, "active", map[string]string{"alertname": "Active"})
+			createAlertIssue(t, c, "stale", map[string]string{"alertname": "Stale"})
+			// Issues without metadata are never reconciled.
+			if _, err := c.CreateIssue(context.Background(), "default", "manual", "body", nil); err != nil {
+				t.Fatal(err)
+			}
+			if tt.resolved {
+				issues, _ := c.ListOpenIssues(context.Background())
+				for _, issue := range issues {
+					c.LabelIssue(context.Background(), issue, "resolved", true)
+				}
+			}
+			r := NewReconciler(rh, &fakeAlertLister{
+				alerts: []*alertmanager.Alert{active, otherReceiver},
+				err:    tt.listErr,
+			}, tt.dryRun)
+
+			got, err := r.Reconcile(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Reconcile() = %d, want %d", got, tt.want)
+			}
+			issues, _ := c.ListOpenIssues(context.Background())
+			if len(issues) != tt.wantOpen {
+				t.Errorf("got %d open issues, want %d", len(issues), tt.wantOpen)
+			}
+			labels := 0
+			for _, issue := range issues {
+				if issue.GetTitle() == "stale" && hasLabel(issue, "resolved") {
+					labels++
+				}
+			}
+			if !tt.resolved && labels != tt.wantLabels {
+				t.Errorf("got %d resolved stale issues, want %d", labels, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestReconciler_Run(t *testing.T) {
+	c := local.NewClient()
+	rh, err := NewReceiver(c, "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createAlertIssue(t, c, "stale", map[string]string{"alertname": "Stale"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	NewReconciler(rh, &fakeAlertLister{}, false).Run(ctx, time.Millisecond)
+	if issues, _ := c.ListOpenIssues(context.Background()); len(issues) != 0 {
+		t.Errorf("Run() left %d open issues, want 0", len(issues))
+	}
+}
+
+// cancelingAlertLister cancels a context when alerts are listed.
+type cancelingAlertLister struct {
+	cancel context.CancelFunc
+}
+
+func (f *cancelingAlertLister) ListAlerts(ctx context.Context) ([]*alertmanager.Alert, error) {
+	f.cancel()
+	return nil, nil
+}
+
+// ctxClient fails to close issues when the context is canceled, like the
+// clients of real issue trackers.
+type ctxClient struct {
+	*local.Client
+}
+
+func (c ctxClient) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Client.CloseIssue(ctx, issue)
+}
+
+func TestReconciler_Run_canceled(t *testing.T) {
+	c := local.NewClient()
+	rh, err := NewReceiver(ctxClient{c}, "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createAlertIssue(t, c, "stale", map[string]string{"alertname": "Stale"})
+	// The context is canceled during the first reconciliation, which still
+	// resolves the stale issue.
+	ctx, cancel := context.WithCancel(context.Background())
+	NewReconciler(rh, &cancelingAlertLister{cancel: cancel}, false).Run(ctx, time.Millisecond)
+	if issues, _ := c.ListOpenIssues(context.Background()); len(issues) != 0 {
+		t.Errorf("Run() left %d open issues, want 0", len(issues))
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/prometheus/alertmanager/notify/webhook"
+)
+
+// maxRecordingSize is the largest supported line of a recording file.
+const maxRecordingSize = 16 << 20
+
+// Recording is one line of a recording file: a webhook message and the time
+// it was received.
+type Recording struct {
+	Received time.Time       `json:"received"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// Recorder writes every accepted webhook message as a JSON line, so it can be
+// replayed with ReceiverHandler.Replay.
+type Recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewRecorder creates a Recorder that writes to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Record writes the message body received at the given time.
+func (r *Recorder) Record(received time.Time, body []byte) error {
+	line, err := json.Marshal(&Recording{Received: received, Message: body})
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+// Replay processes the recorded webhook messages read from r in order, and
+// returns the number of messages processed. The time between messages is
+// preserved when speed is 1, compressed when speed is greater than 1, and
+// ignored when speed is 0. Messages that fail are logged and skipped. Replay
+// stops waiting for the next message when ctx is canceled.
+func (rh *ReceiverHandler) Replay(ctx context.Context, r io.Reader, speed float64) (int, error) {
+	return rh.replay(ctx, r, speed, time.After)
+}
+
+func (rh *ReceiverHandler) replay(ctx context.Context, r io.Reader, speed float64, after func(time.Duration) <-chan time.Time) (int, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxRecordingSize)
+	var last time.Time
+	n := 0
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		rec := &Recording{}
+		if err := json.Unmarshal(s.Bytes(), rec); err != nil {
+			return n, fmt.Errorf("line %d: %s", line, err)
+		}
+		msg := &webhook.Message{}
+		if err := json.Unmarshal(rec.Message, msg); err != nil {
+			return n, fmt.Errorf("line %d: %s", line, err)
+		}
+		if msg.Data == nil {
+			return n, fmt.Errorf("line %d: message has no alert data", line)
+		}
+		if speed > 0 && !last.IsZero() && rec.Received.After(last) {
+			select {
+			case <-ctx.Done():
+				return n, ctx.Err()
+			case <-after(time.Duration(float64(rec.Received.Sub(last)) / speed)):
+			}
+		}
+		last = rec.Received
+		mctx, _ := logging.WithRequestID(ctx)
+		logger := logging.FromContext(mctx).With("alert", id(msg), "line", line)
+		mctx = logging.WithLogger(mctx, logger)
+		logger.Info("Replaying alert", "status", msg.Status)
+		res, err := rh.processAlert(mctx, msg)
+		if err != nil {
+			logger.Error("Failed to replay alert", "reason", res.Reason, "error", err)
+		} else {
+			logger.Info("Replayed alert", "action", res.Action, "issue", res.Issue)
+		}
+		n++
+	}
+	return n, s.Err()
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+)
+
+func TestReceiverHandler_record(t *testing.T) {
+	rh, err := NewReceiver(local.NewClient(), "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	rh.Recorder = NewRecorder(&buf)
+	for _, status := range []string{"firing", "resolved"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/receiver",
+			marshalWebhookMessage(createWebhookMessage("DiskRunningFull", status, "")))
+		rw := httptest.NewRecorder()
+		rh.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP() code = %d, want %d", rw.Code, http.StatusOK)
+		}
+	}
+	// Malformed messages are not recorded.
+	rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/receiver", strings.NewReader("{")))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"status":"firing"`) || !strings.Contains(lines[1], `"status":"resolved"`) {
+		t.Errorf("recorded %q, want firing and resolved messages", lines)
+	}
+}
+
+func TestReceiverHandler_replay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	start := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, status := range []string{"firing", "firing", "resolved"} {
+		b := marshalWebhookMessage(createWebhookMessage("DiskRunningFull", status, "")).Bytes()
+		if err := rec.Record(start.Add(time.Duration(i)*time.Minute), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recording := buf.String()
+
+	tests := []struct {
+		name      string
+		input     string
+		speed     float64
+		want      int
+		wantSleep []time.Duration
+		wantOpen  int
+		wantErr   bool
+	}{
+		{
+			name:      "success-preserve-timing",
+			input:     recording,
+			speed:     1,
+			want:      3,
+			wantSleep: []time.Duration{time.Minute, time.Minute},
+		},
+		{
+			name:      "success-compress-timing",
+			input:     recording,
+			speed:     60,
+			want:      3,
+			wantSleep: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:  "success-no-timing",
+			input: recording,
+			want:  3,
+		},
+		{
+			name:     "success-partial",
+			input:    strings.SplitAfter(recording, "\n")[0] + "\n",
+			want:     1,
+			wantOpen: 1,
+		},
+		{
+			name:     "failure-malformed-line",
+			input:    strings.SplitAfter(recording, "\n")[0] + "{\n",
+			want:     1,
+			wantOpen: 1,
+			wantErr:  true,
+		},
+		{
+			name:    "failure-no-data",
+			input:   `{"message": {}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := local.NewClient()
+			rh, err := NewReceiver(c, "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var slept []time.Duration
+			got, err := rh.replay(context.Background(), strings.NewReader(tt.input), tt.speed, func(d time.Duration) <-chan time.Time {
+				slept = append(slept, d)
+				c := make(chan time.Time, 1)
+				c <- time.Now()
+				return c
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("replay() = %d, want %d", got, tt.want)
+			}
+			if len(slept) != len(tt.wantSleep) {
+				t.Fatalf("replay() slept %v, want %v", slept, tt.wantSleep)
+			}
+			for i := range slept {
+				if slept[i] != tt.wantSleep[i] {
+					t.Errorf("replay() slept %v, want %v", slept, tt.wantSleep)
+				}
+			}
+			if open, _ := c.ListOpenIssues(context.Background()); len(open) != tt.wantOpen {
+				t.Errorf("got %d open issues, want %d", len(open), tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestReceiverHandler_Replay_canceled(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	start := time.Now()
+	for i, status := range []string{"firing", "resolved"} {
+		b := marshalWebhookMessage(createWebhookMessage("DiskRunningFull", status, "")).Bytes()
+		if err := rec.Record(start.Add(time.Duration(i)*time.Hour), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rh, err := NewReceiver(local.NewClient(), "default", true, "", nil, DefaultTitleTmpl, DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The hour between the messages is not waited for.
+	got, err := rh.Replay(ctx, &buf, 1)
+	if err != context.Canceled || got != 1 {
+		t.Errorf("Replay() = %d, %v; want 1, %v", got, err, context.Canceled)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package alerts
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/go-github/github"
+)
+
+// Actions taken for a webhook message.
+const (
+	ActionNone     = "none"
+	ActionCreated  = "created"
+	ActionReopened = "reopened"
+	ActionLabeled  = "labeled"
+	ActionClosed   = "closed"
+)
+
+// Reasons why a webhook message failed.
+const (
+	ReasonMethodNotAllowed = "method_not_allowed"
+	ReasonReadFailed       = "read_failed"
+	ReasonInvalidMessage   = "invalid_message"
+	ReasonListFailed       = "list_failed"
+	ReasonGetFailed        = "get_failed"
+	ReasonTemplateFailed   = "template_failed"
+	ReasonCreateFailed     = "create_failed"
+	ReasonReopenFailed     = "reopen_failed"
+	ReasonCommentFailed    = "comment_failed"
+	ReasonLabelFailed      = "label_failed"
+	ReasonCloseFailed      = "close_failed"
+)
+
+// Result describes how the ReceiverHandler processed a webhook message. It is
+// the JSON response of the ReceiverHandler. Alertmanager only uses the status
+// code of the response.
+type Result struct {
+	// Action is the change made to the issue of the alert, e.g. ActionCreated,
+	// or ActionNone if the issue was already up to date or does not exist.
+	Action string `json:"action"`
+	// Repo is the target repository of the alert.
+	Repo  string `json:"repo,omitempty"`
+	Title string `json:"title,omitempty"`
+	// Issue and URL identify the issue of the alert, if any.
+	Issue int    `json:"issue,omitempty"`
+	URL   string `json:"url,omitempty"`
+	// Reason and Error describe why processing the message failed.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// setIssue records the issue of the alert.
+func (r *Result) setIssue(issue *github.Issue) {
+	r.Issue = issue.GetNumber()
+	r.URL = issue.GetHTMLURL()
+}
+
+// fail records the reason of a failure, and returns err.
+func (r *Result) fail(reason string, err error) error {
+	r.Reason = reason
+	return err
+}
+
+// writeResult writes the result as the JSON response with the given status.
+func writeResult(rw http.ResponseWriter, status int, res *Result) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(res); err != nil {
+		slog.Error("Failed to write result", "error", err)
+	}
+}
@@ -17,8 +17,10 @@ package alerts
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
 	"github.com/prometheus/alertmanager/notify/webhook"
 )
 
@@ -80,18 +82,24 @@ func id(msg *webhook.Message) string {
 }
 
 // formatTitle constructs an issue title from a webhook message.
-func (rh *ReceiverHandler) formatTitle(msg *webhook.Message) (string, error) {
+func (rh *ReceiverHandler) formatTitle(ctx context.Context, msg *webhook.Message) (string, error) {
+	_, span := tracing.Start(ctx, "template.title")
 	var title bytes.Buffer
-	if err := rh.titleTmpl.Execute(&title, msg); err != nil {
+	err := rh.titleTmpl.Execute(&title, msg)
+	tracing.End(span, err)
+	if err != nil {
 		return "", err
 	}
 	return title.String(), nil
 }
 
 // formatIssueBody constructs an issue body from a webhook message.
-func (rh *ReceiverHandler) formatIssueBody(msg *webhook.Message) (string, error) {
+func (rh *ReceiverHandler) formatIssueBody(ctx context.Context, msg *webhook.Message) (string, error) {
+	_, span := tracing.Start(ctx, "template.body")
 	var buf bytes.Buffer
-	if err := rh.alertTmpl.Execute(&buf, msg); err != nil {
+	err := rh.alertTmpl.Execute(&buf, msg)
+	tracing.End(span, err)
+	if err != nil {
 		return "", err
 	}
 	return buf.String(), nil
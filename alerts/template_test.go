@@ -16,6 +16,7 @@
 package alerts
 
 import (
+	"context"
 	"testing"
 
 	"github.com/prometheus/alertmanager/notify/webhook"
@@ -75,7 +76,7 @@ func Test_formatIssueBody(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			got, err := rh.formatIssueBody(&msg)
+			got, err := rh.formatIssueBody(context.Background(), &msg)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("formatIssueBody() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -135,7 +136,7 @@ func TestReceiverHandler_formatTitle(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			got, err := rh.formatTitle(&msg)
+			got, err := rh.formatTitle(context.Background(), &msg)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ReceiverHandler.formatTitle() error = %v, wantErr %v", err, tt.wantErr)
 				return
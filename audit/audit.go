@@ -0,0 +1,243 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package audit writes an append-only log of the issue changes made for alert
+// notifications, ChatOps commands and admin commands, and reads it back.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions on issues.
+const (
+	ActionCreate  = "create"
+	ActionLabel   = "label"
+	ActionUnlabel = "unlabel"
+	ActionClose   = "close"
+	ActionReopen  = "reopen"
+	ActionRetitle = "retitle"
+)
+
+// Outcomes of actions.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// openFile opens log files. It is replaced in tests.
+var openFile = os.OpenFile
+
+// DefaultMaxSize is the default size of an audit log file before it is
+// rotated.
+const DefaultMaxSize = 10 << 20
+
+// Record describes one issue change and the notification that caused it, if
+// any.
+type Record struct {
+	Time time.Time `json:"time"`
+	// RequestID is the ID of the webhook request, or of the reconciliation run.
+	RequestID string `json:"requestId,omitempty"`
+	// GroupKey and Status identify the alert group and its status. They are
+	// empty for changes made by commands.
+	GroupKey string `json:"groupKey"`
+	Status   string `json:"status"`
+	// Action is the change made to the issue, e.g. ActionCreate.
+	Action string `json:"action"`
+	Repo   string `json:"repo"`
+	// Issue is the issue number, or zero if no issue was created.
+	Issue int    `json:"issue,omitempty"`
+	Label string `json:"label,omitempty"`
+	// Title is the new title of a retitled issue.
+	Title string `json:"title,omitempty"`
+	// Outcome is OutcomeOK, or OutcomeError with the Error.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Log appends records as JSON lines to a file. When the file would grow
+// beyond its maximum size, it is renamed with the suffix ".1", older files
+// are renamed to ".2" and so on, and a new file is started. Log is safe for
+// concurrent use.
+type Log struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	// mu protects f and size. f is nil if the log file could not be reopened
+	// after a rotation.
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// Open opens the audit log at path for appending. Files are rotated at
+// maxSize bytes, and at most maxBackups rotated files are kept.
+func Open(path string, maxSize int64, maxBackups int) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write appends the record to the log.
+func (l *Log) Write(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rotateErr error
+	if l.f != nil && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		// A failed rotation still appends the record to the current file.
+		rotateErr = l.rotate()
+	}
+	if l.f == nil {
+		// Retry opening the log file, so that the log recovers once it can.
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// open opens the current log file.
+func (l *Log) open() error {
+	f, err := openFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the current log file to the first backup, shifting older
+// backups and dropping the oldest, and opens a new log file. The log file is
+// reopened even if it could not be renamed, so that the log stays usable. If
+// it cannot be reopened, f is nil.
+func (l *Log) rotate() error {
+	err := l.f.Close()
+	l.f = nil
+	if err == nil {
+		err = l.shift()
+	}
+	if openErr := l.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// shift renames the closed log file and its backups.
+func (l *Log) shift() error {
+	if l.maxBackups <= 0 {
+		return os.Remove(l.path)
+	}
+	for i := l.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(backup(l.path, i), backup(l.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.path, backup(l.path, 1))
+}
+
+// backup returns the name of the i-th rotated file of the log at path.
+func backup(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// Files returns the existing files of the log at path, oldest first.
+func Files(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup(path, i)); err != nil {
+			break
+		}
+		files = append([]string{backup(path, i)}, files...)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// Filter selects audit records. Empty fields match all records.
+type Filter struct {
+	// Repo and Issue select the records of one issue.
+	Repo  string
+	Issue int
+	// Alert selects the records of alert groups whose group key contains it,
+	// e.g. an alertname.
+	Alert string
+}
+
+// Match reports whether the record matches the filter.
+func (f *Filter) Match(r *Record) bool {
+	if f.Repo != "" && r.Repo != f.Repo {
+		return false
+	}
+	if f.Issue != 0 && r.Issue != f.Issue {
+		return false
+	}
+	return f.Alert == "" || strings.Contains(r.GroupKey, f.Alert)
+}
+
+// Read reads the records from r and returns those that match the filter.
+func Read(r io.Reader, f *Filter) ([]*Record, error) {
+	var records []*Record
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		rec := &Record{}
+		if err := json.Unmarshal(s.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if f.Match(rec) {
+			records = append(records, rec)
+		}
+	}
+	return records, s.Err()
+}
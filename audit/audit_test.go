@@ -0,0 +1,170 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// Every file holds two records.
+	l, err := Open(path, 300, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 7; i++ {
+		r := &Record{GroupKey: `{}:{alertname="DiskFull"}`, Status: "firing", Action: ActionCreate,
+			Repo: "repo", Issue: i, Outcome: OutcomeOK}
+		if i%2 == 0 {
+			r.GroupKey = `{}:{alertname="CPUHigh"}`
+		}
+		if err := l.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest file was dropped, and the others are read oldest first.
+	files := Files(path)
+	if want := []string{path + ".2", path + ".1", path}; strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Fatalf("Files() = %v, want %v", files, want)
+	}
+	var issues []int
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := Read(f, &Filter{Alert: "DiskFull"})
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			issues = append(issues, r.Issue)
+		}
+	}
+	if got := len(issues); got != 3 || issues[0] != 3 || issues[2] != 7 {
+		t.Errorf("got DiskFull issues %v, want [3 5 7]", issues)
+	}
+}
+
+func TestLog_rotateFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r := &Record{Action: ActionCreate, Repo: "repo", Outcome: OutcomeOK}
+	if err := l.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	// The log file cannot be renamed over a directory.
+	if err := os.Mkdir(path+".1", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(r); err == nil {
+		t.Error("Write() got nil error for failed rotation")
+	}
+	// The log still appends records, and rotates once it can.
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Write(r); err != nil {
+		t.Fatalf("Write() error = %v after failed rotation", err)
+	}
+	f, err := os.Open(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := Read(f, &Filter{})
+	if err != nil || len(records) != 2 {
+		t.Errorf("Read() of rotated file = %d records, %v; want 2 records", len(records), err)
+	}
+}
+
+func TestLog_reopenFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r := &Record{Action: ActionCreate, Repo: "repo", Outcome: OutcomeOK}
+	if err := l.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	// The log file is rotated, but a new file cannot be opened.
+	defer func() { openFile = os.OpenFile }()
+	openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		return nil, os.ErrPermission
+	}
+	for i := 0; i < 2; i++ {
+		if err := l.Write(r); err == nil {
+			t.Errorf("Write() got nil error while the log file cannot be opened")
+		}
+	}
+	// The log recovers once the file can be opened.
+	openFile = os.OpenFile
+	if err := l.Write(r); err != nil {
+		t.Fatalf("Write() error = %v after the log file can be opened", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := Read(f, &Filter{})
+	if err != nil || len(records) != 1 {
+		t.Errorf("Read() of new file = %d records, %v; want 1 record", len(records), err)
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	r := &Record{GroupKey: `{}:{alertname="DiskFull"}`, Repo: "repo", Issue: 12}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "all", want: true},
+		{name: "issue", filter: Filter{Repo: "repo", Issue: 12}, want: true},
+		{name: "other-issue", filter: Filter{Issue: 13}},
+		{name: "other-repo", filter: Filter{Repo: "other", Issue: 12}},
+		{name: "alert", filter: Filter{Alert: "DiskFull"}, want: true},
+		{name: "other-alert", filter: Filter{Alert: "CPUHigh"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(r); got != tt.want {
+				t.Errorf("Filter.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRead_invalid(t *testing.T) {
+	if _, err := Read(strings.NewReader("{}\nnot json\n"), &Filter{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Read() error = %v, want error on line 2", err)
+	}
+}
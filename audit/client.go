@@ -0,0 +1,143 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+)
+
+// Client changes issues using the wrapped client, and records every change in
+// the Log, with the alert group of the context, if any. Client implements the
+// optional client interfaces of the alerts package, and returns
+// errors.ErrUnsupported when the wrapped client does not.
+type Client struct {
+	client alerts.ReceiverClient
+	log    *Log
+}
+
+// NewClient creates a Client that records the changes made by client in log.
+func NewClient(client alerts.ReceiverClient, log *Log) *Client {
+	return &Client{client: client, log: log}
+}
+
+// ListOpenIssues returns the open issues of the wrapped client.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	return c.client.ListOpenIssues(ctx)
+}
+
+// CreateIssue creates the issue and records it. The record has no issue
+// number if the issue was not created.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	issue, err := c.client.CreateIssue(ctx, repo, title, body, extra)
+	c.record(ctx, &Record{Action: ActionCreate, Repo: repo, Issue: issue.GetNumber()}, err)
+	return issue, err
+}
+
+// LabelIssue adds or removes the label and records it. Adding or removing an
+// empty label changes nothing, and is not recorded.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	err := c.client.LabelIssue(ctx, issue, label, add)
+	if label == "" {
+		return err
+	}
+	r := &Record{Action: ActionLabel, Label: label}
+	if !add {
+		r.Action = ActionUnlabel
+	}
+	c.recordIssue(ctx, r, issue, err)
+	return err
+}
+
+// CloseIssue closes the issue and records it.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	closed, err := c.client.CloseIssue(ctx, issue)
+	c.recordIssue(ctx, &Record{Action: ActionClose}, issue, err)
+	return closed, err
+}
+
+// GetIssue returns the current issue from the wrapped client.
+func (c *Client) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	getter, ok := c.client.(alerts.IssueGetter)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	return getter.GetIssue(ctx, issue)
+}
+
+// ReopenIssue reopens the issue and records it.
+func (c *Client) ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	reopener, ok := c.client.(alerts.IssueReopener)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	reopened, err := reopener.ReopenIssue(ctx, issue)
+	c.recordIssue(ctx, &Record{Action: ActionReopen}, issue, err)
+	return reopened, err
+}
+
+// CommentIssue comments on the issue using the wrapped client. Comments do
+// not change the issue, and are not recorded.
+func (c *Client) CommentIssue(ctx context.Context, issue *github.Issue, body string) error {
+	commenter, ok := c.client.(alerts.IssueCommenter)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return commenter.CommentIssue(ctx, issue, body)
+}
+
+// RetitleIssue changes the issue title and records it.
+func (c *Client) RetitleIssue(ctx context.Context, issue *github.Issue, title string) (*github.Issue, error) {
+	retitler, ok := c.client.(alerts.IssueRetitler)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	retitled, err := retitler.RetitleIssue(ctx, issue, title)
+	c.recordIssue(ctx, &Record{Action: ActionRetitle, Title: title}, issue, err)
+	return retitled, err
+}
+
+// recordIssue records the change of an existing issue.
+func (c *Client) recordIssue(ctx context.Context, r *Record, issue *github.Issue, err error) {
+	r.Issue = issue.GetNumber()
+	if issue.GetRepositoryURL() != "" {
+		r.Repo = path.Base(issue.GetRepositoryURL())
+	}
+	c.record(ctx, r, err)
+}
+
+// record completes the record of a change with the outcome, the request and
+// the alert group of ctx, and writes it to the log. Write errors are logged,
+// since the change was made regardless.
+func (c *Client) record(ctx context.Context, r *Record, err error) {
+	g := alerts.AlertGroupFromContext(ctx)
+	r.Time = time.Now().UTC()
+	r.RequestID = logging.RequestID(ctx)
+	r.GroupKey, r.Status = g.Key, g.Status
+	r.Outcome = OutcomeOK
+	if err != nil {
+		r.Outcome, r.Error = OutcomeError, err.Error()
+	}
+	if err := c.log.Write(r); err != nil {
+		logging.FromContext(ctx).Error("Failed to write audit record", "error", err)
+	}
+}
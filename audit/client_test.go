@@ -0,0 +1,176 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/audit"
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
+)
+
+// readRecords closes the log at path and returns its records without their
+// times.
+func readRecords(t *testing.T, l *audit.Log, path string) []audit.Record {
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := audit.Read(f, &audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []audit.Record
+	for _, r := range records {
+		if r.Time.IsZero() {
+			t.Errorf("record has no time: %+v", r)
+		}
+		r.Time = time.Time{}
+		got = append(got, *r)
+	}
+	return got
+}
+
+func TestClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(path, audit.DefaultMaxSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := audit.NewClient(local.NewClient(), l)
+
+	ctx, id := logging.WithRequestID(context.Background())
+	alertCtx := alerts.WithAlertGroup(ctx, alerts.AlertGroup{Key: `{}:{alertname="DiskFull"}`, Status: "firing"})
+	issue, err := c.CreateIssue(alertCtx, "repo", "DiskFull", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty label changes nothing.
+	if err := c.LabelIssue(alertCtx, issue, "", false); err != nil {
+		t.Fatal(err)
+	}
+	// Commands change issues without an alert group.
+	if err := c.LabelIssue(ctx, issue, "acknowledged", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CloseIssue(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReopenIssue(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RetitleIssue(ctx, issue, "DiskFull on sda3"); err != nil {
+		t.Fatal(err)
+	}
+	missing := &github.Issue{Number: github.Int(7), Title: github.String("missing")}
+	if _, err := c.CloseIssue(ctx, missing); err == nil {
+		t.Error("CloseIssue() of missing issue got nil error")
+	}
+	// Comments are not recorded, and the local client cannot make them.
+	if err := c.CommentIssue(ctx, issue, "comment"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("CommentIssue() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if current, err := c.GetIssue(ctx, issue); err != nil || current.GetTitle() != "DiskFull on sda3" {
+		t.Errorf("GetIssue() = %v, %v; want retitled issue", current, err)
+	}
+
+	got := readRecords(t, l, path)
+	want := []audit.Record{
+		{RequestID: id, GroupKey: `{}:{alertname="DiskFull"}`, Status: "firing", Action: audit.ActionCreate,
+			Repo: "repo", Issue: 1, Outcome: audit.OutcomeOK},
+		{RequestID: id, Action: audit.ActionLabel, Repo: "repo", Issue: 1, Label: "acknowledged", Outcome: audit.OutcomeOK},
+		{RequestID: id, Action: audit.ActionClose, Repo: "repo", Issue: 1, Outcome: audit.OutcomeOK},
+		{RequestID: id, Action: audit.ActionReopen, Repo: "repo", Issue: 1, Outcome: audit.OutcomeOK},
+		{RequestID: id, Action: audit.ActionRetitle, Repo: "repo", Issue: 1, Title: "DiskFull on sda3", Outcome: audit.OutcomeOK},
+		{RequestID: id, Action: audit.ActionClose, Issue: 7, Outcome: audit.OutcomeError, Error: "Unknown issue:missing"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// postAlert sends a webhook message of the alert with the given status.
+func postAlert(rh *alerts.ReceiverHandler, status string) {
+	msg := &webhook.Message{
+		Data: &template.Data{
+			Receiver:     "webhook",
+			Status:       status,
+			Alerts:       template.Alerts{{Status: status, Labels: template.KV{"alertname": "DiskFull"}}},
+			GroupLabels:  template.KV{"alertname": "DiskFull"},
+			CommonLabels: template.KV{"alertname": "DiskFull"},
+		},
+		Version:  "4",
+		GroupKey: `{}:{alertname="DiskFull"}`,
+	}
+	b, _ := json.Marshal(msg)
+	rh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/receiver", bytes.NewReader(b)))
+}
+
+func TestClient_receiver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(path, audit.DefaultMaxSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := alerts.NewReceiver(audit.NewClient(local.NewClient(), l), "repo", true, "resolved", nil,
+		alerts.DefaultTitleTmpl, alerts.DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postAlert(rh, "firing")
+	postAlert(rh, "resolved")
+
+	got := readRecords(t, l, path)
+	want := []audit.Record{
+		{Status: "firing", Action: audit.ActionCreate, Issue: 1},
+		{Status: "resolved", Action: audit.ActionLabel, Issue: 1, Label: "resolved"},
+		{Status: "resolved", Action: audit.ActionClose, Issue: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].RequestID == "" {
+			t.Errorf("record %d has no request ID", i)
+		}
+		w.RequestID, w.GroupKey, w.Repo, w.Outcome = got[i].RequestID, `{}:{alertname="DiskFull"}`, "repo", audit.OutcomeOK
+		if got[i] != w {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], w)
+		}
+	}
+}
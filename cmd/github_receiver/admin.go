@@ -0,0 +1,154 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/m-lab/go/flagx"
+)
+
+const issuesUsage = `
+USAGE
+  github_receiver [flags] issues list [filters]
+  github_receiver [flags] issues close [filters]
+  github_receiver [flags] issues label [filters] <label>
+  github_receiver [flags] issues unlabel [filters] <label>
+
+  Lists or changes the open alert issues found by the receiver, i.e. the
+  issues with the -alertlabel label. The filters select a subset of them.
+  Changes are confirmed interactively unless -yes is given.
+
+FILTERS
+`
+
+// issueVerbs contains the verb and past tense of every issue change.
+var issueVerbs = map[string][2]string{
+	"close":   {"Close", "Closed"},
+	"label":   {"Label", "Labeled"},
+	"unlabel": {"Unlabel", "Unlabeled"},
+}
+
+// runIssues runs the issues command with the given arguments. Confirmations
+// are read from in, and results are written to out.
+func runIssues(ctx context.Context, client alerts.ReceiverClient, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("issues requires one of: list, close, label, unlabel")
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("issues "+action, flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprint(out, issuesUsage)
+		fs.PrintDefaults()
+	}
+	title := fs.String("title", "", "Only issues with titles matching this regular expression.")
+	repo := fs.String("repo", "", "Only issues in this repository.")
+	olderThan := fs.Duration("older-than", 0, "Only issues created at least this long ago.")
+	labels := flagx.StringArray{}
+	fs.Var(&labels, "label", "Only issues with this label. May be repeated.")
+	yes := fs.Bool("yes", false, "Change issues without confirmation.")
+	dryRun := fs.Bool("dry-run", false, "Only list the issues that would be changed.")
+	if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var label string
+	switch action {
+	case "list", "close":
+		if fs.NArg() != 0 {
+			return fmt.Errorf("issues %s takes no arguments", action)
+		}
+	case "label", "unlabel":
+		if fs.NArg() != 1 || fs.Arg(0) == "" {
+			return fmt.Errorf("issues %s requires a label", action)
+		}
+		label = fs.Arg(0)
+	default:
+		return fmt.Errorf("unknown issues command %q", action)
+	}
+
+	f := &issues.Filter{Repo: *repo, MinAge: *olderThan, Labels: labels, Now: time.Now()}
+	if *title != "" {
+		var err error
+		if f.Title, err = regexp.Compile(*title); err != nil {
+			return err
+		}
+	}
+	open, err := client.ListOpenIssues(ctx)
+	if err != nil {
+		return err
+	}
+	var selected []*github.Issue
+	for _, issue := range open {
+		if f.Match(issue) {
+			selected = append(selected, issue)
+			fmt.Fprintf(out, "#%d\t%s\t%s\t%s\n", issue.GetNumber(),
+				issues.RepoName(issue), issue.GetCreatedAt().Format(time.RFC3339), issue.GetTitle())
+		}
+	}
+	if action == "list" || len(selected) == 0 {
+		fmt.Fprintf(out, "%d issues\n", len(selected))
+		return nil
+	}
+	if *dryRun {
+		fmt.Fprintf(out, "Dry run: would %s %d issues\n", action, len(selected))
+		return nil
+	}
+	if !*yes && !confirm(in, out, fmt.Sprintf("%s %d issues?", issueVerbs[action][0], len(selected))) {
+		return fmt.Errorf("canceled")
+	}
+	failed := 0
+	for _, issue := range selected {
+		switch action {
+		case "close":
+			_, err = client.CloseIssue(ctx, issue)
+		case "label":
+			err = client.LabelIssue(ctx, issue, label, true)
+		case "unlabel":
+			err = client.LabelIssue(ctx, issue, label, false)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "Failed to %s #%d: %s\n", action, issue.GetNumber(), err)
+			failed++
+		}
+	}
+	fmt.Fprintf(out, "%s %d issues\n", issueVerbs[action][1], len(selected)-failed)
+	if failed > 0 {
+		return fmt.Errorf("failed to %s %d issues", action, failed)
+	}
+	return nil
+}
+
+// confirm asks the question and reports whether the answer is yes.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+)
+
+// newAdminClient returns a local client with three open issues, created at
+// different times.
+func newAdminClient(t *testing.T) *local.Client {
+	c := local.NewClient()
+	for _, i := range []struct {
+		repo, title string
+		labels      []string
+	}{
+		{"repo-a", "DiskFull", []string{"storage"}},
+		{"repo-a", "DiskSlow", nil},
+		{"repo-b", "CPUHigh", []string{"storage"}},
+	} {
+		if _, err := c.CreateIssue(context.Background(), i.repo, i.title, "body", i.labels); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func Test_runIssues(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		input      string
+		wantErr    bool
+		wantOpen   int
+		wantLabels int
+		wantOutput string
+	}{
+		{
+			name:       "list-all",
+			args:       []string{"list"},
+			wantOpen:   3,
+			wantLabels: 2,
+			wantOutput: "3 issues",
+		},
+		{
+			name:       "list-filtered",
+			args:       []string{"list", "-title", "^Disk", "-repo", "repo-a", "-label", "storage"},
+			wantOpen:   3,
+			wantLabels: 2,
+			wantOutput: "1 issues",
+		},
+		{
+			name:       "close-confirmed",
+			args:       []string{"close", "-title", "^Disk"},
+			input:      "y\n",
+			wantOpen:   1,
+			wantLabels: 1,
+			wantOutput: "Closed 2 issues",
+		},
+		{
+			name:       "close-canceled",
+			args:       []string{"close"},
+			input:      "n\n",
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+		{
+			name:       "close-dry-run",
+			args:       []string{"close", "-dry-run"},
+			wantOpen:   3,
+			wantLabels: 2,
+			wantOutput: "Dry run: would close 3 issues",
+		},
+		{
+			name:       "close-none",
+			args:       []string{"close", "-older-than", "1h"},
+			wantOpen:   3,
+			wantLabels: 2,
+			wantOutput: "0 issues",
+		},
+		{
+			name:       "label-yes",
+			args:       []string{"label", "-yes", "storage"},
+			wantOpen:   3,
+			wantLabels: 3,
+			wantOutput: "Labeled 3 issues",
+		},
+		{
+			name:       "unlabel-yes",
+			args:       []string{"unlabel", "-yes", "-repo", "repo-b", "storage"},
+			wantOpen:   3,
+			wantLabels: 1,
+			wantOutput: "Unlabeled 1 issues",
+		},
+		{
+			name:       "help",
+			args:       []string{"list", "-h"},
+			wantOpen:   3,
+			wantLabels: 2,
+			wantOutput: "USAGE",
+		},
+		{
+			name:       "failure-missing-action",
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+		{
+			name:       "failure-unknown-action",
+			args:       []string{"delete"},
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+		{
+			name:       "failure-missing-label",
+			args:       []string{"label", "-yes"},
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+		{
+			name:       "failure-extra-argument",
+			args:       []string{"close", "storage"},
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+		{
+			name:       "failure-bad-title",
+			args:       []string{"list", "-title", "("},
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+		{
+			name:       "failure-bad-flag",
+			args:       []string{"list", "-unknown"},
+			wantErr:    true,
+			wantOpen:   3,
+			wantLabels: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAdminClient(t)
+			var out bytes.Buffer
+			err := runIssues(context.Background(), c, tt.args, strings.NewReader(tt.input), &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runIssues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(out.String(), tt.wantOutput) {
+				t.Errorf("runIssues() output = %q, want %q", out.String(), tt.wantOutput)
+			}
+			open, _ := c.ListOpenIssues(context.Background())
+			if len(open) != tt.wantOpen {
+				t.Errorf("got %d open issues, want %d", len(open), tt.wantOpen)
+			}
+			labels := 0
+			for _, issue := range open {
+				labels += len(issue.Labels)
+			}
+			if labels != tt.wantLabels {
+				t.Errorf("got %d labels on open issues, want %d", labels, tt.wantLabels)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/audit"
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+)
+
+const auditUsage = `
+USAGE
+  github_receiver -audit.file <file> audit [filters]
+
+  Lists the issue changes recorded in the -audit.file audit log, including
+  rotated files, oldest first. The filters select a subset of them.
+
+FILTERS
+`
+
+// runAudit runs the audit command with the given arguments on the audit log
+// at path, and writes the matching records to out.
+func runAudit(path string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprint(out, auditUsage)
+		fs.PrintDefaults()
+	}
+	f := &audit.Filter{}
+	fs.IntVar(&f.Issue, "issue", 0, "Only changes of the issue with this number.")
+	fs.StringVar(&f.Repo, "repo", "", "Only changes of issues in this repository.")
+	fs.StringVar(&f.Alert, "alert", "", "Only changes for alert groups whose group key contains this text, e.g. an alertname.")
+	asJSON := fs.Bool("json", false, "Write the records as JSON lines.")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("audit takes no arguments")
+	}
+	if path == "" {
+		return fmt.Errorf("audit requires -audit.file")
+	}
+
+	files := audit.Files(path)
+	if len(files) == 0 {
+		return fmt.Errorf("no audit log found at %s", path)
+	}
+	n := 0
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		records, err := audit.Read(file, f)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		for _, r := range records {
+			if *asJSON {
+				b, _ := json.Marshal(r)
+				fmt.Fprintf(out, "%s\n", b)
+			} else {
+				outcome := r.Outcome
+				if r.Error != "" {
+					outcome += ": " + r.Error
+				}
+				detail := r.Label
+				if r.Title != "" {
+					detail = r.Title
+				}
+				fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s#%d\t%s\t%s\t%s\n", r.Time.Format(time.RFC3339),
+					r.RequestID, r.Status, r.Action, r.Repo, r.Issue, detail, outcome, r.GroupKey)
+			}
+		}
+		n += len(records)
+	}
+	if !*asJSON {
+		fmt.Fprintf(out, "%d records\n", n)
+	}
+	return nil
+}
+
+// auditedCommandClient records the issue changes of ChatOps commands in the
+// audit log.
+type auditedCommandClient struct {
+	*issues.Client
+	audit *audit.Client
+}
+
+// LabelIssue adds or removes the label, and records it.
+func (c *auditedCommandClient) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	return c.audit.LabelIssue(ctx, issue, label, add)
+}
+
+// CloseIssue closes the issue, and records it.
+func (c *auditedCommandClient) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	return c.audit.CloseIssue(ctx, issue)
+}
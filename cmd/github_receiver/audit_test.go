@@ -0,0 +1,73 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/alertmanager-github-receiver/audit"
+)
+
+func Test_runAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(path, audit.DefaultMaxSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*audit.Record{
+		{GroupKey: `{}:{alertname="DiskFull"}`, Status: "firing", Action: audit.ActionCreate, Repo: "repo-a", Issue: 1, Outcome: audit.OutcomeOK},
+		{GroupKey: `{}:{alertname="CPUHigh"}`, Status: "firing", Action: audit.ActionCreate, Repo: "repo-a", Issue: 2, Outcome: audit.OutcomeOK},
+		{GroupKey: `{}:{alertname="DiskFull"}`, Status: "resolved", Action: audit.ActionClose, Repo: "repo-a", Issue: 1, Outcome: audit.OutcomeError, Error: "timeout"},
+	} {
+		if err := l.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		args       []string
+		wantErr    bool
+		wantOutput []string
+	}{
+		{name: "all", path: path, wantOutput: []string{"3 records"}},
+		{name: "issue", path: path, args: []string{"-repo", "repo-a", "-issue", "1"},
+			wantOutput: []string{"close\trepo-a#1\t\terror: timeout", "2 records"}},
+		{name: "alert", path: path, args: []string{"-alert", "CPUHigh"}, wantOutput: []string{"repo-a#2", "1 records"}},
+		{name: "json", path: path, args: []string{"-json", "-issue", "2"}, wantOutput: []string{`"issue":2`}},
+		{name: "no-file", wantErr: true},
+		{name: "missing-file", path: path + ".missing", wantErr: true},
+		{name: "arguments", path: path, args: []string{"extra"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runAudit(tt.path, tt.args, &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runAudit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("runAudit() output = %q, want %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -21,14 +21,30 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
 	"github.com/m-lab/go/httpx"
 	"github.com/m-lab/go/rtx"
 
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
 	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/audit"
+	"github.com/m-lab/alertmanager-github-receiver/events"
 	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/m-lab/alertmanager-github-receiver/issues/dryrun"
+	"github.com/m-lab/alertmanager-github-receiver/issues/fanout"
+	"github.com/m-lab/alertmanager-github-receiver/issues/gitea"
+	"github.com/m-lab/alertmanager-github-receiver/issues/gitlab"
+	"github.com/m-lab/alertmanager-github-receiver/issues/jira"
 	"github.com/m-lab/alertmanager-github-receiver/issues/local"
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/prometheusx"
@@ -44,12 +60,48 @@ var (
 	githubRepo      = flag.String("repo", "", "The default repository for creating issues when alerts do not include a repo label.")
 	githubBaseURL   = flag.String("enterprise.base-url", "", "The URL of your GitHub Enterprise with API suffix (for example '/api/v3/').")
 	githubUploadURL = flag.String("enterprise.upload-url", "", "The upload URL needs to be set if it differs from the Github Enterprise base URL.")
+	enterpriseToken = flagx.File{}
+	gitlabToken     = flagx.File{}
+	giteaToken      = flagx.File{}
+	jiraToken       = flagx.File{}
+	gitlabBaseURL   = flag.String("gitlab.base-url", gitlab.DefaultBaseURL, "The URL of the GitLab API when using the gitlab backend.")
+	giteaBaseURL    = flag.String("gitea.base-url", "", "The URL of the Gitea API (for example 'https://gitea.example.com/api/v1/') when using the gitea backend.")
+	jiraBaseURL     = flag.String("jira.base-url", "", "The URL of the Jira site (for example 'https://example.atlassian.net/') when using the jira backend.")
+	jiraUser        = flag.String("jira.user", "", "The Jira Cloud account email used with the authtoken for basic auth. When empty, the authtoken is a bearer token.")
+	jiraIssueType   = flag.String("jira.issue-type", "Task", "The Jira issue type of new tickets.")
+	jiraResolved    = flag.String("jira.resolved-status", "Done", "The Jira status that tickets are transitioned to when closed.")
+	backend         = flagx.Enum{Options: []string{"github", "gitlab", "gitea", "jira"}, Value: "github"}
+	mirrors         = flagx.StringArray{}
+	mirrorPolicy    = flagx.Enum{Options: []string{string(fanout.RequirePrimary), string(fanout.RequireAll), string(fanout.RequireAny)}, Value: string(fanout.RequirePrimary)}
 	enableAutoClose = flag.Bool("enable-auto-close", false, "Once an alert stops firing, automatically close open issues.")
 	labelOnResolved = flag.String("label-on-resolved", "", "Once an alert stops firing, apply this label.")
+	closedPolicy    = flagx.Enum{Options: []string{string(alerts.ClosedRecreate), string(alerts.ClosedRespect), string(alerts.ClosedReopen), string(alerts.ClosedLink)}, Value: string(alerts.ClosedRecreate)}
+	closedTTL       = flag.Duration("closed.ttl", alerts.DefaultClosedTTL, "How long to remember issues that were closed while their alert was firing.")
+	dryRun          = flag.Bool("dry-run", false, "List issues from the issue tracker, but only log and record changes to issues on /v1/dryrun.")
 	enableInMemory  = flag.Bool("enable-inmemory", false, "Perform all operations in memory, without using github API.")
+	inMemoryFile    = flag.String("inmemory.file", "", "When -enable-inmemory is set, save all issues to this file so they persist across restarts.")
+	webhookSecret   = flagx.File{}
+	alertmanagerURL = flag.String("alertmanager.url", "", "The URL of Alertmanager. When set with -github.webhook-secret-file, Github issues events silence alerts.")
+	silenceLabel    = flag.String("silence.label", "silence", "Adding this label to an alert issue creates an Alertmanager silence for its alerts.")
+	silenceDuration = flag.Duration("silence.duration", 24*time.Hour, "The duration of silences created from alert issues.")
+	enableChatOps   = flag.Bool("enable-chatops", false, "Run commands like /ack from alert issue comments. Requires -github.webhook-secret-file.")
+	chatOpsTeam     = flag.String("chatops.team", "", "The slug of the -org team whose members may run commands. When empty, repository collaborators may run commands.")
+	ackLabel        = flag.String("chatops.ack-label", "acknowledged", "The label added to alert issues by /ack.")
+	reconcileEvery  = flag.Duration("reconcile.interval", 0, "How often to resolve open issues whose alerts are gone from Alertmanager. Requires -alertmanager.url. Zero disables reconciliation.")
+	reconcileDryRun = flag.Bool("reconcile.dry-run", false, "Only log the stale issues found by reconciliation.")
+	auditFile       = flag.String("audit.file", "", "Append a record of every issue change made for an alert or a command to this JSONL file, for use with the audit command.")
+	auditMaxSize    = flag.Int64("audit.max-size", audit.DefaultMaxSize, "Rotate the -audit.file when it would grow beyond this many bytes.")
+	auditBackups    = flag.Int("audit.max-backups", 5, "The number of rotated -audit.file files to keep.")
+	recordFile      = flag.String("record.file", "", "Append every accepted webhook message to this JSONL file, for use with the replay command.")
+	replaySpeed     = flag.Float64("replay.speed", 1, "How much faster than recorded to replay messages. Zero replays without delay.")
+	otlpEndpoint    = flag.String("tracing.otlp-endpoint", "", "The OTLP/HTTP URL of an OpenTelemetry collector (for example 'http://localhost:4318') to export traces to. When empty, traces are not recorded.")
+	readyFailures   = flag.Int("readiness.max-failures", 3, "Report not ready on /readyz after this many consecutive failed issue tracker API calls. Zero ignores failures. The local backend is not checked.")
+	readyProbe      = flag.Duration("readiness.probe-interval", issues.DefaultProbeInterval, "When not ready, or idle, /readyz checks the Github rate limits at most this often.")
+	shutdownTimeout = flag.Duration("shutdown.timeout", 30*time.Second, "On SIGTERM or SIGINT, how long to wait for in-flight webhook requests and reconciliation to finish before exiting.")
 	receiverAddr    = flag.String("webhook.listen-address", ":9393", "Listen on address for new alertmanager webhook messages.")
 	alertLabel      = flag.String("alertlabel", "alert:boom:", "The default label applied to all alerts. Also used to search the repo to discover exisitng alerts.")
 	extraLabels     = flagx.StringArray{}
+	logLevel        = slog.LevelInfo
 	titleTmplFile   = flagx.FileBytes(alerts.DefaultTitleTmpl)
 	alertTmplFile   = flagx.FileBytes(alerts.DefaultAlertTmpl)
 )
@@ -81,12 +133,116 @@ DESCRIPTION
   or the value read from -authtokenFile. As well, the given -org and -repo
   names are used as the default destination for new issues.
 
+  The -backend flag selects the issue tracker. For the gitlab backend, -org
+  is the GitLab group path (e.g. "group/subgroup") and -repo is the default
+  project within that group. The gitea backend requires -gitea.base-url.
+  The jira backend requires -jira.base-url; -repo is the default Jira
+  project key and -org is not used.
+
+  With -enable-inmemory, issues are kept locally instead of using any issue
+  tracker, and no token is required. Add -inmemory.file to save them to a
+  file, so the receiver can run fully offline across restarts.
+
+  With -dry-run, open issues are read from the issue tracker as usual, but
+  changes to issues are only logged, and the most recent ones are listed as
+  JSON on /v1/dryrun, including the exact Github API request when possible.
+  Github webhook events cannot be used with -dry-run.
+
+  Each -mirror flag adds a backend that receives a copy of every issue, in
+  addition to the primary backend; one of: github, enterprise, gitlab, gitea,
+  jira or local.
+  The "enterprise" mirror uses -enterprise.base-url, and the token from
+  -enterprise.authtoken-file when given; when it is selected, the "github"
+  backend always uses github.com. The gitlab, gitea and jira mirrors require
+  their own -gitlab.authtoken-file, -gitea.authtoken-file or
+  -jira.authtoken-file. The local mirror cannot be used with -enable-inmemory.
+  The -mirror.policy flag selects which backend failures fail a webhook
+  request: "primary", "all" or "any".
+
+  With -github.webhook-secret-file, Github webhook events signed with the
+  secret are accepted on /v1/github. It requires the github backend, without
+  -enable-inmemory. With -alertmanager.url as well, adding the
+  -silence.label label to an alert issue silences its alerts in Alertmanager
+  for -silence.duration, and removing the label or closing the issue expires
+  the silence.
+
+  With -alertmanager.url and -reconcile.interval, open issues are compared to
+  the alerts of Alertmanager at every interval. Issues whose alerts are gone,
+  e.g. because a resolved notification was lost, are resolved like a resolved
+  alert. With -reconcile.dry-run, stale issues are only logged.
+
+  With -enable-chatops as well, comments on alert issues may run the commands
+  /ack, /silence [duration], /assign @user and /close. Only collaborators of
+  the repository, or members of the -chatops.team team, may run commands.
+
+  The -closed.policy flag selects what happens when someone closes an issue
+  while its alert is still firing: "recreate" a new issue, "respect" the close
+  until the alert resolves and fires again, "reopen" the issue with a comment,
+  or create a new issue that "link"s to the closed one. Closed issues are
+  remembered for -closed.ttl. Only the github backend and -enable-inmemory
+  detect closed issues, so other policies than "recreate" cannot be used with
+  the gitlab, gitea or jira backends. With -mirror, only issues closed in the
+  primary backend are detected.
+
+  With -record.file, every accepted webhook message is appended to the file
+  as a JSON line. The replay command processes such a file with the same flags
+  as the receiver, e.g. with -enable-inmemory or -dry-run, and then exits. The
+  time between messages is divided by -replay.speed.
+
+  With -audit.file, every issue change made for an alert, a ChatOps command
+  or the issues and migrate-titles commands is appended to the file as a JSON
+  line, with the request ID, alert group key and status, and whether the
+  change succeeded. Dry runs are not recorded. The file is rotated at
+  -audit.max-size bytes.
+  The audit command lists the recorded changes of an issue or an alert, e.g.
+  "github_receiver -audit.file <file> audit -repo <repo> -issue 12".
+
+  The issues command lists, closes, labels or unlabels open alert issues in
+  bulk, e.g. after an alert storm. Run "github_receiver issues list -h" for
+  its filters.
+
+  Changing -title-template-file would duplicate every open issue, because
+  issues are matched by title. The migrate-titles command retitles the open
+  issues from the -old-title-template-file template to the new one first.
+
+  Logs are JSON records on stderr, at or above -log-level. Every webhook
+  request has a request ID, which is added to all of its records and returned
+  in the X-Request-Id header.
+
+  With -tracing.otlp-endpoint, traces of webhook requests and Github API calls
+  are exported to an OpenTelemetry collector. Webhook requests continue the
+  trace of their traceparent header.
+
+  On SIGTERM or SIGINT, the receiver stops accepting requests, and waits up to
+  -shutdown.timeout for in-flight webhook requests and reconciliation to
+  finish, so that issue changes are not interrupted halfway.
+
+  For probes, /healthz reports that the process is running, and /readyz
+  reports not ready when the token is invalid, a Github rate limit is
+  exhausted, or the last -readiness.max-failures issue tracker API calls
+  failed.
+
 EXAMPLE
   github_receiver -org <name> -repo <repo> -authtoken <token>
+  github_receiver -enable-inmemory -repo <repo> replay <file>
+  github_receiver -org <name> -repo <repo> -authtoken <token> issues close -title '^Disk'
 `
 )
 
+// mirrorOptions are the backends that may be given to -mirror.
+var mirrorOptions = []string{"github", "enterprise", "gitlab", "gitea", "jira", "local"}
+
 func init() {
+	flag.Var(&backend, "backend", "The issue tracker backend to use; one of: "+strings.Join(backend.Options, ", ")+".")
+	flag.Var(&closedPolicy, "closed.policy", "What to do when an issue is closed while its alert is still firing; one of: "+strings.Join(closedPolicy.Options, ", ")+". Only the github backend and -enable-inmemory support other policies than recreate.")
+	flag.Var(&mirrors, "mirror", "Mirror all issues to this additional backend. May be repeated.")
+	flag.Var(&mirrorPolicy, "mirror.policy", "Which backend failures fail a request when mirroring; one of: "+strings.Join(mirrorPolicy.Options, ", ")+".")
+	flag.Var(&enterpriseToken, "enterprise.authtoken-file", "Oauth2 token file for the enterprise mirror. Defaults to the authtoken.")
+	flag.Var(&gitlabToken, "gitlab.authtoken-file", "Access token file for the gitlab backend. Required for the gitlab mirror; defaults to the authtoken for the primary backend.")
+	flag.Var(&giteaToken, "gitea.authtoken-file", "Access token file for the gitea backend. Required for the gitea mirror; defaults to the authtoken for the primary backend.")
+	flag.Var(&jiraToken, "jira.authtoken-file", "API token file for the jira backend. Required for the jira mirror; defaults to the authtoken for the primary backend.")
+	flag.Var(&webhookSecret, "github.webhook-secret-file", "File containing the Github webhook secret. When provided, signed Github events are accepted on /v1/github. Requires the github backend.")
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "The minimum level of logged records; one of: debug, info, warn, error.")
 	flag.Var(&extraLabels, "label", "Extra labels to add to issues at creation time.")
 	flag.Var(&authtokenFile, "authtoken-file", "Oauth2 token file for access to github API. When provided it takes precedence over authtoken.")
 	flag.Var(&titleTmplFile, "title-template-file", "File containing a template to generate issue titles.")
@@ -97,10 +253,26 @@ func init() {
 	}
 }
 
-func mustServeWebhookReceiver(receiver *alerts.ReceiverHandler) *http.Server {
+func mustServeWebhookReceiver(receiver *alerts.ReceiverHandler, eventHandler, readyHandler http.Handler) *http.Server {
 	mux := http.NewServeMux()
-	mux.Handle("/", &issues.ListHandler{ListClient: receiver.Client})
+	mux.Handle("/", &issues.ListHandler{
+		ListClient:    receiver.Client,
+		ResolvedLabel: *labelOnResolved,
+		Refresh:       time.Minute,
+		MetricsAddr:   *prometheusx.ListenAddress,
+	})
+	mux.Handle("/api/v1/issues", &issues.APIHandler{ListClient: receiver.Client})
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(rw, "ok\n")
+	})
+	mux.Handle("/readyz", readyHandler)
 	mux.Handle("/v1/receiver", promhttp.InstrumentHandlerDuration(receiverDuration, receiver))
+	if eventHandler != nil {
+		mux.Handle("/v1/github", eventHandler)
+	}
+	if d, ok := receiver.Client.(*dryrun.Client); ok {
+		mux.Handle("/v1/dryrun", d)
+	}
 	srv := &http.Server{
 		Addr:    *receiverAddr,
 		Handler: mux,
@@ -109,10 +281,215 @@ func mustServeWebhookReceiver(receiver *alerts.ReceiverHandler) *http.Server {
 	return srv
 }
 
+// newReceiverClient creates the ReceiverClient selected by the command line
+// flags, authenticated using the given token. When mirrors are given, the
+// returned client dispatches to the primary backend and every mirror.
+func newReceiverClient(token string) (alerts.ReceiverClient, error) {
+	primary := backend.Value
+	if *enableInMemory {
+		primary = "local"
+	}
+	if primary == "local" && hasMirror("local") {
+		// Both would save their issues to the same -inmemory.file.
+		return nil, fmt.Errorf("-mirror=local cannot be used with -enable-inmemory")
+	}
+	client, err := newBackendClient(primary, token, false)
+	if err != nil {
+		return nil, err
+	}
+	// Closed issues are only detected in the primary backend.
+	if _, ok := client.(alerts.IssueGetter); !ok && closedPolicy.Value != string(alerts.ClosedRecreate) {
+		return nil, fmt.Errorf("-closed.policy=%s cannot be used with the %s backend, which cannot read issues", closedPolicy.Value, primary)
+	}
+	if len(mirrors) == 0 {
+		return client, nil
+	}
+	backends := []fanout.Backend{{Name: primary, Client: client}}
+	for _, name := range mirrors {
+		client, err := newBackendClient(name, token, true)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %s: %w", name, err)
+		}
+		backends = append(backends, fanout.Backend{Name: name, Client: client})
+	}
+	return fanout.NewClient(fanout.Policy(mirrorPolicy.Value), backends...)
+}
+
+// newBackendClient creates the named backend client. The primary backend uses
+// the given token unless the backend has a token flag of its own. Mirrors of
+// other issue trackers than Github must have their own token, so that the
+// Github token is never sent to them.
+func newBackendClient(name, token string, mirror bool) (alerts.ReceiverClient, error) {
+	if tokenFile, ok := backendTokens[name]; ok {
+		switch {
+		case len(tokenFile.Bytes) != 0:
+			token = tokenFile.Content()
+		case mirror:
+			return nil, fmt.Errorf("the %s mirror requires -%s.authtoken-file", name, name)
+		}
+	}
+	switch name {
+	case "local":
+		if *inMemoryFile != "" {
+			return local.NewFileClient(*inMemoryFile)
+		}
+		return local.NewClient(), nil
+	case "gitlab":
+		return gitlab.NewClient(*gitlabBaseURL, *githubOrg, token, *alertLabel)
+	case "gitea":
+		return gitea.NewClient(*giteaBaseURL, *githubOrg, token, *alertLabel)
+	case "jira":
+		return jira.NewClient(*jiraBaseURL, *jiraUser, token, *alertLabel, *jiraIssueType, *jiraResolved)
+	case "enterprise":
+		if *githubBaseURL == "" {
+			return nil, fmt.Errorf("the enterprise backend requires -enterprise.base-url")
+		}
+		if len(enterpriseToken.Bytes) != 0 {
+			token = enterpriseToken.Content()
+		}
+		return issues.NewEnterpriseClient(*githubBaseURL, *githubUploadURL, *githubOrg, token, *alertLabel)
+	case "github":
+		return newGithubClient(token)
+	default:
+		return nil, fmt.Errorf("unsupported backend %q; must be one of: %s", name, strings.Join(mirrorOptions, ", "))
+	}
+}
+
+// newGithubClient creates the client for the "github" backend.
+func newGithubClient(token string) (*issues.Client, error) {
+	// An explicit enterprise mirror means github refers to github.com.
+	if *githubBaseURL == "" || hasMirror("enterprise") {
+		return issues.NewClient(*githubOrg, token, *alertLabel), nil
+	}
+	return issues.NewEnterpriseClient(*githubBaseURL, *githubUploadURL, *githubOrg, token, *alertLabel)
+}
+
+// newReadyHandler creates the /readyz handler. Only the Github API has rate
+// limits to check, so the handler probes the API when the primary backend is
+// github or enterprise.
+func newReadyHandler(token string) (*issues.ReadyHandler, error) {
+	h := &issues.ReadyHandler{MaxFailures: *readyFailures, ProbeInterval: *readyProbe}
+	if *enableInMemory || (backend.Value != "github" && backend.Value != "enterprise") {
+		return h, nil
+	}
+	client, err := newBackendClient(backend.Value, token, false)
+	if err != nil {
+		return nil, err
+	}
+	if gh, ok := client.(*issues.Client); ok {
+		h.Prober = gh
+	}
+	return h, nil
+}
+
+// newEventReceiver creates the receiver for Github webhook events, or returns
+// nil if -github.webhook-secret-file is not set. The issue changes of ChatOps
+// commands are recorded in the auditLog, if not nil.
+func newEventReceiver(token string, auditLog *audit.Log) (http.Handler, error) {
+	if len(webhookSecret.Bytes) != 0 && *dryRun {
+		return nil, fmt.Errorf("-github.webhook-secret-file cannot be used with -dry-run")
+	}
+	// Events change Github issues with the primary token, which must not be
+	// sent to Github for any other backend.
+	if len(webhookSecret.Bytes) != 0 && (*enableInMemory || backend.Value != "github") {
+		return nil, fmt.Errorf("-github.webhook-secret-file requires the github backend")
+	}
+	if len(webhookSecret.Bytes) == 0 {
+		if (*alertmanagerURL != "" && *reconcileEvery == 0) || *enableChatOps {
+			return nil, fmt.Errorf("-alertmanager.url and -enable-chatops require -github.webhook-secret-file")
+		}
+		return nil, nil
+	}
+	// Files usually end with a newline, which is not part of the secret.
+	r, err := events.NewReceiver([]byte(strings.TrimSpace(webhookSecret.Content())))
+	if err != nil {
+		return nil, err
+	}
+	gh, err := newGithubClient(token)
+	if err != nil {
+		return nil, err
+	}
+	var silences *events.SilenceHandler
+	if *alertmanagerURL != "" {
+		am, err := alertmanager.NewClient(*alertmanagerURL)
+		if err != nil {
+			return nil, err
+		}
+		silences = events.NewSilenceHandler(am, gh, *silenceLabel, *silenceDuration)
+		r.Handle("issues", silences)
+	}
+	if *enableChatOps {
+		var client events.CommandClient = gh
+		if auditLog != nil {
+			client = &auditedCommandClient{Client: gh, audit: audit.NewClient(gh, auditLog)}
+		}
+		r.Handle("issue_comment", events.NewCommandHandler(client, silences, *alertLabel, *ackLabel, *chatOpsTeam))
+	}
+	return r, nil
+}
+
+// replay processes the webhook messages recorded in the named file.
+func replay(ctx context.Context, receiver *alerts.ReceiverHandler, name string) error {
+	if name == "" {
+		return fmt.Errorf("replay requires a recording file")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := receiver.Replay(ctx, f, *replaySpeed)
+	slog.Info("Replayed messages", "count", n, "file", name)
+	return err
+}
+
+// backendTokens contains the token files of the issue trackers other than
+// Github, by backend name.
+var backendTokens = map[string]*flagx.File{
+	"gitlab": &gitlabToken,
+	"gitea":  &giteaToken,
+	"jira":   &jiraToken,
+}
+
+// hasMirror reports whether the named backend was given to -mirror.
+func hasMirror(name string) bool {
+	for _, m := range mirrors {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
 	flag.Parse()
 	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Failed to read ArgsFromEnv")
-	if (*authtoken == "" && len(authtokenFile.Bytes) == 0) || *githubOrg == "" || *githubRepo == "" {
+	slog.SetDefault(logging.New(os.Stderr, logLevel))
+	shutdownTracing, err := tracing.Setup(ctx, *otlpEndpoint, "github_receiver")
+	rtx.Must(err, "Failed to set up tracing")
+	defer shutdownTracing(context.Background())
+	// Signals also cancel the replay, issues and migrate-titles commands.
+	go cancelOnSignal(ctx, syscall.SIGTERM, os.Interrupt)
+	if flag.Arg(0) == "audit" {
+		// The audit log is read without any issue tracker.
+		if err := runAudit(*auditFile, flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Print(err)
+			osExit(1)
+		}
+		return
+	}
+	// No token is needed to operate on local issues, and a primary backend
+	// with a token of its own does not need the Github token.
+	needsToken := !*enableInMemory
+	if tokenFile, ok := backendTokens[backend.Value]; ok && len(tokenFile.Bytes) != 0 {
+		needsToken = false
+	}
+	for _, m := range mirrors {
+		// Other mirrors have their own tokens.
+		needsToken = needsToken || m == "github" || m == "enterprise"
+	}
+	missingToken := *authtoken == "" && len(authtokenFile.Bytes) == 0 && needsToken
+	if missingToken || (*githubOrg == "" && backend.Value != "jira") || *githubRepo == "" {
 		flag.Usage()
 		osExit(1)
 		return
@@ -125,31 +502,146 @@ func main() {
 		token = *authtoken
 	}
 
-	var client alerts.ReceiverClient
-	if *enableInMemory {
-		client = local.NewClient()
-	} else if *githubBaseURL == "" {
-		client = issues.NewClient(*githubOrg, token, *alertLabel)
-	} else {
-		var err error
-		client, err = issues.NewEnterpriseClient(*githubBaseURL, *githubUploadURL, *githubOrg, token, *alertLabel)
+	client, err := newReceiverClient(token)
+	if err != nil {
+		fmt.Print(err)
+		osExit(1)
+		return
+	}
+	var auditLog *audit.Log
+	if *auditFile != "" {
+		auditLog, err = audit.Open(*auditFile, *auditMaxSize, *auditBackups)
+		if err != nil {
+			fmt.Print(err)
+			osExit(1)
+			return
+		}
+		defer auditLog.Close()
+	}
+	// Dry runs change no issues, so there is nothing to audit.
+	if *dryRun {
+		client = dryrun.NewClient(client, dryrun.DefaultMaxMutations)
+	} else if auditLog != nil {
+		client = audit.NewClient(client, auditLog)
+	}
+	if flag.Arg(0) == "issues" {
+		if err := runIssues(ctx, client, flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Print(err)
+			osExit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "migrate-titles" {
+		if err := runMigrateTitles(ctx, client, string(titleTmplFile), flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Print(err)
+			osExit(1)
+		}
+		return
+	}
+
+	receiver, err := alerts.NewReceiver(client, *githubRepo, *enableAutoClose, *labelOnResolved, extraLabels, string(titleTmplFile), string(alertTmplFile))
+	if err != nil {
+		fmt.Print(err)
+		osExit(1)
+		return
+	}
+	receiver.ClosedPolicy = alerts.ClosedPolicy(closedPolicy.Value)
+	receiver.ClosedTTL = *closedTTL
+
+	if flag.Arg(0) == "replay" {
+		if err := replay(ctx, receiver, flag.Arg(1)); err != nil {
+			fmt.Print(err)
+			osExit(1)
+		}
+		return
+	}
+	if *recordFile != "" {
+		f, err := os.OpenFile(*recordFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			fmt.Print(err)
 			osExit(1)
 			return
 		}
+		defer f.Close()
+		receiver.Recorder = alerts.NewRecorder(f)
 	}
 
 	promSrv := prometheusx.MustServeMetrics()
 	defer promSrv.Close()
 
-	receiver, err := alerts.NewReceiver(client, *githubRepo, *enableAutoClose, *labelOnResolved, extraLabels, string(titleTmplFile), string(alertTmplFile))
+	// workers tracks the background goroutines that change issues.
+	var workers sync.WaitGroup
+	eventHandler, err := newEventReceiver(token, auditLog)
 	if err != nil {
 		fmt.Print(err)
 		osExit(1)
 		return
 	}
-	srv := mustServeWebhookReceiver(receiver)
-	defer srv.Close()
+	if *reconcileEvery > 0 {
+		if *alertmanagerURL == "" {
+			fmt.Print("-reconcile.interval requires -alertmanager.url")
+			osExit(1)
+			return
+		}
+		am, err := alertmanager.NewClient(*alertmanagerURL)
+		if err != nil {
+			fmt.Print(err)
+			osExit(1)
+			return
+		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			alerts.NewReconciler(receiver, am, *reconcileDryRun).Run(ctx, *reconcileEvery)
+		}()
+	}
+	readyHandler, err := newReadyHandler(token)
+	if err != nil {
+		fmt.Print(err)
+		osExit(1)
+		return
+	}
+	srv := mustServeWebhookReceiver(receiver, eventHandler, readyHandler)
 	<-ctx.Done()
+	// The deferred calls flush the record, audit and trace files after the
+	// in-flight requests finish.
+	shutdown(srv, &workers, *shutdownTimeout)
+}
+
+// cancelOnSignal cancels the main context when one of the signals is
+// received, until ctx is canceled.
+func cancelOnSignal(ctx context.Context, sig ...os.Signal) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sig...)
+	defer signal.Stop(signals)
+	select {
+	case s := <-signals:
+		slog.Info("Received signal, shutting down", "signal", s.String())
+		cancelCtx()
+	case <-ctx.Done():
+	}
+}
+
+// shutdown stops accepting new requests, and waits until the in-flight
+// requests and the background workers finish, or until the timeout. Requests
+// still in flight after the timeout are canceled.
+func shutdown(srv *http.Server, workers *sync.WaitGroup, timeout time.Duration) {
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(sctx); err != nil {
+		slog.Error("In-flight requests did not finish before the shutdown timeout", "error", err)
+		srv.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("Shut down", "duration", time.Since(start).String())
+	case <-sctx.Done():
+		slog.Error("Background workers did not finish before the shutdown timeout")
+	}
 }
@@ -2,12 +2,20 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/m-lab/go/httpx"
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/prometheusx/promtest"
+	"github.com/m-lab/go/rtx"
 )
 
 func TestMetrics(t *testing.T) {
@@ -16,14 +24,35 @@ func TestMetrics(t *testing.T) {
 }
 
 func Test_main(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "recording.jsonl")
+	msg := `{"status": "firing", "groupLabels": {"alertname": "DiskRunningFull"}}`
+	if err := ioutil.WriteFile(recording, []byte(`{"message": `+msg+"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
 	tests := []struct {
 		name         string
 		authfile     string
 		authtoken    string
 		repo         string
 		baseURL      string
+		backend      string
+		gitlabURL    string
+		giteaURL     string
+		jiraURL      string
+		org          string
 		titleTmpl    string
 		inmemory     bool
+		inmemoryFile string
+		mirrors      []string
+		closedPolicy string
+		gitlabToken  string
+		amURL        string
+		secret       string
+		chatops      bool
+		reconcile    time.Duration
+		dryRun       bool
+		recordFile   string
+		args         []string
 		expectStatus int
 	}{
 		{
@@ -38,6 +67,283 @@ func Test_main(t *testing.T) {
 			repo:     "fake-repo",
 			inmemory: true,
 		},
+		{
+			name:      "okay-gitlab",
+			repo:      "fake-repo",
+			authtoken: "token",
+			backend:   "gitlab",
+		},
+		{
+			name:        "okay-gitlab-own-token",
+			repo:        "fake-repo",
+			backend:     "gitlab",
+			gitlabToken: "gitlab-token",
+		},
+		{
+			name:         "missing-token-gitlab",
+			repo:         "fake-repo",
+			backend:      "gitlab",
+			expectStatus: 1,
+		},
+		{
+			name:      "okay-gitea",
+			repo:      "fake-repo",
+			authtoken: "token",
+			backend:   "gitea",
+			giteaURL:  "https://gitea.example.com/api/v1/",
+		},
+		{
+			name:      "okay-jira-without-org",
+			repo:      "OPS",
+			authtoken: "token",
+			backend:   "jira",
+			jiraURL:   "https://example.atlassian.net/",
+			org:       "-",
+		},
+		{
+			name:         "okay-inmemory-file-without-token",
+			repo:         "fake-repo",
+			inmemory:     true,
+			inmemoryFile: filepath.Join(t.TempDir(), "issues.json"),
+		},
+		{
+			name:         "bad-inmemory-file",
+			repo:         "fake-repo",
+			inmemory:     true,
+			inmemoryFile: t.TempDir(),
+			expectStatus: 1,
+		},
+		{
+			name:      "okay-mirror-github-local",
+			repo:      "fake-repo",
+			authtoken: "token",
+			mirrors:   []string{"local"},
+		},
+		{
+			name:      "okay-mirror-enterprise",
+			repo:      "fake-repo",
+			authtoken: "token",
+			baseURL:   "https://github.example.com/api/v3/",
+			mirrors:   []string{"enterprise"},
+		},
+		{
+			name:         "bad-inmemory-mirror-local",
+			repo:         "fake-repo",
+			inmemory:     true,
+			mirrors:      []string{"local"},
+			expectStatus: 1,
+		},
+		{
+			name:        "okay-mirror-gitlab",
+			repo:        "fake-repo",
+			authtoken:   "token",
+			gitlabURL:   "https://gitlab.example.com/api/v4/",
+			mirrors:     []string{"gitlab"},
+			gitlabToken: "gitlab-token",
+		},
+		{
+			name:         "missing-mirror-gitlab-token",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			gitlabURL:    "https://gitlab.example.com/api/v4/",
+			mirrors:      []string{"gitlab"},
+			expectStatus: 1,
+		},
+		{
+			name:         "missing-token-inmemory-mirror-github",
+			repo:         "fake-repo",
+			inmemory:     true,
+			mirrors:      []string{"github"},
+			expectStatus: 1,
+		},
+		{
+			name:         "bad-mirror",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			mirrors:      []string{"unknown"},
+			expectStatus: 1,
+		},
+		{
+			name:         "missing-enterprise-baseURL",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			mirrors:      []string{"enterprise"},
+			expectStatus: 1,
+		},
+		{
+			name:         "okay-closed-policy-mirror",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			mirrors:      []string{"local"},
+			closedPolicy: "reopen",
+		},
+		{
+			name:         "bad-closed-policy-gitlab",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			backend:      "gitlab",
+			closedPolicy: "reopen",
+			expectStatus: 1,
+		},
+		{
+			name:      "okay-alertmanager",
+			repo:      "fake-repo",
+			authtoken: "token",
+			amURL:     "http://localhost:9093/",
+			secret:    "webhook-secret",
+		},
+		{
+			name:      "okay-webhook-secret-only",
+			repo:      "fake-repo",
+			authtoken: "token",
+			secret:    "webhook-secret",
+		},
+		{
+			name:         "bad-webhook-secret-gitlab",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			backend:      "gitlab",
+			secret:       "webhook-secret",
+			expectStatus: 1,
+		},
+		{
+			name:         "bad-webhook-secret-inmemory",
+			repo:         "fake-repo",
+			inmemory:     true,
+			secret:       "webhook-secret",
+			expectStatus: 1,
+		},
+		{
+			name:         "bad-alertmanager-url",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			amURL:        "invalidURLEscape%zz",
+			secret:       "webhook-secret",
+			expectStatus: 1,
+		},
+		{
+			name:      "okay-chatops",
+			repo:      "fake-repo",
+			authtoken: "token",
+			secret:    "webhook-secret",
+			chatops:   true,
+		},
+		{
+			name:         "missing-chatops-webhook-secret",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			chatops:      true,
+			expectStatus: 1,
+		},
+		{
+			name:         "missing-webhook-secret",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			amURL:        "http://localhost:9093/",
+			expectStatus: 1,
+		},
+		{
+			name:       "okay-record",
+			repo:       "fake-repo",
+			authtoken:  "token",
+			recordFile: filepath.Join(t.TempDir(), "recording.jsonl"),
+		},
+		{
+			name:         "bad-record-file",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			recordFile:   filepath.Join(t.TempDir(), "missing", "recording.jsonl"),
+			expectStatus: 1,
+		},
+		{
+			name:     "okay-replay",
+			repo:     "fake-repo",
+			inmemory: true,
+			args:     []string{"replay", recording},
+		},
+		{
+			name:     "okay-issues-list",
+			repo:     "fake-repo",
+			inmemory: true,
+			args:     []string{"issues", "list"},
+		},
+		{
+			name:     "okay-migrate-titles",
+			repo:     "fake-repo",
+			inmemory: true,
+			args:     []string{"migrate-titles", "-dry-run"},
+		},
+		{
+			name:         "bad-migrate-titles",
+			repo:         "fake-repo",
+			inmemory:     true,
+			args:         []string{"migrate-titles", "extra"},
+			expectStatus: 1,
+		},
+		{
+			name:         "missing-issues-action",
+			repo:         "fake-repo",
+			inmemory:     true,
+			args:         []string{"issues"},
+			expectStatus: 1,
+		},
+		{
+			name:         "missing-replay-file",
+			repo:         "fake-repo",
+			inmemory:     true,
+			args:         []string{"replay"},
+			expectStatus: 1,
+		},
+		{
+			name:         "bad-replay-file",
+			repo:         "fake-repo",
+			inmemory:     true,
+			args:         []string{"replay", filepath.Join(t.TempDir(), "missing.jsonl")},
+			expectStatus: 1,
+		},
+		{
+			name:      "okay-dry-run",
+			repo:      "fake-repo",
+			authtoken: "token",
+			dryRun:    true,
+		},
+		{
+			name:         "dry-run-webhook-secret",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			secret:       "webhook-secret",
+			dryRun:       true,
+			expectStatus: 1,
+		},
+		{
+			name:      "okay-reconcile",
+			repo:      "fake-repo",
+			authtoken: "token",
+			amURL:     "http://localhost:9093/",
+			reconcile: time.Hour,
+		},
+		{
+			name:         "missing-reconcile-alertmanager-url",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			reconcile:    time.Hour,
+			expectStatus: 1,
+		},
+		{
+			name:         "bad-reconcile-alertmanager-url",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			amURL:        "invalidURLEscape%zz",
+			reconcile:    time.Hour,
+			expectStatus: 1,
+		},
+		{
+			name:         "empty-webhook-secret",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			secret:       "\n",
+			expectStatus: 1,
+		},
 		{
 			name:         "missing-flags-usage",
 			expectStatus: 1,
@@ -56,20 +362,59 @@ func Test_main(t *testing.T) {
 			baseURL:      "invalidURLEscape%zz",
 			expectStatus: 1,
 		},
+		{
+			name:         "bad-gitlab-baseURL",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			backend:      "gitlab",
+			gitlabURL:    "invalidURLEscape%zz",
+			expectStatus: 1,
+		},
+		{
+			name:         "missing-gitea-baseURL",
+			repo:         "fake-repo",
+			authtoken:    "token",
+			backend:      "gitea",
+			expectStatus: 1,
+		},
 	}
 	flag.CommandLine.SetOutput(ioutil.Discard)
 	for _, tt := range tests {
-		osExit = func(status int) {
-			if status != tt.expectStatus {
-				t.Error(status)
-			}
+		status := 0
+		osExit = func(s int) {
+			status = s
 		}
 		*authtoken = tt.authtoken
 		authtokenFile.Bytes = []byte(tt.authfile)
 		*githubOrg = "fake-org"
+		if tt.org == "-" {
+			*githubOrg = ""
+		}
 		*githubRepo = tt.repo
 		*githubBaseURL = tt.baseURL
 		*enableInMemory = tt.inmemory
+		*inMemoryFile = tt.inmemoryFile
+		backend.Value = "github"
+		if tt.backend != "" {
+			backend.Value = tt.backend
+		}
+		*gitlabBaseURL = tt.gitlabURL
+		*giteaBaseURL = tt.giteaURL
+		*jiraBaseURL = tt.jiraURL
+		mirrors = tt.mirrors
+		closedPolicy.Value = "recreate"
+		if tt.closedPolicy != "" {
+			closedPolicy.Value = tt.closedPolicy
+		}
+		gitlabToken.Bytes = []byte(tt.gitlabToken)
+		*alertmanagerURL = tt.amURL
+		webhookSecret.Bytes = []byte(tt.secret)
+		*enableChatOps = tt.chatops
+		*reconcileEvery = tt.reconcile
+		*dryRun = tt.dryRun
+		*recordFile = tt.recordFile
+		*replaySpeed = 0
+		os.Args = append([]string{os.Args[0]}, tt.args...)
 		// Guarantee no port conflicts between tests of main.
 		*prometheusx.ListenAddress = ":0"
 		*receiverAddr = ":0"
@@ -88,6 +433,97 @@ func Test_main(t *testing.T) {
 			}()
 			cancelCtx()
 			wg.Wait()
+			if status != tt.expectStatus {
+				t.Errorf("main() exit status = %d, want %d", status, tt.expectStatus)
+			}
 		})
 	}
 }
+
+func Test_shutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			close(started)
+			<-release
+			fmt.Fprint(rw, "done")
+		}),
+	}
+	rtx.Must(httpx.ListenAndServeAsync(srv), "Failed to start server")
+
+	// One request and one background worker are in flight.
+	result := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		result <- string(b)
+	}()
+	<-started
+	var workers sync.WaitGroup
+	workers.Add(1)
+	workerDone := false
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		workerDone = true
+		workers.Done()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(srv, &workers, 10*time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("shutdown() returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if got := <-result; got != "done" {
+		t.Errorf("in-flight request got %q, want done", got)
+	}
+	<-done
+	if !workerDone {
+		t.Errorf("shutdown() returned before the background worker finished")
+	}
+	if _, err := http.Get("http://" + srv.Addr); err == nil {
+		t.Errorf("server accepted a request after shutdown")
+	}
+}
+
+func Test_shutdown_timeout(t *testing.T) {
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	rtx.Must(httpx.ListenAndServeAsync(srv), "Failed to start server")
+	var workers sync.WaitGroup
+	workers.Add(1)
+	defer workers.Done()
+
+	start := time.Now()
+	shutdown(srv, &workers, 10*time.Millisecond)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown() took %v, want the timeout", d)
+	}
+}
+
+func Test_newReadyHandler(t *testing.T) {
+	defer func() { backend.Value = "github" }()
+	*enableInMemory = false
+	*githubBaseURL = ""
+	for name, wantProber := range map[string]bool{"github": true, "gitlab": false, "jira": false} {
+		backend.Value = name
+		h, err := newReadyHandler("token")
+		if err != nil {
+			t.Fatalf("newReadyHandler() with %s backend error = %v", name, err)
+		}
+		if (h.Prober != nil) != wantProber {
+			t.Errorf("newReadyHandler() with %s backend has prober %t, want %t", name, h.Prober != nil, wantProber)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/go/flagx"
+)
+
+const migrateUsage = `
+USAGE
+  github_receiver [flags] migrate-titles [-old-title-template-file <file>] [flags]
+
+  Retitles open alert issues from the old title template to the current
+  -title-template-file, so that the receiver keeps finding them. The alert of
+  each issue is read from the metadata of the issue, or from a -recording of
+  webhook messages. Stop the receiver before migrating, and restart it with
+  the new template afterwards.
+
+FLAGS
+`
+
+// runMigrateTitles runs the migrate-titles command with the given arguments.
+// Confirmations are read from in, and results are written to out.
+func runMigrateTitles(ctx context.Context, client alerts.ReceiverClient, newTmpl string, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate-titles", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprint(out, migrateUsage)
+		fs.PrintDefaults()
+	}
+	oldTmpl := flagx.FileBytes(alerts.DefaultTitleTmpl)
+	fs.Var(&oldTmpl, "old-title-template-file", "File containing the previous title template. Defaults to the default title template.")
+	recording := fs.String("recording", "", "File of webhook messages recorded with -record.file, for issues without metadata.")
+	yes := fs.Bool("yes", false, "Retitle issues without confirmation.")
+	dryRun := fs.Bool("dry-run", false, "Only list the planned title changes.")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("migrate-titles takes no arguments")
+	}
+
+	m, err := alerts.NewTitleMigrator(client, string(oldTmpl), newTmpl)
+	if err != nil {
+		return err
+	}
+	if *recording != "" {
+		f, err := os.Open(*recording)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := m.LoadRecordings(f); err != nil {
+			return fmt.Errorf("%s: %s", *recording, err)
+		}
+	}
+	plan, err := m.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	changes := 0
+	for _, r := range plan {
+		if r.Title == "" {
+			fmt.Fprintf(out, "#%d\tskip\t%q: %s\n", r.Issue.GetNumber(), r.Issue.GetTitle(), r.Source)
+			continue
+		}
+		changes++
+		fmt.Fprintf(out, "#%d\t%s\t%q -> %q\n", r.Issue.GetNumber(), r.Source, r.Issue.GetTitle(), r.Title)
+	}
+	if changes == 0 || *dryRun {
+		fmt.Fprintf(out, "%d issues to retitle\n", changes)
+		return nil
+	}
+	if !*yes && !confirm(in, out, fmt.Sprintf("Retitle %d issues?", changes)) {
+		return fmt.Errorf("canceled")
+	}
+	n, err := m.Apply(ctx, plan)
+	fmt.Fprintf(out, "Retitled %d issues\n", n)
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+)
+
+func Test_runMigrateTitles(t *testing.T) {
+	dir := t.TempDir()
+	oldTmpl := filepath.Join(dir, "old.tmpl")
+	if err := ioutil.WriteFile(oldTmpl, []byte(`[{{ .Data.Receiver }}] {{ .Data.GroupLabels.alertname }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recording := filepath.Join(dir, "recording.jsonl")
+	msg := `{"receiver": "github", "status": "firing", "groupLabels": {"alertname": "DiskRunningFull"}}`
+	if err := ioutil.WriteFile(recording, []byte(`{"message": `+msg+"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		args       []string
+		input      string
+		wantErr    bool
+		wantTitle  string
+		wantOutput string
+	}{
+		{
+			name:       "success",
+			args:       []string{"-old-title-template-file", oldTmpl, "-recording", recording},
+			input:      "y\n",
+			wantTitle:  "DiskRunningFull",
+			wantOutput: "Retitled 1 issues",
+		},
+		{
+			name:       "success-yes",
+			args:       []string{"-old-title-template-file", oldTmpl, "-recording", recording, "-yes"},
+			wantTitle:  "DiskRunningFull",
+			wantOutput: "Retitled 1 issues",
+		},
+		{
+			name:       "dry-run",
+			args:       []string{"-old-title-template-file", oldTmpl, "-recording", recording, "-dry-run"},
+			wantTitle:  "[github] DiskRunningFull",
+			wantOutput: `"[github] DiskRunningFull" -> "DiskRunningFull"`,
+		},
+		{
+			name:       "no-recording",
+			args:       []string{"-old-title-template-file", oldTmpl},
+			wantTitle:  "[github] DiskRunningFull",
+			wantOutput: "0 issues to retitle",
+		},
+		{
+			name:      "canceled",
+			args:      []string{"-old-title-template-file", oldTmpl, "-recording", recording},
+			input:     "n\n",
+			wantErr:   true,
+			wantTitle: "[github] DiskRunningFull",
+		},
+		{
+			name:      "failure-missing-recording",
+			args:      []string{"-recording", filepath.Join(dir, "missing.jsonl")},
+			wantErr:   true,
+			wantTitle: "[github] DiskRunningFull",
+		},
+		{
+			name:      "failure-extra-argument",
+			args:      []string{"extra"},
+			wantErr:   true,
+			wantTitle: "[github] DiskRunningFull",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := local.NewClient()
+			if _, err := c.CreateIssue(context.Background(), "fake-repo", "[github] DiskRunningFull", "body", nil); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			err := runMigrateTitles(context.Background(), c, alerts.DefaultTitleTmpl, tt.args, strings.NewReader(tt.input), &out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runMigrateTitles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(out.String(), tt.wantOutput) {
+				t.Errorf("runMigrateTitles() output = %q, want %q", out.String(), tt.wantOutput)
+			}
+			open, _ := c.ListOpenIssues(context.Background())
+			if len(open) != 1 || open[0].GetTitle() != tt.wantTitle {
+				t.Errorf("open issues = %v, want one titled %q", open, tt.wantTitle)
+			}
+		})
+	}
+}
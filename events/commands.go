@@ -0,0 +1,220 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+	"github.com/prometheus/common/model"
+)
+
+// commandNames contains the supported commands.
+var commandNames = map[string]bool{
+	"/ack":     true,
+	"/silence": true,
+	"/assign":  true,
+	"/close":   true,
+}
+
+// CommandClient defines the issue operations needed by the CommandHandler.
+type CommandClient interface {
+	Commenter
+	LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error
+	AssignIssue(ctx context.Context, issue *github.Issue, users []string) error
+	CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error)
+	IsCollaborator(ctx context.Context, issue *github.Issue, user string) (bool, error)
+	IsTeamMember(ctx context.Context, team, user string) (bool, error)
+}
+
+// CommandHandler handles Github issue_comment events. Comment lines that start
+// with a command are carried out on the issue and acknowledged with a reply
+// comment:
+//
+//	/ack                  Add the AckLabel to the issue.
+//	/silence [duration]   Silence the alerts of the issue in Alertmanager.
+//	/assign @user...      Assign the issue; "@me" is the comment author.
+//	/close                Close the issue.
+//
+// Only repository collaborators, or members of the Team when set, may run
+// commands. Commands from other users are ignored without a reply.
+type CommandHandler struct {
+	// Client performs the issue operations.
+	Client CommandClient
+
+	// Silences creates silences for /silence. When nil, /silence is not
+	// available.
+	Silences *SilenceHandler
+
+	// AlertLabel is the label of alert issues. When set, comments on other
+	// issues are ignored.
+	AlertLabel string
+
+	// AckLabel is the label added by /ack.
+	AckLabel string
+
+	// Team is the slug of the organization team whose members may run
+	// commands. When empty, repository collaborators may run commands.
+	Team string
+}
+
+// NewCommandHandler creates a new CommandHandler.
+func NewCommandHandler(client CommandClient, silences *SilenceHandler, alertLabel, ackLabel, team string) *CommandHandler {
+	return &CommandHandler{
+		Client:     client,
+		Silences:   silences,
+		AlertLabel: alertLabel,
+		AckLabel:   ackLabel,
+		Team:       team,
+	}
+}
+
+// HandleEvent processes new issue comments. Other events are ignored.
+func (h *CommandHandler) HandleEvent(ctx context.Context, event interface{}) error {
+	e, ok := event.(*github.IssueCommentEvent)
+	if !ok || e.GetAction() != "created" {
+		return nil
+	}
+	issue := e.GetIssue()
+	if issue == nil || issue.IsPullRequest() || !h.isAlertIssue(issue) {
+		return nil
+	}
+	commands := parseCommands(e.GetComment().GetBody())
+	if len(commands) == 0 {
+		return nil
+	}
+	user := e.GetComment().GetUser().GetLogin()
+	allowed, err := h.allowed(ctx, issue, user)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		logging.FromContext(ctx).Info("Ignoring commands from unauthorized user", "user", user, "url", issue.GetHTMLURL())
+		return nil
+	}
+	var replies []string
+	for _, args := range commands {
+		reply, err := h.run(ctx, issue, user, args)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to run command", "command", args[0], "url", issue.GetHTMLURL(), "error", err)
+			reply = fmt.Sprintf("`%s` failed: %s", args[0], err)
+		}
+		replies = append(replies, reply)
+	}
+	return h.Client.CommentIssue(ctx, issue, fmt.Sprintf("@%s\n\n%s", user, strings.Join(replies, "\n")))
+}
+
+// run runs one command and returns the reply.
+func (h *CommandHandler) run(ctx context.Context, issue *github.Issue, user string, args []string) (string, error) {
+	switch args[0] {
+	case "/ack":
+		if err := h.Client.LabelIssue(ctx, issue, h.AckLabel, true); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Acknowledged by @%s.", user), nil
+	case "/silence":
+		if h.Silences == nil {
+			return "`/silence` is not available: Alertmanager is not configured.", nil
+		}
+		d := h.Silences.SilenceDuration
+		if len(args) > 1 {
+			md, err := model.ParseDuration(args[1])
+			if err != nil || md <= 0 {
+				return fmt.Sprintf("`/silence` needs a positive duration like `2h` or `2d`, not %q.", args[1]), nil
+			}
+			d = time.Duration(md)
+		}
+		s, created, err := h.Silences.createSilence(ctx, issue, user, d)
+		if err == metadata.ErrNoMetadata {
+			return "`/silence` is not available: the issue has no alert metadata.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if !created {
+			return fmt.Sprintf("Alerts are already [silenced](%s) until %s.",
+				h.Silences.Silencer.SilenceURL(s.ID), s.EndsAt.Format(time.RFC3339)), nil
+		}
+		return fmt.Sprintf("Created Alertmanager [silence](%s) until %s.",
+			h.Silences.Silencer.SilenceURL(s.ID), s.EndsAt.Format(time.RFC3339)), nil
+	case "/assign":
+		var users []string
+		for _, arg := range args[1:] {
+			u := strings.TrimPrefix(arg, "@")
+			if u == "me" {
+				u = user
+			}
+			users = append(users, u)
+		}
+		if len(users) == 0 {
+			users = []string{user}
+		}
+		if err := h.Client.AssignIssue(ctx, issue, users); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Assigned to @%s.", strings.Join(users, ", @")), nil
+	case "/close":
+		if _, err := h.Client.CloseIssue(ctx, issue); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Closed by @%s.", user), nil
+	default:
+		return "", fmt.Errorf("unsupported command")
+	}
+}
+
+// allowed reports whether the user may run commands on the issue.
+func (h *CommandHandler) allowed(ctx context.Context, issue *github.Issue, user string) (bool, error) {
+	if user == "" {
+		return false, nil
+	}
+	if h.Team != "" {
+		return h.Client.IsTeamMember(ctx, h.Team, user)
+	}
+	return h.Client.IsCollaborator(ctx, issue, user)
+}
+
+// isAlertIssue reports whether the issue has the AlertLabel.
+func (h *CommandHandler) isAlertIssue(issue *github.Issue) bool {
+	if h.AlertLabel == "" {
+		return true
+	}
+	for _, l := range issue.Labels {
+		if l.GetName() == h.AlertLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCommands returns the fields of every line of the comment that starts
+// with a supported command. Other lines, including paths like /var/log, are
+// not commands.
+func parseCommands(body string) [][]string {
+	var commands [][]string
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && commandNames[fields[0]] {
+			commands = append(commands, fields)
+		}
+	}
+	return commands
+}
@@ -0,0 +1,312 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
+	"github.com/m-lab/alertmanager-github-receiver/events"
+)
+
+// fakeCommandClient records the issue operations of the CommandHandler.
+type fakeCommandClient struct {
+	collaborators map[string]bool
+	team          map[string]bool
+	labels        []string
+	assignees     []string
+	closed        bool
+	comments      []string
+	fail          bool
+}
+
+func (f *fakeCommandClient) CommentIssue(ctx context.Context, issue *github.Issue, body string) error {
+	f.comments = append(f.comments, body)
+	return nil
+}
+
+func (f *fakeCommandClient) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	if f.fail {
+		return fmt.Errorf("fake error")
+	}
+	f.labels = append(f.labels, label)
+	return nil
+}
+
+func (f *fakeCommandClient) AssignIssue(ctx context.Context, issue *github.Issue, users []string) error {
+	f.assignees = append(f.assignees, users...)
+	return nil
+}
+
+func (f *fakeCommandClient) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	f.closed = true
+	return issue, nil
+}
+
+func (f *fakeCommandClient) IsCollaborator(ctx context.Context, issue *github.Issue, user string) (bool, error) {
+	return f.collaborators[user], nil
+}
+
+func (f *fakeCommandClient) IsTeamMember(ctx context.Context, team, user string) (bool, error) {
+	if team != "oncall" {
+		return false, fmt.Errorf("unknown team %q", team)
+	}
+	return f.team[user], nil
+}
+
+// commentEvent returns the recorded issue_comment event with the given body.
+func commentEvent(t *testing.T, body string) *github.IssueCommentEvent {
+	e := &github.IssueCommentEvent{}
+	if err := json.Unmarshal(readTestdata(t, "issue_comment_created.json"), e); err != nil {
+		t.Fatal(err)
+	}
+	e.Comment.Body = github.String(body)
+	return e
+}
+
+func TestCommandHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		team          string
+		noSilences    bool
+		fail          bool
+		wantLabels    []string
+		wantAssignees []string
+		wantClosed    bool
+		wantSilences  int
+		wantReply     string
+	}{
+		{
+			name:       "ack",
+			body:       "/ack",
+			wantLabels: []string{"acknowledged"},
+			wantReply:  "Acknowledged by @octocat.",
+		},
+		{
+			name:         "silence-default-duration",
+			body:         "/silence",
+			wantSilences: 1,
+			wantReply:    "until 2020-06-02T10:00:00Z",
+		},
+		{
+			name:         "silence-duration",
+			body:         "Looking into it.\n/silence 2h",
+			wantSilences: 1,
+			wantReply:    "until 2020-06-01T12:00:00Z",
+		},
+		{
+			name:         "silence-days",
+			body:         "/silence 2d",
+			wantSilences: 1,
+			wantReply:    "until 2020-06-03T10:00:00Z",
+		},
+		{
+			name:      "silence-bad-duration",
+			body:      "/silence soon",
+			wantReply: "needs a positive duration",
+		},
+		{
+			name:       "silence-unavailable",
+			body:       "/silence",
+			noSilences: true,
+			wantReply:  "Alertmanager is not configured",
+		},
+		{
+			name:          "assign-me-and-others",
+			body:          "/assign @me @hubot",
+			wantAssignees: []string{"octocat", "hubot"},
+			wantReply:     "Assigned to @octocat, @hubot.",
+		},
+		{
+			name:       "close",
+			body:       "/close",
+			wantClosed: true,
+			wantReply:  "Closed by @octocat.",
+		},
+		{
+			name:          "multiple-commands",
+			body:          "/ack\n/assign",
+			wantLabels:    []string{"acknowledged"},
+			wantAssignees: []string{"octocat"},
+			wantReply:     "Acknowledged by @octocat.\nAssigned to @octocat.",
+		},
+		{
+			name: "not-a-command",
+			body: "/reboot\n/var/log/syslog is full",
+		},
+		{
+			name:      "failed-command",
+			body:      "/ack",
+			fail:      true,
+			wantReply: "`/ack` failed: fake error",
+		},
+		{
+			name:       "team-member",
+			body:       "/ack",
+			team:       "oncall",
+			wantLabels: []string{"acknowledged"},
+			wantReply:  "Acknowledged by @octocat.",
+		},
+		{
+			name: "no-commands",
+			body: "Is anyone looking at this?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apis := &fakeAPIs{}
+			srv := httptest.NewServer(apis)
+			defer srv.Close()
+			am, err := alertmanager.NewClient(srv.URL + "/am/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &fakeCommandClient{
+				collaborators: map[string]bool{"octocat": true},
+				team:          map[string]bool{"octocat": true},
+				fail:          tt.fail,
+			}
+			silences := events.NewSilenceHandler(am, client, "silence", 24*time.Hour)
+			events.SetNow(silences, func() time.Time { return time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC) })
+			if tt.noSilences {
+				silences = nil
+			}
+			h := events.NewCommandHandler(client, silences, "alert:boom:", "acknowledged", tt.team)
+
+			if err := h.HandleEvent(context.Background(), commentEvent(t, tt.body)); err != nil {
+				t.Fatalf("HandleEvent() error = %v", err)
+			}
+			if fmt.Sprint(client.labels) != fmt.Sprint(tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", client.labels, tt.wantLabels)
+			}
+			if fmt.Sprint(client.assignees) != fmt.Sprint(tt.wantAssignees) {
+				t.Errorf("assignees = %v, want %v", client.assignees, tt.wantAssignees)
+			}
+			if client.closed != tt.wantClosed {
+				t.Errorf("closed = %v, want %v", client.closed, tt.wantClosed)
+			}
+			if len(apis.silences) != tt.wantSilences {
+				t.Errorf("got %d silences, want %d", len(apis.silences), tt.wantSilences)
+			}
+			if tt.wantReply == "" {
+				if len(client.comments) != 0 {
+					t.Errorf("comments = %q, want none", client.comments)
+				}
+				return
+			}
+			if len(client.comments) != 1 || !strings.Contains(client.comments[0], tt.wantReply) {
+				t.Errorf("comments = %q, want one reply with %q", client.comments, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestCommandHandler_permissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		team    string
+		event   func(e *github.IssueCommentEvent)
+		wantErr bool
+		want    string
+	}{
+		{
+			name: "not-a-collaborator",
+			event: func(e *github.IssueCommentEvent) {
+				e.Comment.User.Login = github.String("mallory")
+			},
+		},
+		{
+			name: "not-a-team-member",
+			team: "oncall",
+			event: func(e *github.IssueCommentEvent) {
+				e.Comment.User.Login = github.String("mallory")
+			},
+		},
+		{
+			name:    "team-lookup-error",
+			team:    "missing",
+			event:   func(e *github.IssueCommentEvent) {},
+			wantErr: true,
+		},
+		{
+			name: "ignore-other-issues",
+			event: func(e *github.IssueCommentEvent) {
+				e.Issue.Labels = nil
+			},
+		},
+		{
+			name: "ignore-pull-requests",
+			event: func(e *github.IssueCommentEvent) {
+				e.Issue.PullRequestLinks = &github.PullRequestLinks{URL: github.String("https://api.github.com/repos/fake-org/fake-repo/pulls/1")}
+			},
+		},
+		{
+			name: "ignore-edits",
+			event: func(e *github.IssueCommentEvent) {
+				e.Action = github.String("edited")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeCommandClient{
+				collaborators: map[string]bool{"octocat": true},
+				team:          map[string]bool{"octocat": true},
+			}
+			h := events.NewCommandHandler(client, nil, "alert:boom:", "acknowledged", tt.team)
+			e := commentEvent(t, "/close")
+			tt.event(e)
+
+			err := h.HandleEvent(context.Background(), e)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HandleEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if client.closed {
+				t.Errorf("HandleEvent() closed the issue, want no commands run")
+			}
+			if tt.want == "" && len(client.comments) != 0 {
+				t.Errorf("comments = %q, want none", client.comments)
+			}
+			if tt.want != "" && (len(client.comments) != 1 || client.comments[0] != tt.want) {
+				t.Errorf("comments = %q, want %q", client.comments, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandHandler_replay(t *testing.T) {
+	// The recorded comment runs /ack through the Receiver.
+	r, err := events.NewReceiver(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeCommandClient{collaborators: map[string]bool{"octocat": true}}
+	r.Handle("issue_comment", events.NewCommandHandler(client, nil, "alert:boom:", "acknowledged", ""))
+	if code := postEvent(r, "issue_comment", readTestdata(t, "issue_comment_created.json")); code != http.StatusOK {
+		t.Fatalf("ServeHTTP() code = %d, want %d", code, http.StatusOK)
+	}
+	if len(client.labels) != 1 || client.labels[0] != "acknowledged" {
+		t.Errorf("labels = %v, want [acknowledged]", client.labels)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package events receives Github webhook events for alert issues, so that
+// actions on an issue are reflected back in Alertmanager.
+package events
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// signatureHeader contains the HMAC-SHA256 signature of the webhook payload.
+const signatureHeader = "X-Hub-Signature-256"
+
+var receivedEvents = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "githubreceiver_github_events_total",
+		Help: "Number of incoming Github webhook events.",
+	},
+	// "status" is one of "ok", "ignored", "invalid" or "error".
+	[]string{"event", "status"},
+)
+
+// An EventHandler handles Github webhook events. The event is one of the
+// types returned by github.ParseWebHook, e.g. *github.IssuesEvent or
+// *github.IssueCommentEvent.
+type EventHandler interface {
+	HandleEvent(ctx context.Context, event interface{}) error
+}
+
+// The EventHandlerFunc type is an adapter to allow the use of ordinary
+// functions as EventHandlers.
+type EventHandlerFunc func(ctx context.Context, event interface{}) error
+
+// HandleEvent calls f(ctx, event).
+func (f EventHandlerFunc) HandleEvent(ctx context.Context, event interface{}) error {
+	return f(ctx, event)
+}
+
+// Receiver verifies the signature of Github webhook events, and dispatches
+// them to the EventHandlers registered for the event type. Events without
+// handlers are ignored.
+type Receiver struct {
+	// secret is the webhook secret used to sign event payloads.
+	secret []byte
+	// handlers contains the registered handlers, by event type.
+	handlers map[string][]EventHandler
+}
+
+// NewReceiver creates a Receiver that accepts events signed with the secret.
+// The secret must not be empty.
+func NewReceiver(secret []byte) (*Receiver, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("github webhook secret is empty")
+	}
+	return &Receiver{
+		secret:   secret,
+		handlers: make(map[string][]EventHandler),
+	}, nil
+}
+
+// Handle registers the handler for events of the given type, e.g. "issues" or
+// "issue_comment". Handlers are called in registration order. Handle must
+// not be called after the Receiver starts serving requests.
+func (r *Receiver) Handle(eventType string, h EventHandler) {
+	r.handlers[eventType] = append(r.handlers[eventType], h)
+}
+
+// ServeHTTP receives and dispatches Github webhook events. Requests without a
+// valid signature are rejected.
+func (r *Receiver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	ctx, span := tracing.StartRequest(req, "events.ServeHTTP")
+	defer span.End()
+	ctx, reqID := logging.WithRequestID(ctx)
+	rw.Header().Set(logging.RequestIDHeader, reqID)
+	logger := logging.FromContext(ctx)
+
+	if req.Method != http.MethodPost {
+		logger.Warn("Client used unsupported method", "method", req.Method, "remote", req.RemoteAddr)
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logger.Error("Failed to read request body", "error", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	eventType := github.WebHookType(req)
+	if err := r.verify(req.Header.Get(signatureHeader), payload); err != nil {
+		logger.Warn("Rejected github event", "event", eventType, "remote", req.RemoteAddr, "error", err)
+		// The event type of unverified requests is not trusted.
+		receivedEvents.WithLabelValues("unverified", "invalid").Inc()
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	handlers := r.handlers[eventType]
+	if len(handlers) == 0 {
+		// Includes the "ping" event sent when the webhook is created.
+		logger.Debug("Ignoring github event", "event", eventType)
+		receivedEvents.WithLabelValues(eventType, "ignored").Inc()
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		logger.Warn("Failed to parse github event", "event", eventType, "remote", req.RemoteAddr, "error", err)
+		receivedEvents.WithLabelValues(eventType, "invalid").Inc()
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logger = logger.With("event", eventType)
+	ctx = logging.WithLogger(ctx, logger)
+	for _, h := range handlers {
+		if err := h.HandleEvent(ctx, event); err != nil {
+			logger.Error("Failed to handle github event", "error", err)
+			receivedEvents.WithLabelValues(eventType, "error").Inc()
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	receivedEvents.WithLabelValues(eventType, "ok").Inc()
+	rw.WriteHeader(http.StatusOK)
+}
+
+// verify checks that the signature is the HMAC-SHA256 of the payload.
+// See also: https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries
+func (r *Receiver) verify(signature string, payload []byte) error {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return fmt.Errorf("missing %s header", signatureHeader)
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return fmt.Errorf("malformed %s header: %s", signatureHeader, err)
+	}
+	if !hmac.Equal(got, Sign(r.secret, payload)) {
+		return fmt.Errorf("payload signature does not match")
+	}
+	return nil
+}
+
+// Sign returns the HMAC-SHA256 of the payload using the secret. The
+// X-Hub-Signature-256 header value is "sha256=" followed by the hex encoded
+// signature.
+func Sign(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
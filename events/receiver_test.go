@@ -0,0 +1,199 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/events"
+	"github.com/m-lab/go/prometheusx/promtest"
+)
+
+// testSecret is the webhook secret used to sign test payloads.
+var testSecret = []byte("fake-webhook-secret")
+
+// readTestdata returns the content of a recorded event payload.
+func readTestdata(t *testing.T, name string) []byte {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNewReceiver(t *testing.T) {
+	if _, err := events.NewReceiver(nil); err == nil {
+		t.Errorf("NewReceiver() with empty secret; want error, got nil")
+	}
+}
+
+func TestReceiver_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		eventType  string
+		file       string
+		signature  string
+		handlerErr error
+		wantCode   int
+		wantEvent  string
+	}{
+		{
+			name:      "success-issues-labeled",
+			eventType: "issues",
+			file:      "issues_labeled.json",
+			wantCode:  http.StatusOK,
+			wantEvent: "issues labeled #1 silence",
+		},
+		{
+			name:      "success-issues-closed",
+			eventType: "issues",
+			file:      "issues_closed.json",
+			wantCode:  http.StatusOK,
+			wantEvent: "issues closed #1",
+		},
+		{
+			name:      "success-issue-comment-created",
+			eventType: "issue_comment",
+			file:      "issue_comment_created.json",
+			wantCode:  http.StatusOK,
+			wantEvent: "issue_comment created #1 /ack",
+		},
+		{
+			name:      "success-ignore-ping",
+			eventType: "ping",
+			file:      "ping.json",
+			wantCode:  http.StatusOK,
+		},
+		{
+			name:      "failure-missing-signature",
+			eventType: "issues",
+			file:      "issues_labeled.json",
+			signature: "-",
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "failure-malformed-signature",
+			eventType: "issues",
+			file:      "issues_labeled.json",
+			signature: "sha256=zz",
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "failure-wrong-signature",
+			eventType: "issues",
+			file:      "issues_labeled.json",
+			signature: "sha256=0123456789abcdef",
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "failure-method",
+			method:    http.MethodGet,
+			eventType: "issues",
+			file:      "issues_labeled.json",
+			wantCode:  http.StatusMethodNotAllowed,
+		},
+		{
+			name:      "success-mismatched-payload",
+			eventType: "issue_comment",
+			file:      "ping.json",
+			wantCode:  http.StatusOK,
+			wantEvent: "issue_comment  #0 ",
+		},
+		{
+			name:       "failure-handler-error",
+			eventType:  "issues",
+			file:       "issues_closed.json",
+			handlerErr: fmt.Errorf("fake error"),
+			wantCode:   http.StatusInternalServerError,
+			wantEvent:  "issues closed #1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := events.NewReceiver(testSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			record := events.EventHandlerFunc(func(ctx context.Context, event interface{}) error {
+				switch e := event.(type) {
+				case *github.IssuesEvent:
+					got = fmt.Sprintf("issues %s #%d", e.GetAction(), e.GetIssue().GetNumber())
+					if e.Label != nil {
+						got += " " + e.GetLabel().GetName()
+					}
+				case *github.IssueCommentEvent:
+					got = fmt.Sprintf("issue_comment %s #%d %s",
+						e.GetAction(), e.GetIssue().GetNumber(), e.GetComment().GetBody())
+				}
+				return tt.handlerErr
+			})
+			r.Handle("issues", record)
+			r.Handle("issue_comment", record)
+
+			payload := readTestdata(t, tt.file)
+			method := http.MethodPost
+			if tt.method != "" {
+				method = tt.method
+			}
+			req := httptest.NewRequest(method, "/v1/github", bytes.NewReader(payload))
+			req.Header.Set("X-GitHub-Event", tt.eventType)
+			switch tt.signature {
+			case "":
+				req.Header.Set("X-Hub-Signature-256", fmt.Sprintf("sha256=%x", events.Sign(testSecret, payload)))
+			case "-":
+			default:
+				req.Header.Set("X-Hub-Signature-256", tt.signature)
+			}
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", rw.Code, tt.wantCode)
+			}
+			if got != tt.wantEvent {
+				t.Errorf("ServeHTTP() dispatched %q, want %q", got, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestReceiver_badPayload(t *testing.T) {
+	r, err := events.NewReceiver(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Handle("issues", events.EventHandlerFunc(func(ctx context.Context, event interface{}) error {
+		t.Errorf("handler called for bad payload")
+		return nil
+	}))
+	if code := postEvent(r, "issues", []byte(`{`)); code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() code = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	// Metrics are registered by the requests of earlier tests.
+	promtest.LintMetrics(t)
+}
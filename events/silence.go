@@ -0,0 +1,188 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+)
+
+// Silencer defines the Alertmanager silence operations needed by the
+// SilenceHandler.
+type Silencer interface {
+	CreateSilence(ctx context.Context, s *alertmanager.Silence) (string, error)
+	ListSilences(ctx context.Context) ([]*alertmanager.Silence, error)
+	ExpireSilence(ctx context.Context, id string) error
+	SilenceURL(id string) string
+}
+
+// Commenter defines the issue operations needed by the SilenceHandler.
+type Commenter interface {
+	CommentIssue(ctx context.Context, issue *github.Issue, body string) error
+}
+
+// SilenceHandler handles Github issues events. When an alert issue is given
+// the SilenceLabel, the SilenceHandler silences the alerts of the issue in
+// Alertmanager. When the label is removed or the issue is closed, the silence
+// is expired. Both actions are reported in a comment on the issue.
+type SilenceHandler struct {
+	// Silencer creates and expires silences in Alertmanager.
+	Silencer Silencer
+
+	// Commenter adds comments to issues.
+	Commenter Commenter
+
+	// SilenceLabel is the issue label that requests a silence.
+	SilenceLabel string
+
+	// SilenceDuration is how long new silences last.
+	SilenceDuration time.Duration
+
+	// now returns the current time.
+	now func() time.Time
+}
+
+// NewSilenceHandler creates a new SilenceHandler.
+func NewSilenceHandler(silencer Silencer, commenter Commenter, silenceLabel string, silenceDuration time.Duration) *SilenceHandler {
+	return &SilenceHandler{
+		Silencer:        silencer,
+		Commenter:       commenter,
+		SilenceLabel:    silenceLabel,
+		SilenceDuration: silenceDuration,
+		now:             time.Now,
+	}
+}
+
+// HandleEvent processes issues events. Other events are ignored.
+func (h *SilenceHandler) HandleEvent(ctx context.Context, event interface{}) error {
+	if e, ok := event.(*github.IssuesEvent); ok {
+		return h.processIssuesEvent(ctx, e)
+	}
+	return nil
+}
+
+// processIssuesEvent creates or expires the silence of the event issue.
+func (h *SilenceHandler) processIssuesEvent(ctx context.Context, event *github.IssuesEvent) error {
+	issue := event.GetIssue()
+	switch event.GetAction() {
+	case "labeled":
+		if h.SilenceLabel != "" && event.GetLabel().GetName() == h.SilenceLabel {
+			return h.silence(ctx, issue, event.GetSender().GetLogin())
+		}
+	case "unlabeled":
+		if h.SilenceLabel != "" && event.GetLabel().GetName() == h.SilenceLabel {
+			return h.expire(ctx, issue)
+		}
+	case "closed":
+		return h.expire(ctx, issue)
+	}
+	return nil
+}
+
+// silence creates a silence for the alerts of the issue, unless one already
+// exists, and comments the silence link on the issue.
+func (h *SilenceHandler) silence(ctx context.Context, issue *github.Issue, user string) error {
+	s, created, err := h.createSilence(ctx, issue, user, h.SilenceDuration)
+	if err == metadata.ErrNoMetadata {
+		logging.FromContext(ctx).Info("Ignoring issue without alert metadata", "url", issue.GetHTMLURL())
+		return nil
+	}
+	if err != nil || !created {
+		return err
+	}
+	return h.Commenter.CommentIssue(ctx, issue, fmt.Sprintf(
+		"Created Alertmanager [silence](%s) until %s.", h.Silencer.SilenceURL(s.ID), s.EndsAt.Format(time.RFC3339)))
+}
+
+// createSilence creates a silence lasting d for the alerts of the issue. If
+// the issue already has a silence, createSilence returns it and created is
+// false.
+func (h *SilenceHandler) createSilence(ctx context.Context, issue *github.Issue, user string, d time.Duration) (s *alertmanager.Silence, created bool, err error) {
+	meta, err := metadata.Parse(issue.GetBody())
+	if err != nil {
+		return nil, false, err
+	}
+	existing, err := h.findSilences(ctx, issue)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(existing) != 0 {
+		return existing[0], false, nil
+	}
+	if user == "" {
+		user = "alertmanager-github-receiver"
+	}
+	now := h.now().UTC()
+	s = &alertmanager.Silence{
+		Matchers:  alertmanager.EqualMatchers(meta.Matchers()),
+		StartsAt:  now,
+		EndsAt:    now.Add(d),
+		CreatedBy: user,
+		Comment:   silenceComment(issue),
+	}
+	s.ID, err = h.Silencer.CreateSilence(ctx, s)
+	if err != nil {
+		return nil, false, err
+	}
+	logging.FromContext(ctx).Info("Created silence", "silence", s.ID, "url", issue.GetHTMLURL())
+	return s, true, nil
+}
+
+// expire expires all silences created for the issue, and comments on the
+// issue if there were any.
+func (h *SilenceHandler) expire(ctx context.Context, issue *github.Issue) error {
+	silences, err := h.findSilences(ctx, issue)
+	if err != nil || len(silences) == 0 {
+		return err
+	}
+	for _, s := range silences {
+		if err := h.Silencer.ExpireSilence(ctx, s.ID); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("Expired silence", "silence", s.ID, "url", issue.GetHTMLURL())
+	}
+	return h.Commenter.CommentIssue(ctx, issue, "Expired the Alertmanager silence of this issue.")
+}
+
+// findSilences returns the active and pending silences created for the issue.
+func (h *SilenceHandler) findSilences(ctx context.Context, issue *github.Issue) ([]*alertmanager.Silence, error) {
+	silences, err := h.Silencer.ListSilences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []*alertmanager.Silence
+	for _, s := range silences {
+		if s.Status != nil && s.Status.State == "expired" {
+			continue
+		}
+		if s.Comment == silenceComment(issue) {
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
+
+// silenceComment returns the comment of silences created for the issue. The
+// comment identifies the silences of an issue, so it must not change.
+func silenceComment(issue *github.Issue) string {
+	return "Silenced from " + issue.GetHTMLURL()
+}
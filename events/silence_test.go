@@ -0,0 +1,234 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package events_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alertmanager"
+	"github.com/m-lab/alertmanager-github-receiver/events"
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+)
+
+// fakeAPIs is a local stand-in for the Alertmanager and Github APIs.
+type fakeAPIs struct {
+	mu       sync.Mutex
+	silences []*alertmanager.Silence
+	comments []string
+	// fail causes all API requests to fail.
+	fail bool
+}
+
+func (f *fakeAPIs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.URL.Path == "/am/api/v2/silences" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(f.silences)
+	case r.URL.Path == "/am/api/v2/silences" && r.Method == http.MethodPost:
+		s := &alertmanager.Silence{}
+		json.NewDecoder(r.Body).Decode(s)
+		s.ID = fmt.Sprint(len(f.silences) + 1)
+		s.Status = &alertmanager.SilenceStatus{State: "active"}
+		f.silences = append(f.silences, s)
+		fmt.Fprintf(w, `{"silenceID": %q}`, s.ID)
+	case strings.HasPrefix(r.URL.Path, "/am/api/v2/silence/") && r.Method == http.MethodDelete:
+		for _, s := range f.silences {
+			if s.ID == strings.TrimPrefix(r.URL.Path, "/am/api/v2/silence/") {
+				s.Status.State = "expired"
+			}
+		}
+	case r.URL.Path == "/repos/fake-org/fake-repo/issues/1/comments":
+		c := &github.IssueComment{}
+		json.NewDecoder(r.Body).Decode(c)
+		f.comments = append(f.comments, c.GetBody())
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id": 1}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// setupHandler starts the fake APIs and returns a Receiver that dispatches
+// issues events to a SilenceHandler that uses them.
+func setupHandler(t *testing.T) (*events.Receiver, *fakeAPIs, func()) {
+	apis := &fakeAPIs{}
+	srv := httptest.NewServer(apis)
+	am, err := alertmanager.NewClient(srv.URL + "/am/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gh := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	gh.GithubClient.BaseURL, _ = url.Parse(srv.URL + "/")
+	r, err := events.NewReceiver(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Handle("issues", events.NewSilenceHandler(am, gh, "silence", 2*time.Hour))
+	return r, apis, srv.Close
+}
+
+// issuesEvent returns the payload of an issues event for an alert issue.
+func issuesEvent(t *testing.T, action, label string, withMetadata bool) []byte {
+	body := "issue body"
+	if withMetadata {
+		meta, err := metadata.Format(&metadata.Metadata{
+			GroupLabels: map[string]string{"alertname": "DiskRunningFull"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body += meta
+	}
+	event := &github.IssuesEvent{
+		Action: github.String(action),
+		Issue: &github.Issue{
+			Number:        github.Int(1),
+			Title:         github.String("DiskRunningFull"),
+			Body:          github.String(body),
+			HTMLURL:       github.String("https://github.com/fake-org/fake-repo/issues/1"),
+			RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+		},
+		Label:  &github.Label{Name: github.String(label)},
+		Sender: &github.User{Login: github.String("octocat")},
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// postEvent sends the signed event payload to the handler and returns the
+// response status code.
+func postEvent(h http.Handler, eventType string, payload []byte) int {
+	req := httptest.NewRequest(http.MethodPost, "/v1/github", bytes.NewReader(payload))
+	req.Header.Set("X-GitHub-Event", eventType)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(events.Sign(testSecret, payload)))
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	return rw.Code
+}
+
+func TestSilenceHandler_lifecycle(t *testing.T) {
+	h, apis, teardown := setupHandler(t)
+	defer teardown()
+
+	// Labeling twice creates a single silence.
+	for i := 0; i < 2; i++ {
+		if code := postEvent(h, "issues", issuesEvent(t, "labeled", "silence", true)); code != http.StatusOK {
+			t.Fatalf("labeled event returned %d, want %d", code, http.StatusOK)
+		}
+	}
+	if len(apis.silences) != 1 {
+		t.Fatalf("got %d silences, want 1", len(apis.silences))
+	}
+	s := apis.silences[0]
+	want := []alertmanager.Matcher{{Name: "alertname", Value: "DiskRunningFull", IsEqual: true}}
+	if len(s.Matchers) != 1 || s.Matchers[0] != want[0] {
+		t.Errorf("silence matchers = %v, want %v", s.Matchers, want)
+	}
+	if s.CreatedBy != "octocat" || s.EndsAt.Sub(s.StartsAt) != 2*time.Hour {
+		t.Errorf("silence = %#v, want created by octocat for 2h", s)
+	}
+	if len(apis.comments) != 1 || !strings.Contains(apis.comments[0], "/am/#/silences/1") {
+		t.Errorf("comments = %q, want one silence link", apis.comments)
+	}
+
+	// Closing the issue expires the silence.
+	if code := postEvent(h, "issues", issuesEvent(t, "closed", "", true)); code != http.StatusOK {
+		t.Fatalf("closed event returned %d, want %d", code, http.StatusOK)
+	}
+	if s.Status.State != "expired" {
+		t.Errorf("silence state = %q, want expired", s.Status.State)
+	}
+	if len(apis.comments) != 2 {
+		t.Errorf("comments = %q, want an expiry comment", apis.comments)
+	}
+}
+
+func TestSilenceHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      []byte
+		fail         bool
+		wantCode     int
+		wantSilences int
+	}{
+		{
+			name:         "success-labeled",
+			payload:      issuesEvent(t, "labeled", "silence", true),
+			wantCode:     http.StatusOK,
+			wantSilences: 1,
+		},
+		{
+			name:         "success-recorded-labeled",
+			payload:      readTestdata(t, "issues_labeled.json"),
+			wantCode:     http.StatusOK,
+			wantSilences: 1,
+		},
+		{
+			name:     "success-other-label",
+			payload:  issuesEvent(t, "labeled", "bug", true),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "success-without-metadata",
+			payload:  issuesEvent(t, "labeled", "silence", false),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "success-recorded-unlabeled-without-silence",
+			payload:  readTestdata(t, "issues_unlabeled.json"),
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "failure-api-error",
+			payload:  issuesEvent(t, "labeled", "silence", true),
+			fail:     true,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, apis, teardown := setupHandler(t)
+			defer teardown()
+			apis.fail = tt.fail
+
+			if code := postEvent(h, "issues", tt.payload); code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", code, tt.wantCode)
+			}
+			if len(apis.silences) != tt.wantSilences {
+				t.Errorf("got %d silences, want %d", len(apis.silences), tt.wantSilences)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package issues
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+)
+
+const (
+	// defaultPerPage is the default number of issues per page.
+	defaultPerPage = 50
+	// maxPerPage is the largest number of issues per page.
+	maxPerPage = 500
+)
+
+// APIIssue is the JSON representation of an open alert issue.
+type APIIssue struct {
+	Number    int       `json:"number"`
+	Repo      string    `json:"repo"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Labels    []string  `json:"labels"`
+	State     string    `json:"state"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Assignees []string  `json:"assignees"`
+	// Alert identifies the alert group of the issue, if known.
+	Alert *metadata.Metadata `json:"alert"`
+}
+
+// APIResponse is the JSON response of the APIHandler.
+type APIResponse struct {
+	Issues []*APIIssue `json:"issues"`
+	// Total is the number of issues matching the filters, on all pages.
+	Total   int `json:"total"`
+	Page    int `json:"page"`
+	PerPage int `json:"perPage"`
+	// NextPage is the number of the next page, or zero on the last page.
+	NextPage int `json:"nextPage"`
+}
+
+// APIHandler serves open alert issues as JSON. The query parameters "title"
+// (a regular expression), "repo", "label" (may be repeated), "min_age" and
+// "max_age" (durations like "1h") filter the issues, and "page" and
+// "per_page" select a page of the result.
+type APIHandler struct {
+	ListClient
+}
+
+// ServeHTTP lists open issues as JSON, ordered by repository and number.
+func (h *APIHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(rw, "Wrong method\n")
+		return
+	}
+	q := req.URL.Query()
+	f, err := parseFilter(q)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "%s\n", err)
+		return
+	}
+	page, err := parseInt(q, "page", 1, 1, 0)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "%s\n", err)
+		return
+	}
+	perPage, err := parseInt(q, "per_page", defaultPerPage, 1, maxPerPage)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "%s\n", err)
+		return
+	}
+	issues, err := h.ListOpenIssues(req.Context())
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(rw, "%s\n", err)
+		return
+	}
+
+	var matched []*github.Issue
+	for _, issue := range issues {
+		if f.Match(issue) {
+			matched = append(matched, issue)
+		}
+	}
+	sortIssues(matched)
+	resp := &APIResponse{
+		Issues:  []*APIIssue{},
+		Total:   len(matched),
+		Page:    page,
+		PerPage: perPage,
+	}
+	// Pages past the last are empty. Their start may not even fit in an int.
+	if page-1 <= len(matched)/perPage {
+		start := (page - 1) * perPage
+		for i := start; i < len(matched) && i < start+perPage; i++ {
+			resp.Issues = append(resp.Issues, newAPIIssue(matched[i]))
+		}
+		if start+perPage < len(matched) {
+			resp.NextPage = page + 1
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		slog.Error("Failed to write issues", "error", err)
+	}
+}
+
+// newAPIIssue returns the JSON representation of the issue.
+func newAPIIssue(issue *github.Issue) *APIIssue {
+	a := &APIIssue{
+		Number:    issue.GetNumber(),
+		Repo:      RepoName(issue),
+		Title:     issue.GetTitle(),
+		URL:       issue.GetHTMLURL(),
+		Labels:    []string{},
+		State:     issue.GetState(),
+		CreatedAt: issue.GetCreatedAt(),
+		UpdatedAt: issue.GetUpdatedAt(),
+		Assignees: []string{},
+	}
+	for _, l := range issue.Labels {
+		a.Labels = append(a.Labels, l.GetName())
+	}
+	for _, u := range issue.Assignees {
+		a.Assignees = append(a.Assignees, u.GetLogin())
+	}
+	if meta, err := metadata.Parse(issue.GetBody()); err == nil {
+		a.Alert = meta
+	}
+	return a
+}
+
+// parseFilter returns the issue filter given by the query parameters.
+func parseFilter(q url.Values) (*Filter, error) {
+	f := &Filter{
+		Repo:   q.Get("repo"),
+		Labels: q["label"],
+		Now:    time.Now(),
+	}
+	var err error
+	if s := q.Get("title"); s != "" {
+		if f.Title, err = regexp.Compile(s); err != nil {
+			return nil, fmt.Errorf("invalid title: %s", err)
+		}
+	}
+	if s := q.Get("min_age"); s != "" {
+		if f.MinAge, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid min_age: %s", err)
+		}
+	}
+	if s := q.Get("max_age"); s != "" {
+		if f.MaxAge, err = time.ParseDuration(s); err != nil {
+			return nil, fmt.Errorf("invalid max_age: %s", err)
+		}
+	}
+	return f, nil
+}
+
+// parseInt returns the named integer query parameter, or def if it is not
+// set. The value must be at least lo, and at most hi unless hi is zero.
+func parseInt(q url.Values, name string, def, lo, hi int) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || (hi > 0 && v > hi) {
+		return 0, fmt.Errorf("invalid %s: %q", name, s)
+	}
+	return v, nil
+}
+
+// sortIssues sorts the issues by repository and issue number.
+func sortIssues(issues []*github.Issue) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		ri, rj := RepoName(issues[i]), RepoName(issues[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return issues[i].GetNumber() < issues[j].GetNumber()
+	})
+}
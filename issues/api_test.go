@@ -0,0 +1,224 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package issues
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+)
+
+// newAPITestIssues returns open issues in two repositories. Issue #2 has alert
+// metadata, a label and an assignee.
+func newAPITestIssues(t *testing.T) []*github.Issue {
+	meta, err := metadata.Format(&metadata.Metadata{
+		Receiver:    "github",
+		GroupLabels: map[string]string{"alertname": "DiskRunningFull"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	newIssue := func(repo string, number int, created time.Time) *github.Issue {
+		return &github.Issue{
+			Number:        github.Int(number),
+			Title:         github.String(fmt.Sprintf("alert %d", number)),
+			Body:          github.String("body"),
+			State:         github.String("open"),
+			RepositoryURL: github.String("https://api.github.com/repos/fake-org/" + repo),
+			HTMLURL:       github.String(fmt.Sprintf("https://github.com/fake-org/%s/issues/%d", repo, number)),
+			CreatedAt:     &created,
+			UpdatedAt:     &created,
+		}
+	}
+	i2 := newIssue("repo-a", 2, old)
+	i2.Body = github.String("body" + meta)
+	i2.Labels = []github.Label{{Name: github.String("storage")}}
+	i2.Assignees = []*github.User{{Login: github.String("octocat")}}
+	return []*github.Issue{
+		newIssue("repo-b", 1, now),
+		i2,
+		newIssue("repo-a", 1, now),
+	}
+}
+
+func TestAPIHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		query        string
+		listErr      error
+		wantStatus   int
+		wantIssues   []string
+		wantTotal    int
+		wantNextPage int
+	}{
+		{
+			name:       "success-all",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{"repo-a#1", "repo-a#2", "repo-b#1"},
+			wantTotal:  3,
+		},
+		{
+			name:       "success-filter-repo",
+			query:      "?repo=repo-b",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{"repo-b#1"},
+			wantTotal:  1,
+		},
+		{
+			name:       "success-filter-title",
+			query:      "?title=2$",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{"repo-a#2"},
+			wantTotal:  1,
+		},
+		{
+			name:       "failure-bad-title",
+			query:      "?title=(",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "success-filter-label",
+			query:      "?label=storage",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{"repo-a#2"},
+			wantTotal:  1,
+		},
+		{
+			name:       "success-filter-age",
+			query:      "?min_age=1h&max_age=72h",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{"repo-a#2"},
+			wantTotal:  1,
+		},
+		{
+			name:         "success-first-page",
+			query:        "?per_page=2",
+			wantStatus:   http.StatusOK,
+			wantIssues:   []string{"repo-a#1", "repo-a#2"},
+			wantTotal:    3,
+			wantNextPage: 2,
+		},
+		{
+			name:       "success-last-page",
+			query:      "?per_page=2&page=2",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{"repo-b#1"},
+			wantTotal:  3,
+		},
+		{
+			name:       "success-past-last-page",
+			query:      "?page=5",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{},
+			wantTotal:  3,
+		},
+		{
+			name:       "success-huge-page",
+			query:      "?page=9223372036854775807&per_page=500",
+			wantStatus: http.StatusOK,
+			wantIssues: []string{},
+			wantTotal:  3,
+		},
+		{
+			name:       "failure-bad-page",
+			query:      "?page=0",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "failure-bad-per-page",
+			query:      "?per_page=1000",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "failure-bad-min-age",
+			query:      "?min_age=old",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "failure-bad-max-age",
+			query:      "?max_age=new",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "failure-list-error",
+			listErr:    fmt.Errorf("fake error"),
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "failure-method",
+			method:     http.MethodPost,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodGet
+			if tt.method != "" {
+				method = tt.method
+			}
+			h := &APIHandler{ListClient: &fakeClient{issues: newAPITestIssues(t), err: tt.listErr}}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(method, "/api/v1/issues"+tt.query, nil))
+			if rw.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() code = %d, want %d", rw.Code, tt.wantStatus)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+			resp := &APIResponse{}
+			if err := json.Unmarshal(rw.Body.Bytes(), resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, i := range resp.Issues {
+				got = append(got, fmt.Sprintf("%s#%d", i.Repo, i.Number))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantIssues) {
+				t.Errorf("ServeHTTP() issues = %v, want %v", got, tt.wantIssues)
+			}
+			if resp.Total != tt.wantTotal || resp.NextPage != tt.wantNextPage {
+				t.Errorf("ServeHTTP() total = %d, nextPage = %d; want %d, %d",
+					resp.Total, resp.NextPage, tt.wantTotal, tt.wantNextPage)
+			}
+		})
+	}
+}
+
+func TestAPIHandler_issue(t *testing.T) {
+	h := &APIHandler{ListClient: &fakeClient{issues: newAPITestIssues(t)}}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/v1/issues?label=storage", nil))
+	resp := &APIResponse{}
+	if err := json.Unmarshal(rw.Body.Bytes(), resp); err != nil || len(resp.Issues) != 1 {
+		t.Fatalf("ServeHTTP() = %q, want one issue", rw.Body.String())
+	}
+	got := resp.Issues[0]
+	if got.Title != "alert 2" || got.State != "open" || got.URL != "https://github.com/fake-org/repo-a/issues/2" ||
+		fmt.Sprint(got.Labels) != "[storage]" || fmt.Sprint(got.Assignees) != "[octocat]" || got.CreatedAt.IsZero() {
+		t.Errorf("ServeHTTP() issue = %+v, want issue #2 details", got)
+	}
+	if got.Alert == nil || got.Alert.GroupLabels["alertname"] != "DiskRunningFull" {
+		t.Errorf("ServeHTTP() alert = %+v, want DiskRunningFull metadata", got.Alert)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package dryrun provides a client that reads issues from a real backend, but
+// only logs and records the changes it would make.
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultMaxMutations is the default number of recorded mutations.
+const DefaultMaxMutations = 1000
+
+var dryRunMutations = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "githubreceiver_dryrun_mutations_total",
+		Help: "Number of issue changes skipped in dry-run mode.",
+	},
+	// "operation" is one of "create", "label", "unlabel", "close", "reopen" or
+	// "retitle".
+	[]string{"operation"},
+)
+
+// RequestBuilder is implemented by clients that can describe the API request
+// of an operation without sending it, e.g. *issues.Client. A nil request means
+// that the operation sends no request.
+type RequestBuilder interface {
+	NewCreateIssueRequest(repo, title, body string, extra []string) (*http.Request, error)
+	NewLabelIssueRequest(issue *github.Issue, label string, add bool) (*http.Request, error)
+	NewCloseIssueRequest(issue *github.Issue) (*http.Request, error)
+}
+
+// Mutation is an issue change that was skipped.
+type Mutation struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Title     string    `json:"title"`
+	Label     string    `json:"label,omitempty"`
+	// NewTitle is the title given by a retitle.
+	NewTitle string `json:"newTitle,omitempty"`
+
+	// Method, URL and Body describe the API request of the mutation, when the
+	// client is a RequestBuilder.
+	Method string          `json:"method,omitempty"`
+	URL    string          `json:"url,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Client lists open issues using the wrapped client, and logs and records all
+// other operations instead of performing them. Client also serves the recorded
+// mutations as JSON.
+type Client struct {
+	// client performs the read-only operations.
+	client alerts.ReceiverClient
+	// limit is the number of recorded mutations.
+	limit int
+
+	// mu protects mutations.
+	mu sync.Mutex
+	// mutations contains the most recent mutations, oldest first.
+	mutations []Mutation
+}
+
+// NewClient creates a dry-run Client for the given client, that records up to
+// limit mutations. If limit is not positive, DefaultMaxMutations is used.
+func NewClient(client alerts.ReceiverClient, limit int) *Client {
+	if limit <= 0 {
+		limit = DefaultMaxMutations
+	}
+	return &Client{client: client, limit: limit}
+}
+
+// ListOpenIssues returns the open issues of the wrapped client.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	return c.client.ListOpenIssues(ctx)
+}
+
+// CreateIssue records the new issue and returns it without creating it. The
+// returned issue has no number, URL or repository, since it does not exist.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	m := Mutation{Operation: "create", Title: title}
+	if b, ok := c.client.(RequestBuilder); ok {
+		req, err := b.NewCreateIssueRequest(repo, title, body, extra)
+		if err != nil {
+			return nil, err
+		}
+		setRequest(ctx, &m, req)
+	}
+	c.record(ctx, m)
+	return &github.Issue{
+		Title: github.String(title),
+		Body:  github.String(body),
+		State: github.String("open"),
+	}, nil
+}
+
+// LabelIssue records the label change without performing it.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	m := Mutation{Operation: "label", Title: issue.GetTitle(), Label: label}
+	if !add {
+		m.Operation = "unlabel"
+	}
+	if b, ok := c.client.(RequestBuilder); ok {
+		req, err := b.NewLabelIssueRequest(issue, label, add)
+		if err != nil || req == nil {
+			return err
+		}
+		setRequest(ctx, &m, req)
+	} else if label == "" {
+		return nil
+	}
+	c.record(ctx, m)
+	return nil
+}
+
+// CloseIssue records the close and returns a closed copy of the issue without
+// closing it.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	m := Mutation{Operation: "close", Title: issue.GetTitle()}
+	if b, ok := c.client.(RequestBuilder); ok {
+		req, err := b.NewCloseIssueRequest(issue)
+		if err != nil {
+			return nil, err
+		}
+		setRequest(ctx, &m, req)
+	}
+	c.record(ctx, m)
+	closed := *issue
+	closed.State = github.String("closed")
+	return &closed, nil
+}
+
+// RetitleIssue records the title change and returns a retitled copy of the
+// issue without changing it.
+func (c *Client) RetitleIssue(ctx context.Context, issue *github.Issue, title string) (*github.Issue, error) {
+	c.record(ctx, Mutation{Operation: "retitle", Title: issue.GetTitle(), NewTitle: title})
+	retitled := *issue
+	retitled.Title = github.String(title)
+	return &retitled, nil
+}
+
+// GetIssue returns the current issue from the wrapped client, or
+// errors.ErrUnsupported if the wrapped client cannot read issues or the issue
+// was returned by CreateIssue and does not exist.
+func (c *Client) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	getter, ok := c.client.(alerts.IssueGetter)
+	if !ok || issue.GetNumber() == 0 {
+		return nil, errors.ErrUnsupported
+	}
+	return getter.GetIssue(ctx, issue)
+}
+
+// ReopenIssue records the reopen and returns an open copy of the issue
+// without reopening it. ReopenIssue returns errors.ErrUnsupported if the
+// wrapped client cannot reopen issues.
+func (c *Client) ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	if _, ok := c.client.(alerts.IssueReopener); !ok {
+		return nil, errors.ErrUnsupported
+	}
+	c.record(ctx, Mutation{Operation: "reopen", Title: issue.GetTitle()})
+	reopened := *issue
+	reopened.State = github.String("open")
+	reopened.ClosedAt = nil
+	return &reopened, nil
+}
+
+// Mutations returns the recorded mutations, oldest first.
+func (c *Client) Mutations() []Mutation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Mutation(nil), c.mutations...)
+}
+
+// ServeHTTP writes the recorded mutations as a JSON array, oldest first.
+func (c *Client) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	m := c.Mutations()
+	if m == nil {
+		m = []Mutation{}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(m); err != nil {
+		slog.Error("Failed to write dry-run mutations", "error", err)
+	}
+}
+
+// record logs and saves the mutation, dropping the oldest mutation when full.
+func (c *Client) record(ctx context.Context, m Mutation) {
+	m.Time = time.Now()
+	if m.Method != "" {
+		logging.FromContext(ctx).Info("Dry run", "operation", m.Operation, "title", m.Title,
+			"method", m.Method, "url", m.URL, "body", string(m.Body))
+	} else {
+		logging.FromContext(ctx).Info("Dry run", "operation", m.Operation, "title", m.Title, "label", m.Label)
+	}
+	dryRunMutations.WithLabelValues(m.Operation).Inc()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.mutations) >= c.limit {
+		c.mutations = c.mutations[1:]
+	}
+	c.mutations = append(c.mutations, m)
+}
+
+// setRequest copies the method, URL and body of the request to the mutation.
+func setRequest(ctx context.Context, m *Mutation, req *http.Request) {
+	m.Method = req.Method
+	m.URL = req.URL.String()
+	if req.Body == nil {
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to read dry-run request body", "error", err)
+		return
+	}
+	if body = bytes.TrimSpace(body); len(body) != 0 {
+		m.Body = body
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package dryrun_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/m-lab/alertmanager-github-receiver/issues/dryrun"
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+	"github.com/m-lab/go/prometheusx/promtest"
+)
+
+func TestClient_github(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+	})
+	mux.HandleFunc("/search/issues", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"total_count": 1, "items": [{"number": 1, "title": "disk full",
+			"repository_url": "https://api.github.com/repos/fake-org/fake-repo"}]}`)
+	})
+	gh := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+	gh.GithubClient.BaseURL, _ = url.Parse(srv.URL + "/")
+	c := dryrun.NewClient(gh, 0)
+
+	open, err := c.ListOpenIssues(context.Background())
+	if err != nil || len(open) != 1 {
+		t.Fatalf("ListOpenIssues() = %v, %v; want one issue", open, err)
+	}
+	created, err := c.CreateIssue(context.Background(), "fake-repo", "new alert", "body", []string{"extra"})
+	if err != nil || created.GetTitle() != "new alert" {
+		t.Errorf("CreateIssue() = %v, %v; want issue titled %q", created, err, "new alert")
+	}
+	if err := c.LabelIssue(context.Background(), open[0], "resolved", true); err != nil {
+		t.Errorf("LabelIssue() error = %v", err)
+	}
+	if err := c.LabelIssue(context.Background(), open[0], "resolved", false); err != nil {
+		t.Errorf("LabelIssue() error = %v", err)
+	}
+	if err := c.LabelIssue(context.Background(), open[0], "", false); err != nil {
+		t.Errorf("LabelIssue() error = %v", err)
+	}
+	closed, err := c.CloseIssue(context.Background(), open[0])
+	if err != nil || closed.GetState() != "closed" || open[0].GetState() == "closed" {
+		t.Errorf("CloseIssue() = %v, %v; want closed copy", closed, err)
+	}
+	if _, err := c.CloseIssue(context.Background(), &github.Issue{}); err == nil {
+		t.Errorf("CloseIssue() got nil error for empty RepositoryURL, want error")
+	}
+
+	want := []struct {
+		op, method, path, body string
+	}{
+		{"create", "POST", "/repos/fake-org/fake-repo/issues", `{"title":"new alert","body":"body","labels":["alert:boom:","extra"]}`},
+		{"label", "POST", "/repos/fake-org/fake-repo/issues/1/labels", `["resolved"]`},
+		{"unlabel", "DELETE", "/repos/fake-org/fake-repo/issues/1/labels/resolved", ``},
+		{"close", "PATCH", "/repos/fake-org/fake-repo/issues/1", `{"state":"closed"}`},
+	}
+	got := c.Mutations()
+	if len(got) != len(want) {
+		t.Fatalf("Mutations() = %d mutations, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		m := got[i]
+		if m.Operation != w.op || m.Method != w.method || m.URL != srv.URL+w.path || string(m.Body) != w.body {
+			t.Errorf("Mutations()[%d] = %s %s %s %s, want %s %s %s %s",
+				i, m.Operation, m.Method, m.URL, m.Body, w.op, w.method, srv.URL+w.path, w.body)
+		}
+	}
+}
+
+func TestClient_receiverFiringTwice(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+	})
+	// Issues created in a dry run are never found.
+	mux.HandleFunc("/search/issues", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"total_count": 0, "items": []}`)
+	})
+	gh := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+	gh.GithubClient.BaseURL, _ = url.Parse(srv.URL + "/")
+	rh, err := alerts.NewReceiver(dryrun.NewClient(gh, 0), "fake-repo", false, "", nil, alerts.DefaultTitleTmpl, alerts.DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := `{"status": "firing", "groupLabels": {"alertname": "DiskRunningFull"},
+		"alerts": [{"status": "firing", "labels": {"alertname": "DiskRunningFull"}}]}`
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		rh.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/v1/receiver", strings.NewReader(msg)))
+		res := &alerts.Result{}
+		if err := json.Unmarshal(rw.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		if rw.Code != http.StatusOK || res.Action != alerts.ActionCreated {
+			t.Errorf("notification %d got %d %+v, want %d %s", i+1, rw.Code, res, http.StatusOK, alerts.ActionCreated)
+		}
+	}
+}
+
+func TestClient_local(t *testing.T) {
+	lc := local.NewClient()
+	issue, err := lc.CreateIssue(context.Background(), "fake-repo", "disk full", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dryrun.NewClient(lc, 2)
+	c.CreateIssue(context.Background(), "fake-repo", "new alert", "body", nil)
+	c.LabelIssue(context.Background(), issue, "", true)
+	c.LabelIssue(context.Background(), issue, "resolved", true)
+	c.CloseIssue(context.Background(), issue)
+	retitled, err := c.RetitleIssue(context.Background(), issue, "disk full on host1")
+	if err != nil || retitled.GetTitle() != "disk full on host1" {
+		t.Errorf("RetitleIssue() = %v, %v; want retitled issue", retitled, err)
+	}
+
+	open, err := lc.ListOpenIssues(context.Background())
+	if err != nil || len(open) != 1 || len(open[0].Labels) != 0 || open[0].GetTitle() != "disk full" {
+		t.Errorf("local issues = %v, %v; want one unchanged issue", open, err)
+	}
+	// Only the two most recent mutations are kept.
+	got := c.Mutations()
+	if len(got) != 2 || got[0].Operation != "close" || got[0].Method != "" ||
+		got[1].Operation != "retitle" || got[1].NewTitle != "disk full on host1" {
+		t.Errorf("Mutations() = %+v, want close and retitle without requests", got)
+	}
+}
+
+func TestClient_ReopenIssue(t *testing.T) {
+	lc := local.NewClient()
+	issue, err := lc.CreateIssue(context.Background(), "fake-repo", "disk full", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc.CloseIssue(context.Background(), issue); err != nil {
+		t.Fatal(err)
+	}
+	c := dryrun.NewClient(lc, 0)
+	current, err := c.GetIssue(context.Background(), issue)
+	if err != nil || current.GetState() != "closed" {
+		t.Fatalf("GetIssue() = %v, %v; want closed issue", current, err)
+	}
+	reopened, err := c.ReopenIssue(context.Background(), current)
+	if err != nil || reopened.GetState() != "open" {
+		t.Errorf("ReopenIssue() = %v, %v; want open issue", reopened, err)
+	}
+	if current, _ := lc.GetIssue(context.Background(), issue); current.GetState() != "closed" {
+		t.Errorf("local issue state = %q, want closed", current.GetState())
+	}
+	if got := c.Mutations(); len(got) != 1 || got[0].Operation != "reopen" {
+		t.Errorf("Mutations() = %+v, want reopen", got)
+	}
+
+	// Clients that cannot read or reopen issues are unsupported.
+	c = dryrun.NewClient(plainClient{lc}, 0)
+	if _, err := c.GetIssue(context.Background(), issue); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("GetIssue() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if _, err := c.ReopenIssue(context.Background(), issue); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ReopenIssue() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+// plainClient hides the optional methods of a client.
+type plainClient struct {
+	alerts.ReceiverClient
+}
+
+func TestClient_ServeHTTP(t *testing.T) {
+	c := dryrun.NewClient(local.NewClient(), 0)
+	rw := httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/v1/dryrun", nil))
+	if rw.Code != http.StatusOK || rw.Body.String() != "[]\n" {
+		t.Errorf("ServeHTTP() = %d %q, want empty list", rw.Code, rw.Body.String())
+	}
+
+	c.CreateIssue(context.Background(), "fake-repo", "new alert", "body", nil)
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/v1/dryrun", nil))
+	var got []dryrun.Mutation
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil || len(got) != 1 || got[0].Title != "new alert" {
+		t.Errorf("ServeHTTP() = %q, want one mutation", rw.Body.String())
+	}
+
+	rw = httptest.NewRecorder()
+	c.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/v1/dryrun", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() code = %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	promtest.LintMetrics(t)
+}
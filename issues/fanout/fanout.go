@@ -0,0 +1,393 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package fanout provides a client that mirrors every issue operation to
+// several issue tracker backends.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	backendOperations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "githubreceiver_backend_operations_total",
+			Help: "Number of issue operations performed per backend.",
+		},
+		// "status" is either "ok" or "error".
+		[]string{"backend", "operation", "status"},
+	)
+	backendDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "githubreceiver_backend_duration_seconds",
+			Help: "A histogram of issue operation latencies per backend.",
+		},
+		[]string{"backend", "operation"},
+	)
+)
+
+// Policy determines which backend failures cause a Client operation to fail.
+// Failures are always logged and counted, regardless of the policy.
+type Policy string
+
+// Supported policies.
+const (
+	// RequireAll fails an operation when any backend fails.
+	RequireAll Policy = "all"
+	// RequirePrimary fails an operation only when the primary backend fails.
+	RequirePrimary Policy = "primary"
+	// RequireAny fails an operation only when every backend fails.
+	RequireAny Policy = "any"
+)
+
+// Backend is a named ReceiverClient.
+type Backend struct {
+	// Name identifies the backend in logs and metrics.
+	Name string
+	// Client performs the issue operations for this backend.
+	Client alerts.ReceiverClient
+}
+
+// Client implements the alerts.ReceiverClient interface by dispatching every
+// operation to all backends. The first backend is the primary backend.
+//
+// Each backend has its own issues, so issues are matched across backends by
+// title, the same way the ReceiverHandler matches alerts to issues.
+// ListOpenIssues returns the open issues of the primary backend, so that an
+// issue missing from the primary backend is created again even if a mirror
+// has it. CreateIssue only creates the issue in backends without an open
+// issue of the same title, and LabelIssue and CloseIssue apply to the issue
+// with the same title in each backend. The primary backend uses the given
+// issue when its open issues lack it. Closed issues are only read and
+// reopened in the primary backend.
+type Client struct {
+	backends []Backend
+	policy   Policy
+
+	// mu protects open.
+	mu sync.Mutex
+	// open contains the open issues of each backend, by title, as of the last
+	// ListOpenIssues. A nil map means the backend issues are unknown.
+	open []map[string]*github.Issue
+}
+
+// NewClient creates a Client that dispatches all operations to the given
+// backends. The first backend is the primary backend.
+func NewClient(policy Policy, backends ...Backend) (*Client, error) {
+	switch policy {
+	case RequireAll, RequirePrimary, RequireAny:
+	default:
+		return nil, fmt.Errorf("unsupported fanout policy: %q", policy)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("fanout requires at least one backend")
+	}
+	return &Client{
+		backends: backends,
+		policy:   policy,
+		open:     make([]map[string]*github.Issue, len(backends)),
+	}, nil
+}
+
+// CreateIssue creates the issue in every backend that does not already have
+// an open issue with the same title, e.g. from an earlier request that failed
+// in another backend. The issue of the earliest successful backend is
+// returned.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	var result *github.Issue
+	errs := c.each(ctx, "create", func(i int, b Backend) error {
+		issue, err := c.find(ctx, i, title)
+		if err != nil {
+			return err
+		}
+		if issue == nil {
+			issue, err = b.Client.CreateIssue(ctx, repo, title, body, extra)
+			if err != nil {
+				return err
+			}
+			c.remember(i, issue)
+		}
+		if result == nil {
+			result = issue
+		}
+		return nil
+	})
+	if err := c.check("create", errs); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// LabelIssue adds or removes the label on the issue with the same title in
+// every backend. Mirrors without a matching open issue are skipped.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	errs := c.each(ctx, "label", func(i int, b Backend) error {
+		found, err := c.match(ctx, i, issue)
+		if err != nil || found == nil {
+			return err
+		}
+		return b.Client.LabelIssue(ctx, found, label, add)
+	})
+	return c.check("label", errs)
+}
+
+// ListOpenIssues lists the open issues of every backend and returns those of
+// the primary backend. If the primary backend fails and the policy allows it,
+// the issues of the earliest successful backend are returned.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	lists := make([][]*github.Issue, len(c.backends))
+	errs := c.each(ctx, "list", func(i int, b Backend) error {
+		issues, err := b.Client.ListOpenIssues(ctx)
+		lists[i] = issues
+		c.update(i, issues, err)
+		return err
+	})
+	if err := c.check("list", errs); err != nil {
+		return nil, err
+	}
+
+	for i := range lists {
+		if errs[i] == nil {
+			return lists[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CloseIssue closes the issue with the same title in every backend. Mirrors
+// without a matching open issue are skipped. The closed issue from the
+// earliest successful backend is returned, or the given issue if no backend
+// had a matching open issue.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	result := issue
+	closedAny := false
+	errs := c.each(ctx, "close", func(i int, b Backend) error {
+		found, err := c.match(ctx, i, issue)
+		if err != nil || found == nil {
+			return err
+		}
+		closed, err := b.Client.CloseIssue(ctx, found)
+		if err != nil {
+			return err
+		}
+		c.forget(i, issue.GetTitle())
+		if !closedAny {
+			result, closedAny = closed, true
+		}
+		return nil
+	})
+	if err := c.check("close", errs); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RetitleIssue changes the title of the issue with the same title in every
+// backend. Mirrors without a matching open issue are skipped, and backends
+// that cannot retitle issues fail. The retitled issue from the earliest
+// successful backend is returned, or the given issue if no backend had a
+// matching open issue.
+func (c *Client) RetitleIssue(ctx context.Context, issue *github.Issue, title string) (*github.Issue, error) {
+	result := issue
+	retitledAny := false
+	errs := c.each(ctx, "retitle", func(i int, b Backend) error {
+		found, err := c.match(ctx, i, issue)
+		if err != nil || found == nil {
+			return err
+		}
+		rt, ok := b.Client.(alerts.IssueRetitler)
+		if !ok {
+			return fmt.Errorf("cannot retitle issues")
+		}
+		retitled, err := rt.RetitleIssue(ctx, found, title)
+		if err != nil {
+			return err
+		}
+		c.forget(i, issue.GetTitle())
+		c.remember(i, retitled)
+		if !retitledAny {
+			result, retitledAny = retitled, true
+		}
+		return nil
+	})
+	if err := c.check("retitle", errs); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetIssue returns the current state of the issue from the primary backend,
+// since the issues returned by ListOpenIssues are those of the primary
+// backend. GetIssue returns errors.ErrUnsupported if the primary backend
+// cannot read issues.
+func (c *Client) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	b := c.backends[0]
+	getter, ok := b.Client.(alerts.IssueGetter)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	var current *github.Issue
+	err := c.do(ctx, "get", b, func() error {
+		var err error
+		current, err = getter.GetIssue(ctx, issue)
+		return err
+	})
+	return current, err
+}
+
+// ReopenIssue reopens the issue in the primary backend. Only issues closed in
+// the primary backend are detected by GetIssue, so the issues of the mirrors
+// are still open. ReopenIssue returns errors.ErrUnsupported if the primary
+// backend cannot reopen issues.
+func (c *Client) ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	b := c.backends[0]
+	reopener, ok := b.Client.(alerts.IssueReopener)
+	if !ok {
+		return nil, errors.ErrUnsupported
+	}
+	var reopened *github.Issue
+	err := c.do(ctx, "reopen", b, func() error {
+		var err error
+		reopened, err = reopener.ReopenIssue(ctx, issue)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.remember(0, reopened)
+	return reopened, nil
+}
+
+// each calls f for every backend in order, and records the result of every
+// operation. each returns the errors from every backend, indexed by backend.
+func (c *Client) each(ctx context.Context, op string, f func(i int, b Backend) error) []error {
+	errs := make([]error, len(c.backends))
+	for i, b := range c.backends {
+		errs[i] = c.do(ctx, op, b, func() error { return f(i, b) })
+	}
+	return errs
+}
+
+// do calls f for the operation of backend b, and records the result.
+func (c *Client) do(ctx context.Context, op string, b Backend, f func() error) error {
+	start := time.Now()
+	err := f()
+	backendDuration.WithLabelValues(b.Name, op).Observe(time.Since(start).Seconds())
+	status := "ok"
+	if err != nil {
+		status = "error"
+		logging.FromContext(ctx).Error("Backend operation failed", "backend", b.Name, "operation", op, "error", err)
+	}
+	backendOperations.WithLabelValues(b.Name, op, status).Inc()
+	return err
+}
+
+// check applies the client policy to the backend errors and returns a single
+// error if the operation failed.
+func (c *Client) check(op string, errs []error) error {
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", c.backends[i].Name, err))
+		}
+	}
+	failed := false
+	switch c.policy {
+	case RequireAll:
+		failed = len(msgs) > 0
+	case RequirePrimary:
+		failed = errs[0] != nil
+	case RequireAny:
+		failed = len(msgs) == len(errs)
+	}
+	if !failed {
+		return nil
+	}
+	return fmt.Errorf("failed to %s issue: %s", op, strings.Join(msgs, "; "))
+}
+
+// find returns the open issue with the given title in backend i, or nil if
+// there is none. If the backend issues are unknown, they are listed first.
+func (c *Client) find(ctx context.Context, i int, title string) (*github.Issue, error) {
+	c.mu.Lock()
+	open := c.open[i]
+	c.mu.Unlock()
+	if open == nil {
+		issues, err := c.backends[i].Client.ListOpenIssues(ctx)
+		c.update(i, issues, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open[i][title], nil
+}
+
+// match returns the issue of backend i that matches the given issue, or nil if
+// there is none. Since searches may lag behind changes, an issue may be
+// missing from the open issues of the primary backend, e.g. after GetIssue
+// found it open. The primary backend then uses the given issue, which is
+// one of its own.
+func (c *Client) match(ctx context.Context, i int, issue *github.Issue) (*github.Issue, error) {
+	found, err := c.find(ctx, i, issue.GetTitle())
+	if err == nil && found == nil && i == 0 {
+		return issue, nil
+	}
+	return found, err
+}
+
+// update replaces the known open issues of backend i. If the list failed,
+// the backend issues become unknown.
+func (c *Client) update(i int, issues []*github.Issue, err error) {
+	var open map[string]*github.Issue
+	if err == nil {
+		open = make(map[string]*github.Issue, len(issues))
+		for _, issue := range issues {
+			open[issue.GetTitle()] = issue
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open[i] = open
+}
+
+// remember adds a newly created issue to the known open issues of backend i.
+func (c *Client) remember(i int, issue *github.Issue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open[i] != nil {
+		c.open[i][issue.GetTitle()] = issue
+	}
+}
+
+// forget removes a closed issue from the known open issues of backend i.
+func (c *Client) forget(i int, title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.open[i], title)
+}
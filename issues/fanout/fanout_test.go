@@ -0,0 +1,340 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/issues/local"
+	"github.com/m-lab/go/prometheusx/promtest"
+)
+
+// failClient fails every operation.
+type failClient struct{}
+
+func (failClient) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	return nil, fmt.Errorf("create failed")
+}
+
+func (failClient) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	return fmt.Errorf("label failed")
+}
+
+func (failClient) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	return nil, fmt.Errorf("list failed")
+}
+
+func (failClient) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	return nil, fmt.Errorf("close failed")
+}
+
+func TestMetrics(t *testing.T) {
+	backendOperations.WithLabelValues("x", "x", "x")
+	backendDuration.WithLabelValues("x", "x")
+	promtest.LintMetrics(t)
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient("bogus", Backend{Name: "local", Client: local.NewClient()}); err == nil {
+		t.Errorf("NewClient() with bad policy; want error, got nil")
+	}
+	if _, err := NewClient(RequireAll); err == nil {
+		t.Errorf("NewClient() without backends; want error, got nil")
+	}
+}
+
+func TestClient_mirrors(t *testing.T) {
+	primary := local.NewClient()
+	mirror := local.NewClient()
+	c, err := NewClient(RequireAll, Backend{"primary", primary}, Backend{"mirror", mirror})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An issue that already exists only in the mirror.
+	if _, err := mirror.CreateIssue(context.Background(), "fake-repo", "old alert", "body", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIssue(context.Background(), "fake-repo", "new alert", "body", nil); err != nil {
+		t.Fatalf("CreateIssue() error = %v", err)
+	}
+	list, err := c.ListOpenIssues(context.Background())
+	if err != nil {
+		t.Fatalf("ListOpenIssues() error = %v", err)
+	}
+	if len(list) != 1 || list[0].GetTitle() != "new alert" {
+		t.Fatalf("ListOpenIssues() = %v, want [new alert]", list)
+	}
+
+	// Labels and closes apply to backends that have the issue.
+	old, _ := mirror.ListOpenIssues(context.Background())
+	for _, issue := range append(list, old[0]) {
+		if err := c.LabelIssue(context.Background(), issue, "resolved", true); err != nil {
+			t.Errorf("LabelIssue(%q) error = %v", issue.GetTitle(), err)
+		}
+		if _, err := c.CloseIssue(context.Background(), issue); err != nil {
+			t.Errorf("CloseIssue(%q) error = %v", issue.GetTitle(), err)
+		}
+	}
+	for name, b := range map[string]*local.Client{"primary": primary, "mirror": mirror} {
+		open, _ := b.ListOpenIssues(context.Background())
+		if len(open) != 0 {
+			t.Errorf("%s has open issues %v, want none", name, open)
+		}
+	}
+}
+
+// flakyClient fails to create issues while fail is set.
+type flakyClient struct {
+	*local.Client
+	fail bool
+}
+
+func (f *flakyClient) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	if f.fail {
+		return nil, fmt.Errorf("create failed")
+	}
+	return f.Client.CreateIssue(ctx, repo, title, body, extra)
+}
+
+func TestClient_primaryCreateFails(t *testing.T) {
+	primary := &flakyClient{Client: local.NewClient(), fail: true}
+	mirror := local.NewClient()
+	c, err := NewClient(RequirePrimary, Backend{"primary", primary}, Backend{"mirror", mirror})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CreateIssue(context.Background(), "fake-repo", "alert", "body", nil); err == nil {
+		t.Fatal("CreateIssue() error = nil, want error")
+	}
+
+	// The retried request finds no issue in the primary backend, and creates
+	// it there without duplicating the issue of the mirror.
+	primary.fail = false
+	list, err := c.ListOpenIssues(context.Background())
+	if err != nil || len(list) != 0 {
+		t.Fatalf("ListOpenIssues() = %v, %v, want no issues", list, err)
+	}
+	if _, err := c.CreateIssue(context.Background(), "fake-repo", "alert", "body", nil); err != nil {
+		t.Fatalf("CreateIssue() error = %v", err)
+	}
+	for name, b := range map[string]alerts.ReceiverClient{"primary": primary, "mirror": mirror} {
+		open, _ := b.ListOpenIssues(context.Background())
+		if len(open) != 1 {
+			t.Errorf("%s has open issues %v, want one", name, open)
+		}
+	}
+}
+
+// plainClient hides the optional methods of a client.
+type plainClient struct {
+	alerts.ReceiverClient
+}
+
+func TestClient_RetitleIssue(t *testing.T) {
+	primary := local.NewClient()
+	mirror := local.NewClient()
+	c, err := NewClient(RequireAll, Backend{"primary", primary}, Backend{"mirror", mirror})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retitled, err := c.RetitleIssue(context.Background(), issue, "alert on host1")
+	if err != nil || retitled.GetTitle() != "alert on host1" {
+		t.Fatalf("RetitleIssue() = %v, %v, want retitled issue", retitled, err)
+	}
+	for name, b := range map[string]*local.Client{"primary": primary, "mirror": mirror} {
+		open, _ := b.ListOpenIssues(context.Background())
+		if len(open) != 1 || open[0].GetTitle() != "alert on host1" {
+			t.Errorf("%s has open issues %v, want one retitled issue", name, open)
+		}
+	}
+
+	// Backends that cannot retitle issues fail.
+	c, err = NewClient(RequireAll, Backend{"primary", primary}, Backend{"mirror", plainClient{mirror}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RetitleIssue(context.Background(), retitled, "alert"); err == nil {
+		t.Errorf("RetitleIssue() error = nil, want error")
+	}
+}
+
+func TestClient_ReopenIssue(t *testing.T) {
+	primary := local.NewClient()
+	mirror := local.NewClient()
+	c, err := NewClient(RequireAll, Backend{"primary", primary}, Backend{"mirror", mirror})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Someone closes the issue of the primary backend.
+	if _, err := primary.CloseIssue(context.Background(), issue); err != nil {
+		t.Fatal(err)
+	}
+	current, err := c.GetIssue(context.Background(), issue)
+	if err != nil || current.GetState() != "closed" {
+		t.Fatalf("GetIssue() = %v, %v, want closed issue", current, err)
+	}
+	reopened, err := c.ReopenIssue(context.Background(), current)
+	if err != nil || reopened.GetState() != "open" {
+		t.Fatalf("ReopenIssue() = %v, %v, want open issue", reopened, err)
+	}
+	for name, b := range map[string]*local.Client{"primary": primary, "mirror": mirror} {
+		open, _ := b.ListOpenIssues(context.Background())
+		if len(open) != 1 {
+			t.Errorf("%s has open issues %v, want one", name, open)
+		}
+	}
+
+	// A primary backend that cannot read or reopen issues is unsupported.
+	c, err = NewClient(RequireAll, Backend{"primary", plainClient{primary}}, Backend{"mirror", mirror})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetIssue(context.Background(), issue); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("GetIssue() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+	if _, err := c.ReopenIssue(context.Background(), issue); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ReopenIssue() error = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+// laggingClient lists no open issues, like a search that lags behind changes.
+type laggingClient struct {
+	*local.Client
+}
+
+func (laggingClient) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	return nil, nil
+}
+
+func TestClient_primaryListLags(t *testing.T) {
+	primary := local.NewClient()
+	mirror := local.NewClient()
+	c, err := NewClient(RequireAll, Backend{"primary", laggingClient{primary}}, Backend{"mirror", mirror})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert", "body", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The primary backend does not list the new issue yet.
+	if open, err := c.ListOpenIssues(context.Background()); err != nil || len(open) != 0 {
+		t.Fatalf("ListOpenIssues() = %v, %v, want no issues", open, err)
+	}
+	if err := c.LabelIssue(context.Background(), issue, "resolved", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CloseIssue(context.Background(), issue); err != nil {
+		t.Fatal(err)
+	}
+	got, err := primary.GetIssue(context.Background(), issue)
+	if err != nil || got.GetState() != "closed" || len(got.Labels) != 1 || got.Labels[0].GetName() != "resolved" {
+		t.Errorf("primary issue = %v, %v, want closed with the resolved label", got, err)
+	}
+	if open, _ := mirror.ListOpenIssues(context.Background()); len(open) != 0 {
+		t.Errorf("mirror has open issues %v, want none", open)
+	}
+}
+
+func TestClient_policy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		fail    []bool
+		wantErr bool
+	}{
+		{
+			name:   "all-success",
+			policy: RequireAll,
+			fail:   []bool{false, false},
+		},
+		{
+			name:    "all-mirror-fails",
+			policy:  RequireAll,
+			fail:    []bool{false, true},
+			wantErr: true,
+		},
+		{
+			name:   "primary-mirror-fails",
+			policy: RequirePrimary,
+			fail:   []bool{false, true},
+		},
+		{
+			name:    "primary-primary-fails",
+			policy:  RequirePrimary,
+			fail:    []bool{true, false},
+			wantErr: true,
+		},
+		{
+			name:   "any-primary-fails",
+			policy: RequireAny,
+			fail:   []bool{true, false},
+		},
+		{
+			name:    "any-all-fail",
+			policy:  RequireAny,
+			fail:    []bool{true, true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backends []Backend
+			for i, fail := range tt.fail {
+				b := Backend{Name: fmt.Sprint(i), Client: local.NewClient()}
+				if fail {
+					b.Client = failClient{}
+				}
+				backends = append(backends, b)
+			}
+			c, err := NewClient(tt.policy, backends...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert", "body", nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CreateIssue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && issue.GetTitle() != "alert" {
+				t.Errorf("CreateIssue() = %v, want title 'alert'", issue)
+			}
+			_, err = c.ListOpenIssues(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListOpenIssues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			issue = &github.Issue{Title: github.String("alert")}
+			if err := c.LabelIssue(context.Background(), issue, "resolved", true); (err != nil) != tt.wantErr {
+				t.Errorf("LabelIssue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, err := c.CloseIssue(context.Background(), issue); (err != nil) != tt.wantErr {
+				t.Errorf("CloseIssue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package issues
+
+import (
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// Filter selects issues. Empty fields match all issues.
+type Filter struct {
+	// Title matches the issue title.
+	Title *regexp.Regexp
+	// Repo is the name of the issue repository.
+	Repo string
+	// Labels must all be present on the issue.
+	Labels []string
+	// MinAge and MaxAge bound the time since the issue was created at Now.
+	MinAge time.Duration
+	MaxAge time.Duration
+	Now    time.Time
+}
+
+// Match reports whether the issue matches all fields of the filter.
+func (f *Filter) Match(issue *github.Issue) bool {
+	if f.Title != nil && !f.Title.MatchString(issue.GetTitle()) {
+		return false
+	}
+	if f.Repo != "" && RepoName(issue) != f.Repo {
+		return false
+	}
+	age := f.Now.Sub(issue.GetCreatedAt())
+	if (f.MinAge > 0 && age < f.MinAge) || (f.MaxAge > 0 && age > f.MaxAge) {
+		return false
+	}
+	for _, name := range f.Labels {
+		found := false
+		for _, l := range issue.Labels {
+			found = found || l.GetName() == name
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// RepoName returns the name of the issue repository, i.e. the last element of
+// the issue RepositoryURL.
+func RepoName(issue *github.Issue) string {
+	if issue.GetRepositoryURL() == "" {
+		return ""
+	}
+	return path.Base(issue.GetRepositoryURL())
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package issues
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestFilter_Match(t *testing.T) {
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	created := now.Add(-48 * time.Hour)
+	issue := &github.Issue{
+		Title:         github.String("DiskFull"),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/repo-a"),
+		CreatedAt:     &created,
+		Labels:        []github.Label{{Name: github.String("storage")}},
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"no-filters", Filter{}, true},
+		{"title", Filter{Title: regexp.MustCompile("^Disk")}, true},
+		{"other-title", Filter{Title: regexp.MustCompile("^CPU")}, false},
+		{"repo", Filter{Repo: "repo-a"}, true},
+		{"other-repo", Filter{Repo: "repo-b"}, false},
+		{"min-age", Filter{MinAge: 24 * time.Hour, Now: now}, true},
+		{"too-recent", Filter{MinAge: 72 * time.Hour, Now: now}, false},
+		{"max-age", Filter{MaxAge: 72 * time.Hour, Now: now}, true},
+		{"too-old", Filter{MaxAge: 24 * time.Hour, Now: now}, false},
+		{"label", Filter{Labels: []string{"storage"}}, true},
+		{"missing-label", Filter{Labels: []string{"storage", "network"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(issue); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := RepoName(&github.Issue{}); got != "" {
+		t.Errorf("RepoName() = %q for issue without RepositoryURL, want empty", got)
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package gitea implements issue operations using the Gitea (or Forgejo) API.
+//
+// Gitea issues are converted to *github.Issue values so that the Client
+// satisfies the same interface as the Github client. The RepositoryURL of
+// every issue has the same form as Github's, e.g.
+// https://gitea.example.com/api/v1/repos/org/repo
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+)
+
+// labelColor is the color of labels created by the Client. Unlike Github,
+// Gitea requires a color for every new label.
+const labelColor = "#e11d21"
+
+// labelMissTTL is how long a label that was not found in a repo is assumed to
+// be missing before the repo labels are loaded again. Labels may be created
+// outside the receiver.
+const labelMissTTL = 10 * time.Minute
+
+// pageSize is the number of issues or labels requested per page. Gitea may
+// return fewer per page, up to its MAX_RESPONSE_ITEMS setting.
+const pageSize = 50
+
+// A Client manages communication with the Gitea API.
+type Client struct {
+	// BaseURL is the Gitea API URL, including the trailing "/api/v1/".
+	BaseURL *url.URL
+	// HTTPClient is used for all requests to the Gitea API.
+	HTTPClient *http.Client
+	// org is the Gitea user or organization name (e.g. gitea.example.com/<org>/<repo>).
+	org string
+	// authToken is an access token for the Gitea API.
+	authToken string
+	// alertLabel is the label applied to all alerts. It is also used as the
+	// label to search to discover all existing alerts.
+	alertLabel string
+
+	// mu protects labels. It is not held during API requests.
+	mu sync.Mutex
+	// labels caches the labels of each repo, by "org/repo".
+	labels map[string]*repoLabels
+	// now returns the current time.
+	now func() time.Time
+}
+
+// repoLabels caches the labels of a repo.
+type repoLabels struct {
+	// ids maps label names to label IDs.
+	ids map[string]int64
+	// missing maps the names of labels that were not found to the time they
+	// were looked up.
+	missing map[string]time.Time
+}
+
+type label struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+}
+
+// giteaIssue is the subset of a Gitea issue used by the Client.
+// See also: https://try.gitea.io/api/swagger#/issue
+type giteaIssue struct {
+	ID         int64      `json:"id"`
+	Number     int        `json:"number"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	State      string     `json:"state"`
+	Labels     []label    `json:"labels"`
+	HTMLURL    string     `json:"html_url"`
+	CreatedAt  *time.Time `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+	ClosedAt   *time.Time `json:"closed_at"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// NewClient creates a Client authenticated using the Gitea authToken. Future
+// operations are only performed on repositories owned by org.
+func NewClient(baseURL, org, authToken, alertLabel string) (*Client, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("gitea base URL is required")
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		BaseURL:    u,
+		HTTPClient: rest.NewHTTPClient(),
+		org:        org,
+		authToken:  authToken,
+		alertLabel: alertLabel,
+		labels:     make(map[string]*repoLabels),
+		now:        time.Now,
+	}
+	return client, nil
+}
+
+// CreateIssue creates a new Gitea issue. Issues are labeled with the alertLabel
+// and any extra labels. Because Gitea labels issues by label ID, labels that do
+// not already exist in the repo are created first.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	ids, err := c.getLabelIDs(ctx, c.org, repo, append([]string{c.alertLabel}, extra...), true)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to find issue labels", "repo", repo, "error", err)
+		return nil, err
+	}
+	issueReq := map[string]interface{}{
+		"title":  title,
+		"body":   body,
+		"labels": ids,
+	}
+	// See also: https://try.gitea.io/api/swagger#/issue/issueCreateIssue
+	created := &giteaIssue{}
+	_, err = c.do(ctx, http.MethodPost, repoPath(c.org, repo)+"/issues", issueReq, created)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to create issue", "repo", repo, "title", title, "error", err)
+		return nil, err
+	}
+	// The created issue does not include the repository.
+	created.Repository.FullName = c.org + "/" + repo
+	return c.toGithubIssue(created), nil
+}
+
+// LabelIssue adds or removes a label from an issue. This call is idempotent;
+// no error is returned if trying to add a label that's already present or
+// remove one that's absent.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	if label == "" {
+		return nil
+	}
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return err
+	}
+	// Only create the label when adding it. A label that does not exist cannot
+	// be associated with the issue, so there is nothing to remove.
+	ids, err := c.getLabelIDs(ctx, org, repo, []string{label}, add)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	path := repoPath(org, repo) + "/issues/" + strconv.Itoa(issue.GetNumber()) + "/labels"
+	if add {
+		// See also: https://try.gitea.io/api/swagger#/issue/issueAddLabel
+		_, err = c.do(ctx, http.MethodPost, path, map[string][]int64{"labels": ids}, nil)
+		return err
+	}
+	// See also: https://try.gitea.io/api/swagger#/issue/issueRemoveLabel
+	resp, err := c.do(ctx, http.MethodDelete, path+"/"+strconv.FormatInt(ids[0], 10), nil, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		err = nil
+	}
+	return err
+}
+
+// ListOpenIssues returns open issues created by past alerts within the client
+// organization.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	var allIssues []*github.Issue
+
+	params := url.Values{}
+	params.Set("state", "open")
+	params.Set("type", "issues")
+	params.Set("owner", c.org)
+	params.Set("labels", c.alertLabel)
+	params.Set("limit", strconv.Itoa(pageSize))
+	for page := 1; ; page++ {
+		params.Set("page", strconv.Itoa(page))
+		// Unlike Github, the Gitea search matches issues having any of the
+		// given labels and filters repositories by owner.
+		// See also: https://try.gitea.io/api/swagger#/issue/issueSearchIssues
+		var issues []*giteaIssue
+		resp, err := c.do(ctx, http.MethodGet, "repos/issues/search?"+params.Encode(), nil, &issues)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to list open gitea issues", "error", err)
+			return nil, err
+		}
+		for i := range issues {
+			allIssues = append(allIssues, c.toGithubIssue(issues[i]))
+		}
+		if !hasNextPage(resp, len(issues), len(allIssues)) {
+			break
+		}
+	}
+	return allIssues, nil
+}
+
+// CloseIssue changes the issue state to "closed" unconditionally. If the issue
+// is already closed, then this should have no effect.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	// See also: https://try.gitea.io/api/swagger#/issue/issueEditIssue
+	closed := &giteaIssue{}
+	path := repoPath(org, repo) + "/issues/" + strconv.Itoa(issue.GetNumber())
+	_, err = c.do(ctx, http.MethodPatch, path, map[string]string{"state": "closed"}, closed)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to close issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	closed.Repository.FullName = org + "/" + repo
+	return c.toGithubIssue(closed), nil
+}
+
+// getLabelIDs returns the IDs of the named labels in the given repo. When
+// create is true, missing labels are created. Otherwise, missing labels are
+// omitted from the result.
+func (c *Client) getLabelIDs(ctx context.Context, org, repo string, names []string, create bool) ([]int64, error) {
+	key := org + "/" + repo
+	var ids []int64
+	for _, name := range names {
+		id, ok, missing := c.cachedLabel(key, name)
+		if !ok && (create || !missing) {
+			// Refresh the cache, since labels may be created outside the receiver.
+			if err := c.loadLabels(ctx, org, repo); err != nil {
+				return nil, err
+			}
+			id, ok, _ = c.cachedLabel(key, name)
+			if !ok && !create {
+				// Only a fresh load starts the TTL, so that labels created
+				// outside the receiver are found after it expires.
+				c.cacheMissingLabel(key, name, c.now())
+			}
+		}
+		if !ok && create {
+			// See also: https://try.gitea.io/api/swagger#/issue/issueCreateLabel
+			created := &label{}
+			_, err := c.do(ctx, http.MethodPost, repoPath(org, repo)+"/labels", &label{Name: name, Color: labelColor}, created)
+			if err != nil {
+				return nil, err
+			}
+			id, ok = created.ID, true
+			c.cacheLabel(key, name, id)
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// cachedLabel returns the cached ID of the named label in the repo with the
+// given key, or whether the label was recently found to be missing.
+func (c *Client) cachedLabel(key, name string) (id int64, ok bool, missing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.labels[key]
+	if l == nil {
+		return 0, false, false
+	}
+	if id, ok := l.ids[name]; ok {
+		return id, true, false
+	}
+	t, ok := l.missing[name]
+	return 0, false, ok && c.now().Sub(t) < labelMissTTL
+}
+
+// cacheLabel adds the label ID to the cached labels of the repo with the given
+// key.
+func (c *Client) cacheLabel(key, name string, id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l := c.labels[key]; l != nil {
+		l.ids[name] = id
+		delete(l.missing, name)
+	}
+}
+
+// cacheMissingLabel records that the named label was not found in the repo
+// with the given key at the given time.
+func (c *Client) cacheMissingLabel(key, name string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l := c.labels[key]; l != nil {
+		l.missing[name] = t
+	}
+}
+
+// loadLabels replaces the cached labels for the given repo.
+func (c *Client) loadLabels(ctx context.Context, org, repo string) error {
+	ids := make(map[string]int64)
+	for page := 1; ; page++ {
+		// See also: https://try.gitea.io/api/swagger#/issue/issueListLabels
+		var labels []label
+		path := fmt.Sprintf("%s/labels?page=%d&limit=%d", repoPath(org, repo), page, pageSize)
+		resp, err := c.do(ctx, http.MethodGet, path, nil, &labels)
+		if err != nil {
+			return err
+		}
+		for _, l := range labels {
+			ids[l.Name] = l.ID
+		}
+		if !hasNextPage(resp, len(labels), len(ids)) {
+			break
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.labels[org+"/"+repo] = &repoLabels{ids: ids, missing: make(map[string]time.Time)}
+	return nil
+}
+
+// hasNextPage reports whether another page follows a page of n items, given
+// the number of items received so far. Since Gitea may return fewer items than
+// requested on any page, only an empty page or the X-Total-Count header of the
+// response marks the last page.
+func hasNextPage(resp *http.Response, n, received int) bool {
+	if n == 0 {
+		return false
+	}
+	if total, err := strconv.Atoi(resp.Header.Get("X-Total-Count")); err == nil {
+		return received < total
+	}
+	return true
+}
+
+// do sends an API request with an optional JSON body to the path relative to
+// the client BaseURL. A successful JSON response is decoded into result, when
+// result is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) (*http.Response, error) {
+	req, err := rest.NewRequest(ctx, method, c.BaseURL, path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+c.authToken)
+	return rest.Do(c.HTTPClient, req, result)
+}
+
+// toGithubIssue converts a Gitea issue to the equivalent Github issue.
+func (c *Client) toGithubIssue(i *giteaIssue) *github.Issue {
+	labels := make([]github.Label, len(i.Labels))
+	for n := range i.Labels {
+		labels[n] = github.Label{
+			ID:   github.Int64(i.Labels[n].ID),
+			Name: github.String(i.Labels[n].Name),
+		}
+	}
+	gi := &github.Issue{
+		ID:        github.Int64(i.ID),
+		Number:    github.Int(i.Number),
+		Title:     github.String(i.Title),
+		Body:      github.String(i.Body),
+		State:     github.String(i.State),
+		Labels:    labels,
+		HTMLURL:   github.String(i.HTMLURL),
+		CreatedAt: i.CreatedAt,
+		UpdatedAt: i.UpdatedAt,
+		ClosedAt:  i.ClosedAt,
+	}
+	if i.Repository.FullName != "" {
+		u, _ := c.BaseURL.Parse("repos/" + i.Repository.FullName)
+		gi.RepositoryURL = github.String(u.String())
+	}
+	return gi
+}
+
+// repoPath returns the API path of the given repo.
+func repoPath(org, repo string) string {
+	return "repos/" + url.PathEscape(org) + "/" + url.PathEscape(repo)
+}
+
+// getOrgAndRepoFromIssue reads the issue RepositoryURL and extracts the
+// owner and repo names.
+func getOrgAndRepoFromIssue(issue *github.Issue) (string, string, error) {
+	repoURL := issue.GetRepositoryURL()
+	if repoURL == "" {
+		return "", "", fmt.Errorf("issue has invalid RepositoryURL value")
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.Split(strings.TrimSuffix(u.Path, "/"), "/")
+	if len(fields) < 3 || fields[len(fields)-3] != "repos" {
+		return "", "", fmt.Errorf("issue has invalid RepositoryURL path values")
+	}
+	return fields[len(fields)-2], fields[len(fields)-1], nil
+}
@@ -0,0 +1,477 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package gitea_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/gitea"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest/resttest"
+)
+
+const (
+	result = `{
+		"id": 1001,
+		"number": 3,
+		"title": "DiskRunningFull",
+		"body": "fake issue body",
+		"state": "open",
+		"labels": [{"id": 11, "name": "alert:boom:"}],
+		"html_url": "https://gitea.example.com/fake-org/fake-repo/issues/3",
+		"repository": {"full_name": "fake-org/fake-repo"}
+	}`
+	labels = `[{"id": 11, "name": "alert:boom:"}, {"id": 12, "name": "resolved"}]`
+)
+
+// labelsPage returns the requested page of the repo labels. All labels are on
+// the first page.
+func labelsPage(r *http.Request) string {
+	if r.URL.Query().Get("page") != "1" {
+		return `[]`
+	}
+	return labels
+}
+
+func newIssue(base *url.URL, state string) *github.Issue {
+	return &github.Issue{
+		ID:     github.Int64(1001),
+		Number: github.Int(3),
+		Title:  github.String("DiskRunningFull"),
+		Body:   github.String("fake issue body"),
+		State:  github.String(state),
+		Labels: []github.Label{
+			{ID: github.Int64(11), Name: github.String("alert:boom:")},
+		},
+		HTMLURL:       github.String("https://gitea.example.com/fake-org/fake-repo/issues/3"),
+		RepositoryURL: github.String(base.String() + "repos/fake-org/fake-repo"),
+	}
+}
+
+func checkAuth(t *testing.T, r *http.Request) {
+	if r.Header.Get("Authorization") != "token FAKE-AUTH-TOKEN" {
+		t.Errorf("Request does not contain auth token")
+	}
+}
+
+// handleLabels registers a handler for the repo labels API which counts the
+// labels created.
+func handleLabels(t *testing.T, mux *http.ServeMux, created *[]string) {
+	mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/labels", func(w http.ResponseWriter, r *http.Request) {
+		checkAuth(t, r)
+		if r.Method == http.MethodPost {
+			v := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&v)
+			if v["color"] == "" {
+				t.Errorf("Create label request without color")
+			}
+			*created = append(*created, v["name"])
+			fmt.Fprintf(w, `{"id": 13, "name": %q}`, v["name"])
+			return
+		}
+		fmt.Fprint(w, labelsPage(r))
+	})
+}
+
+func TestClient_CreateIssue(t *testing.T) {
+	tests := []struct {
+		name        string
+		extra       []string
+		wantIDs     []int64
+		wantCreated []string
+		wantErr     bool
+	}{
+		{
+			name:    "success",
+			wantIDs: []int64{11},
+		},
+		{
+			name:        "success-create-missing-label",
+			extra:       []string{"resolved", "new-label"},
+			wantIDs:     []int64{11, 12, 13},
+			wantCreated: []string{"new-label"},
+		},
+		{
+			name:    "create-returns-error",
+			wantIDs: []int64{11},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v1/")
+			c, err := gitea.NewClient(base.String(), "fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var created []string
+			handleLabels(t, mux, &created)
+			mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/issues", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				v := struct {
+					Title  string
+					Body   string
+					Labels []int64
+				}{}
+				json.NewDecoder(r.Body).Decode(&v)
+				if v.Title != "DiskRunningFull" || v.Body != "fake issue body" {
+					t.Errorf("Request = %+v, want title and body", v)
+				}
+				if !reflect.DeepEqual(v.Labels, tt.wantIDs) {
+					t.Errorf("Request labels = %v, want %v", v.Labels, tt.wantIDs)
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"message":"not found"}`)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, strings.Replace(result, `"fake-org/fake-repo"`, `""`, 1))
+			})
+
+			got, err := c.CreateIssue(context.Background(), "fake-repo", "DiskRunningFull", "fake issue body", tt.extra)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CreateIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(created, tt.wantCreated) {
+				t.Errorf("Client.CreateIssue(context.Background()) created labels %v, want %v", created, tt.wantCreated)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := newIssue(base, "open"); !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.CreateIssue(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_ListOpenIssues(t *testing.T) {
+	tests := []struct {
+		name       string
+		totalCount bool
+		wantErr    bool
+	}{
+		{
+			name: "success",
+		},
+		{
+			name:       "success-total-count",
+			totalCount: true,
+		},
+		{
+			name:    "list-returns-error",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v1/")
+			c, _ := gitea.NewClient(base.String(), "fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+
+			mux.HandleFunc("/api/v1/repos/issues/search", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				q := r.URL.Query()
+				if q.Get("state") != "open" || q.Get("labels") != "alert:boom:" || q.Get("owner") != "fake-org" {
+					t.Errorf("Request query = %v, want open issues with alert label", q)
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"message":"token is required"}`)
+					return
+				}
+				if tt.totalCount {
+					w.Header().Set("X-Total-Count", "21")
+				}
+				switch q.Get("page") {
+				case "1", "2":
+					// Return partial pages, as with a MAX_RESPONSE_ITEMS of 10.
+					fmt.Fprint(w, `[`+strings.TrimSuffix(strings.Repeat(result+",", 10), ",")+`]`)
+				case "3":
+					fmt.Fprint(w, `[`+result+`]`)
+				default:
+					if tt.totalCount {
+						t.Errorf("Request for page %s after all issues were listed", q.Get("page"))
+					}
+					fmt.Fprint(w, `[]`)
+				}
+			})
+
+			got, err := c.ListOpenIssues(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.ListOpenIssues(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != 21 {
+				t.Fatalf("Client.ListOpenIssues(context.Background()) returned %d issues, want 21", len(got))
+			}
+			if want := newIssue(base, "open"); !reflect.DeepEqual(got[20], want) {
+				t.Errorf("Client.ListOpenIssues(context.Background()) = %v, want %v", got[20], want)
+			}
+		})
+	}
+}
+
+func TestClient_LabelIssue(t *testing.T) {
+	base, _ := url.Parse("http://gitea.example.com/api/v1/")
+	tests := []struct {
+		name        string
+		issue       *github.Issue
+		label       string
+		add         bool
+		httpCode    int
+		wantCreated []string
+		wantPath    string
+		errorSubstr string
+	}{
+		{
+			name:     "success-label",
+			issue:    newIssue(base, "open"),
+			label:    "resolved",
+			add:      true,
+			wantPath: "/api/v1/repos/fake-org/fake-repo/issues/3/labels",
+		},
+		{
+			name:        "success-label-create",
+			issue:       newIssue(base, "open"),
+			label:       "new-label",
+			add:         true,
+			wantCreated: []string{"new-label"},
+			wantPath:    "/api/v1/repos/fake-org/fake-repo/issues/3/labels",
+		},
+		{
+			name:     "success-unlabel",
+			issue:    newIssue(base, "open"),
+			label:    "resolved",
+			wantPath: "/api/v1/repos/fake-org/fake-repo/issues/3/labels/12",
+		},
+		{
+			name:     "success-unlabel-not-associated",
+			issue:    newIssue(base, "open"),
+			label:    "resolved",
+			httpCode: http.StatusNotFound,
+			wantPath: "/api/v1/repos/fake-org/fake-repo/issues/3/labels/12",
+		},
+		{
+			name:  "success-unlabel-undefined",
+			issue: newIssue(base, "open"),
+			label: "undefined",
+		},
+		{
+			name:  "success-noop-label",
+			issue: newIssue(base, "open"),
+		},
+		{
+			name:        "failure-label-bad",
+			issue:       newIssue(base, "open"),
+			label:       "resolved",
+			add:         true,
+			httpCode:    http.StatusBadRequest,
+			wantPath:    "/api/v1/repos/fake-org/fake-repo/issues/3/labels",
+			errorSubstr: "fake error",
+		},
+		{
+			name:        "failure-bad-issue",
+			issue:       &github.Issue{Number: github.Int(3)},
+			label:       "resolved",
+			errorSubstr: "invalid RepositoryURL",
+		},
+		{
+			name: "failure-bad-repository-url",
+			issue: &github.Issue{
+				Number:        github.Int(3),
+				RepositoryURL: github.String("https://gitea.example.com/api/v1/orgs/fake-org"),
+			},
+			label:       "resolved",
+			errorSubstr: "invalid RepositoryURL path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v1/")
+			c, _ := gitea.NewClient(base.String(), "fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+
+			var created []string
+			var path string
+			handleLabels(t, mux, &created)
+			mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/issues/3/labels", func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				if tt.httpCode != 0 {
+					w.WriteHeader(tt.httpCode)
+					fmt.Fprint(w, `{"message": "fake error"}`)
+					return
+				}
+				fmt.Fprint(w, labels)
+			})
+			mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/issues/3/labels/", func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				if r.Method != http.MethodDelete {
+					t.Errorf("Request method = %q, want DELETE", r.Method)
+				}
+				if tt.httpCode != 0 {
+					w.WriteHeader(tt.httpCode)
+					fmt.Fprint(w, `{"message": "fake error"}`)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			err := c.LabelIssue(context.Background(), tt.issue, tt.label, tt.add)
+			if err != nil {
+				if tt.errorSubstr == "" {
+					t.Error(err)
+				} else if !strings.Contains(err.Error(), tt.errorSubstr) {
+					t.Errorf("error %q but want %q", err.Error(), tt.errorSubstr)
+				}
+			} else if tt.errorSubstr != "" {
+				t.Errorf("no error but want %q", tt.errorSubstr)
+			}
+			if path != tt.wantPath {
+				t.Errorf("Client.LabelIssue(context.Background()) path = %q, want %q", path, tt.wantPath)
+			}
+			if !reflect.DeepEqual(created, tt.wantCreated) {
+				t.Errorf("Client.LabelIssue(context.Background()) created labels %v, want %v", created, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestClient_labelCache(t *testing.T) {
+	mux, base := resttest.NewServer(t, "/api/v1/")
+	c, _ := gitea.NewClient(base.String(), "fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+
+	loads := map[string]int{}
+	for _, org := range []string{"fake-org", "other-org"} {
+		mux.HandleFunc("/api/v1/repos/"+org+"/fake-repo/labels", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") == "1" {
+				loads[r.URL.Path]++
+			}
+			fmt.Fprint(w, labelsPage(r))
+		})
+		mux.HandleFunc("/api/v1/repos/"+org+"/fake-repo/issues/3/labels", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, labels)
+		})
+	}
+	issue := newIssue(c.BaseURL, "open")
+	other := newIssue(c.BaseURL, "open")
+	other.RepositoryURL = github.String(c.BaseURL.String() + "repos/other-org/fake-repo")
+
+	// Labels are cached for each org and repo.
+	for _, i := range []*github.Issue{issue, other, issue, other} {
+		if err := c.LabelIssue(context.Background(), i, "resolved", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Labels that are missing are not loaded again when removing them.
+	for n := 0; n < 3; n++ {
+		if err := c.LabelIssue(context.Background(), issue, "undefined", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]int{
+		"/api/v1/repos/fake-org/fake-repo/labels":  2,
+		"/api/v1/repos/other-org/fake-repo/labels": 1,
+	}
+	if !reflect.DeepEqual(loads, want) {
+		t.Errorf("Client.LabelIssue() loaded labels %v, want %v", loads, want)
+	}
+}
+
+func TestClient_CloseIssue(t *testing.T) {
+	tests := []struct {
+		name    string
+		issue   func(base *url.URL) *github.Issue
+		wantErr bool
+	}{
+		{
+			name:  "success",
+			issue: func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+		},
+		{
+			name:    "error-empty-repository-url",
+			issue:   func(base *url.URL) *github.Issue { return &github.Issue{} },
+			wantErr: true,
+		},
+		{
+			name:    "error-close-returns-error",
+			issue:   func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v1/")
+			c, _ := gitea.NewClient(base.String(), "fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+
+			mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/issues/3", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				if r.Method != http.MethodPatch {
+					t.Errorf("Request method = %q, want PATCH", r.Method)
+				}
+				v := map[string]string{}
+				json.NewDecoder(r.Body).Decode(&v)
+				if v["state"] != "closed" {
+					t.Errorf("Request state = %q, want closed", v["state"])
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"message":"not found"}`)
+					return
+				}
+				fmt.Fprint(w, strings.Replace(result, `"open"`, `"closed"`, 1))
+			})
+
+			got, err := c.CloseIssue(context.Background(), tt.issue(base))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CloseIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := newIssue(base, "closed"); !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.CloseIssue(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := gitea.NewClient("https://gitea.example.com/api/v1", "fake-org", "FAKE-AUTH-TOKEN", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseURL.String() != "https://gitea.example.com/api/v1/" {
+		t.Errorf("NewClient() BaseURL = %q, want trailing slash", c.BaseURL)
+	}
+	if _, err = gitea.NewClient("", "fake-org", "FAKE-AUTH-TOKEN", "alert"); err == nil {
+		t.Errorf("NewClient() got nil error for empty URL, want error")
+	}
+	if _, err = gitea.NewClient("invalidURLEscape%zz", "fake-org", "FAKE-AUTH-TOKEN", "alert"); err == nil {
+		t.Errorf("NewClient() got nil error, want error")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package gitea
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+func TestClient_labelMissExpires(t *testing.T) {
+	repoLabels := `[{"id": 11, "name": "alert:boom:"}]`
+	loads, applied := 0, 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/labels", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") != "1" {
+			fmt.Fprint(w, `[]`)
+			return
+		}
+		loads++
+		fmt.Fprint(w, repoLabels)
+	})
+	mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/issues/3/labels", func(w http.ResponseWriter, r *http.Request) {
+		applied++
+		fmt.Fprint(w, repoLabels)
+	})
+	mux.HandleFunc("/api/v1/repos/fake-org/fake-repo/issues/3/labels/12", func(w http.ResponseWriter, r *http.Request) {
+		applied++
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	c, err := NewClient(srv.URL+"/api/v1/", "fake-org", "FAKE-AUTH-TOKEN", "alert:boom:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	issue := &github.Issue{
+		Number:        github.Int(3),
+		RepositoryURL: github.String(srv.URL + "/api/v1/repos/fake-org/fake-repo"),
+	}
+
+	// The missing label is looked up again once labelMissTTL expires, however
+	// often it is removed in between.
+	for i := 0; i < 3; i++ {
+		if err := c.LabelIssue(context.Background(), issue, "resolved", false); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(labelMissTTL / 2)
+	}
+	if loads != 2 || applied != 0 {
+		t.Fatalf("Client.LabelIssue() loaded labels %d times and removed %d, want 2 and 0", loads, applied)
+	}
+
+	// Someone creates the label, which is found once the miss expires.
+	repoLabels = `[{"id": 11, "name": "alert:boom:"}, {"id": 12, "name": "resolved"}]`
+	now = now.Add(labelMissTTL)
+	if err := c.LabelIssue(context.Background(), issue, "resolved", false); err != nil {
+		t.Fatal(err)
+	}
+	if loads != 3 || applied != 1 {
+		t.Errorf("Client.LabelIssue() loaded labels %d times and removed %d, want 3 and 1", loads, applied)
+	}
+}
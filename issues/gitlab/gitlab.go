@@ -0,0 +1,265 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package gitlab implements issue operations using the GitLab REST API.
+//
+// GitLab issues are converted to *github.Issue values so that the Client
+// satisfies the same interface as the Github client. The issue Number is the
+// project-scoped GitLab "iid", and the RepositoryURL is the API URL of the
+// issue's project, e.g. https://gitlab.example.com/api/v4/projects/group%2Frepo
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+)
+
+// DefaultBaseURL is the API URL of gitlab.com.
+const DefaultBaseURL = "https://gitlab.com/api/v4/"
+
+// A Client manages communication with the GitLab API.
+type Client struct {
+	// BaseURL is the GitLab API URL, including the trailing "/api/v4/".
+	BaseURL *url.URL
+	// HTTPClient is used for all requests to the GitLab API.
+	HTTPClient *http.Client
+	// group is the GitLab group path containing all projects, e.g.
+	// gitlab.example.com/<group>/<repo>. Subgroups are separated by "/".
+	group string
+	// authToken is a personal, group or project access token.
+	authToken string
+	// alertLabel is the label applied to all alerts. It is also used as the
+	// label to search to discover all existing alerts.
+	alertLabel string
+}
+
+// glIssue is the subset of a GitLab issue used by the Client.
+// See also: https://docs.gitlab.com/ee/api/issues.html
+type glIssue struct {
+	ID          int64      `json:"id"`
+	IID         int        `json:"iid"`
+	ProjectID   int64      `json:"project_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	Labels      []string   `json:"labels"`
+	WebURL      string     `json:"web_url"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+	ClosedAt    *time.Time `json:"closed_at"`
+	References  struct {
+		Full string `json:"full"`
+	} `json:"references"`
+}
+
+// issueRequest contains the fields used to create or edit a GitLab issue.
+// Labels are given as comma separated lists of label names.
+type issueRequest struct {
+	Title        string `json:"title,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Labels       string `json:"labels,omitempty"`
+	AddLabels    string `json:"add_labels,omitempty"`
+	RemoveLabels string `json:"remove_labels,omitempty"`
+	StateEvent   string `json:"state_event,omitempty"`
+}
+
+// NewClient creates a Client authenticated using the GitLab authToken. Future
+// operations are only performed on projects within the given GitLab group.
+// If baseURL is empty, DefaultBaseURL is used.
+func NewClient(baseURL, group, authToken, alertLabel string) (*Client, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		BaseURL:    u,
+		HTTPClient: rest.NewHTTPClient(),
+		group:      group,
+		authToken:  authToken,
+		alertLabel: alertLabel,
+	}
+	return client, nil
+}
+
+// CreateIssue creates a new GitLab issue in the project "group/repo". Issues
+// are labeled with the alertLabel and any extra labels. GitLab creates labels
+// automatically if they do not already exist.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	labels := append([]string{c.alertLabel}, extra...)
+	issueReq := &issueRequest{
+		Title:       title,
+		Description: body,
+		Labels:      strings.Join(labels, ","),
+	}
+	// See also: https://docs.gitlab.com/ee/api/issues.html#new-issue
+	created := &glIssue{}
+	_, err := c.do(ctx, http.MethodPost, c.projectPath(c.group+"/"+repo)+"/issues", issueReq, created)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to create issue", "repo", repo, "title", title, "error", err)
+		return nil, err
+	}
+	return c.toGithubIssue(created), nil
+}
+
+// LabelIssue adds or removes a label from an issue. GitLab ignores requests to
+// add a label that is already present or remove one that is absent, so this
+// call is idempotent. Scoped labels (e.g. "alert::resolved") are supported;
+// adding one replaces any other label with the same scope.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	if label == "" {
+		return nil
+	}
+	project, err := getProjectFromIssue(issue)
+	if err != nil {
+		return err
+	}
+	issueReq := &issueRequest{}
+	if add {
+		issueReq.AddLabels = label
+	} else {
+		issueReq.RemoveLabels = label
+	}
+	// See also: https://docs.gitlab.com/ee/api/issues.html#edit-issue
+	_, err = c.do(ctx, http.MethodPut, c.issuePath(project, issue.GetNumber()), issueReq, nil)
+	return err
+}
+
+// ListOpenIssues returns open issues created by past alerts within the client
+// group, including all subgroups.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	var allIssues []*github.Issue
+
+	params := url.Values{}
+	params.Set("state", "opened")
+	params.Set("scope", "all")
+	params.Set("labels", c.alertLabel)
+	params.Set("per_page", "100")
+	page := "1"
+	for page != "" {
+		params.Set("page", page)
+		// See also: https://docs.gitlab.com/ee/api/issues.html#list-group-issues
+		var issues []*glIssue
+		resp, err := c.do(ctx, http.MethodGet, "groups/"+url.PathEscape(c.group)+"/issues?"+params.Encode(), nil, &issues)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to list open gitlab issues", "error", err)
+			return nil, err
+		}
+		for i := range issues {
+			allIssues = append(allIssues, c.toGithubIssue(issues[i]))
+		}
+		// Continue loading the next page until all issues are received.
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return allIssues, nil
+}
+
+// CloseIssue changes the issue state to "closed" unconditionally. If the issue
+// is already closed, then this should have no effect.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	project, err := getProjectFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	// See also: https://docs.gitlab.com/ee/api/issues.html#edit-issue
+	closed := &glIssue{}
+	_, err = c.do(ctx, http.MethodPut, c.issuePath(project, issue.GetNumber()), &issueRequest{StateEvent: "close"}, closed)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to close issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	return c.toGithubIssue(closed), nil
+}
+
+// do sends an API request with an optional JSON body to the path relative to
+// the client BaseURL. A successful JSON response is decoded into result, when
+// result is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) (*http.Response, error) {
+	req, err := rest.NewRequest(ctx, method, c.BaseURL, path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.authToken)
+	return rest.Do(c.HTTPClient, req, result)
+}
+
+// projectPath returns the API path of the project with the given full path.
+func (c *Client) projectPath(project string) string {
+	return "projects/" + url.PathEscape(project)
+}
+
+// issuePath returns the API path of the numbered issue in the given project.
+func (c *Client) issuePath(project string, number int) string {
+	return c.projectPath(project) + "/issues/" + strconv.Itoa(number)
+}
+
+// toGithubIssue converts a GitLab issue to the equivalent Github issue.
+func (c *Client) toGithubIssue(i *glIssue) *github.Issue {
+	labels := make([]github.Label, len(i.Labels))
+	for n := range i.Labels {
+		labels[n] = github.Label{Name: github.String(i.Labels[n])}
+	}
+	state := i.State
+	if state == "opened" {
+		state = "open"
+	}
+	gi := &github.Issue{
+		ID:        github.Int64(i.ID),
+		Number:    github.Int(i.IID),
+		Title:     github.String(i.Title),
+		Body:      github.String(i.Description),
+		State:     github.String(state),
+		Labels:    labels,
+		HTMLURL:   github.String(i.WebURL),
+		CreatedAt: i.CreatedAt,
+		UpdatedAt: i.UpdatedAt,
+		ClosedAt:  i.ClosedAt,
+	}
+	// The full reference has the form "group/subgroup/repo#iid".
+	if project := strings.TrimSuffix(i.References.Full, "#"+strconv.Itoa(i.IID)); project != "" {
+		u, _ := c.BaseURL.Parse(c.projectPath(project))
+		gi.RepositoryURL = github.String(u.String())
+	}
+	return gi
+}
+
+// getProjectFromIssue reads the issue RepositoryURL and extracts the full
+// project path, e.g. "group/subgroup/repo".
+func getProjectFromIssue(issue *github.Issue) (string, error) {
+	repoURL := issue.GetRepositoryURL()
+	if repoURL == "" {
+		return "", fmt.Errorf("issue has invalid RepositoryURL value")
+	}
+	// Use the raw URL value because the project path is escaped.
+	fields := strings.SplitN(repoURL, "/projects/", 2)
+	if len(fields) != 2 || fields[1] == "" {
+		return "", fmt.Errorf("issue has invalid RepositoryURL path values")
+	}
+	return url.PathUnescape(strings.TrimSuffix(fields[1], "/"))
+}
@@ -0,0 +1,360 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package gitlab_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/gitlab"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest/resttest"
+)
+
+const (
+	result = `{
+		"id": 84188,
+		"iid": 7,
+		"project_id": 1,
+		"title": "DiskRunningFull",
+		"description": "fake issue body",
+		"state": "opened",
+		"labels": ["alert:boom:", "extra"],
+		"web_url": "https://gitlab.example.com/fake-group/fake-repo/-/issues/7",
+		"references": {"full": "fake-group/fake-repo#7"}
+	}`
+	closedResult = `{
+		"id": 84188,
+		"iid": 7,
+		"project_id": 1,
+		"title": "DiskRunningFull",
+		"state": "closed",
+		"web_url": "https://gitlab.example.com/fake-group/fake-repo/-/issues/7",
+		"references": {"full": "fake-group/fake-repo#7"}
+	}`
+)
+
+func newIssue(base *url.URL, state string, labels ...string) *github.Issue {
+	i := &github.Issue{
+		ID:            github.Int64(84188),
+		Number:        github.Int(7),
+		Title:         github.String("DiskRunningFull"),
+		Body:          github.String("fake issue body"),
+		State:         github.String(state),
+		Labels:        []github.Label{},
+		HTMLURL:       github.String("https://gitlab.example.com/fake-group/fake-repo/-/issues/7"),
+		RepositoryURL: github.String(base.String() + "projects/fake-group%2Ffake-repo"),
+	}
+	for _, l := range labels {
+		i.Labels = append(i.Labels, github.Label{Name: github.String(l)})
+	}
+	return i
+}
+
+func checkRequest(t *testing.T, r *http.Request, method, path string) {
+	if r.Method != method {
+		t.Errorf("Request method = %q, want %q", r.Method, method)
+	}
+	if r.URL.EscapedPath() != path {
+		t.Errorf("Request path = %q, want %q", r.URL.EscapedPath(), path)
+	}
+	if r.Header.Get("PRIVATE-TOKEN") != "FAKE-AUTH-TOKEN" {
+		t.Errorf("Request does not contain private token")
+	}
+}
+
+func TestClient_CreateIssue(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   []string
+		wantErr bool
+	}{
+		{
+			name:  "success",
+			extra: []string{"extra"},
+		},
+		{
+			name:    "create-returns-error",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v4/")
+			c, err := gitlab.NewClient(base.String(), "fake-group", "FAKE-AUTH-TOKEN", "alert:boom:")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+				checkRequest(t, r, http.MethodPost, "/api/v4/projects/fake-group%2Ffake-repo/issues")
+				v := map[string]string{}
+				json.NewDecoder(r.Body).Decode(&v)
+				if v["title"] != "DiskRunningFull" || v["description"] != "fake issue body" {
+					t.Errorf("Request = %+v, want title and description", v)
+				}
+				if want := strings.Join(append([]string{"alert:boom:"}, tt.extra...), ","); v["labels"] != want {
+					t.Errorf("Request labels = %q, want %q", v["labels"], want)
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"message":"404 Project Not Found"}`)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, result)
+			})
+
+			got, err := c.CreateIssue(context.Background(), "fake-repo", "DiskRunningFull", "fake issue body", tt.extra)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CreateIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			want := newIssue(base, "open", "alert:boom:", "extra")
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.CreateIssue(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_ListOpenIssues(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{
+			name: "success",
+		},
+		{
+			name:    "list-returns-error",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v4/")
+			c, err := gitlab.NewClient(base.String(), "fake-group/sub", "FAKE-AUTH-TOKEN", "alert:boom:")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mux.HandleFunc("/api/v4/groups/", func(w http.ResponseWriter, r *http.Request) {
+				checkRequest(t, r, http.MethodGet, "/api/v4/groups/fake-group%2Fsub/issues")
+				q := r.URL.Query()
+				if q.Get("state") != "opened" || q.Get("labels") != "alert:boom:" {
+					t.Errorf("Request query = %v, want opened issues with alert label", q)
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprint(w, `{"message":"401 Unauthorized"}`)
+					return
+				}
+				if q.Get("page") == "1" {
+					w.Header().Set("X-Next-Page", "2")
+				}
+				fmt.Fprint(w, `[`+result+`]`)
+			})
+
+			got, err := c.ListOpenIssues(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.ListOpenIssues(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			issue := newIssue(base, "open", "alert:boom:", "extra")
+			want := []*github.Issue{issue, issue}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.ListOpenIssues(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_LabelIssue(t *testing.T) {
+	base, _ := url.Parse("http://gitlab.example.com/api/v4/")
+	tests := []struct {
+		name        string
+		issue       *github.Issue
+		label       string
+		add         bool
+		httpCode    int
+		errorSubstr string
+	}{
+		{
+			name:  "success-label",
+			issue: newIssue(base, "open"),
+			label: "alert::resolved",
+			add:   true,
+		},
+		{
+			name:  "success-unlabel",
+			issue: newIssue(base, "open"),
+			label: "alert::resolved",
+		},
+		{
+			name:  "success-noop-label",
+			issue: newIssue(base, "open"),
+		},
+		{
+			name:        "failure-label-bad",
+			issue:       newIssue(base, "open"),
+			label:       "my label",
+			add:         true,
+			httpCode:    http.StatusBadRequest,
+			errorSubstr: "fake error",
+		},
+		{
+			name:        "failure-bad-issue",
+			issue:       &github.Issue{Number: github.Int(7)},
+			label:       "my label",
+			errorSubstr: "invalid RepositoryURL",
+		},
+		{
+			name: "failure-bad-repository-url",
+			issue: &github.Issue{
+				Number:        github.Int(7),
+				RepositoryURL: github.String("https://gitlab.example.com/api/v4/groups/fake-group"),
+			},
+			label:       "my label",
+			errorSubstr: "invalid RepositoryURL path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v4/")
+			c, _ := gitlab.NewClient(base.String(), "fake-group", "FAKE-AUTH-TOKEN", "alert:boom:")
+
+			mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+				checkRequest(t, r, http.MethodPut, "/api/v4/projects/fake-group%2Ffake-repo/issues/7")
+				v := map[string]string{}
+				json.NewDecoder(r.Body).Decode(&v)
+				if tt.add && v["add_labels"] != tt.label {
+					t.Errorf("Request add_labels = %q, want %q", v["add_labels"], tt.label)
+				}
+				if !tt.add && v["remove_labels"] != tt.label {
+					t.Errorf("Request remove_labels = %q, want %q", v["remove_labels"], tt.label)
+				}
+				if tt.httpCode != 0 {
+					w.WriteHeader(tt.httpCode)
+					fmt.Fprint(w, `{"message": "fake error"}`)
+					return
+				}
+				fmt.Fprint(w, result)
+			})
+
+			err := c.LabelIssue(context.Background(), tt.issue, tt.label, tt.add)
+			if err != nil {
+				if tt.errorSubstr == "" {
+					t.Error(err)
+				} else if !strings.Contains(err.Error(), tt.errorSubstr) {
+					t.Errorf("error %q but want %q", err.Error(), tt.errorSubstr)
+				}
+			} else if tt.errorSubstr != "" {
+				t.Errorf("no error but want %q", tt.errorSubstr)
+			}
+		})
+	}
+}
+
+func TestClient_CloseIssue(t *testing.T) {
+	tests := []struct {
+		name    string
+		issue   func(base *url.URL) *github.Issue
+		wantErr bool
+	}{
+		{
+			name:  "success",
+			issue: func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+		},
+		{
+			name:    "error-empty-repository-url",
+			issue:   func(base *url.URL) *github.Issue { return &github.Issue{} },
+			wantErr: true,
+		},
+		{
+			name:    "error-close-returns-error",
+			issue:   func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/api/v4/")
+			c, _ := gitlab.NewClient(base.String(), "fake-group", "FAKE-AUTH-TOKEN", "alert:boom:")
+
+			mux.HandleFunc("/api/v4/projects/", func(w http.ResponseWriter, r *http.Request) {
+				checkRequest(t, r, http.MethodPut, "/api/v4/projects/fake-group%2Ffake-repo/issues/7")
+				v := map[string]string{}
+				json.NewDecoder(r.Body).Decode(&v)
+				if v["state_event"] != "close" {
+					t.Errorf("Request state_event = %q, want close", v["state_event"])
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"message":"404 Not found"}`)
+					return
+				}
+				fmt.Fprint(w, closedResult)
+			})
+
+			got, err := c.CloseIssue(context.Background(), tt.issue(base))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CloseIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			want := newIssue(base, "closed")
+			want.Body = github.String("")
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.CloseIssue(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := gitlab.NewClient("", "fake-group", "FAKE-AUTH-TOKEN", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseURL.String() != gitlab.DefaultBaseURL {
+		t.Errorf("NewClient() BaseURL = %q, want %q", c.BaseURL, gitlab.DefaultBaseURL)
+	}
+	c, err = gitlab.NewClient("https://gitlab.example.com/api/v4", "fake-group", "FAKE-AUTH-TOKEN", "alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseURL.String() != "https://gitlab.example.com/api/v4/" {
+		t.Errorf("NewClient() BaseURL = %q, want trailing slash", c.BaseURL)
+	}
+	_, err = gitlab.NewClient("invalidURLEscape%zz", "fake-group", "FAKE-AUTH-TOKEN", "alert")
+	if err == nil {
+		t.Errorf("NewClient() got nil error, want error")
+	}
+}
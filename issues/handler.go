@@ -17,56 +17,190 @@ package issues
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"time"
 
 	"github.com/google/go-github/github"
 )
 
 const (
-	listRawHTMLTemplate = `
-<html><body>
-<h2>Open Issues</h2>
+	listRawHTMLTemplate = `<!DOCTYPE html>
+<html><head>
+<title>Open Issues</title>
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { padding: 0.3em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+tr.resolved { background: #e6f4ea; }
+.badge { display: inline-block; padding: 0 0.5em; margin-right: 0.3em; border-radius: 1em; background: #ddf4ff; font-size: 0.85em; }
+.error { color: #b00; }
+form { margin-bottom: 1.5em; }
+</style>
+</head><body>
+<h2>Open Issues ({{.Total}})</h2>
+<form method="get" action="/">
+  Title <input name="title" value="{{.Query.Get "title"}}">
+  Repo <input name="repo" value="{{.Query.Get "repo"}}">
+  Label <input name="label" value="{{.Query.Get "label"}}">
+  Sort <select name="sort">
+  {{range .Sorts}}<option value="{{.}}"{{if eq . $.Sort}} selected{{end}}>{{.}}</option>{{end}}
+  </select>
+  <input type="submit" value="Filter">
+</form>
+{{range .Repos}}
+<h3>{{.Name}} ({{len .Issues}})</h3>
 <table>
-{{range .}}
-  <tr><td><a href={{.HTMLURL}}>{{.Title}}</a></td></tr>
+<tr><th>#</th><th>Title</th><th>Labels</th><th>Age</th></tr>
+{{range .Issues}}
+  <tr{{if .Resolved}} class="resolved"{{end}}>
+    <td>{{.Number}}</td>
+    <td><a href="{{.URL}}">{{.Title}}</a>{{if .Resolved}} (resolved){{end}}</td>
+    <td>{{range .Labels}}<span class="badge">{{.}}</span>{{end}}</td>
+    <td>{{.Age}}</td>
+  </tr>
 {{end}}
 </table>
-<br/>
-Receiver metrics: <a href="/metrics" onclick="javascript:event.target.port=9990">/metrics</a>
+{{end}}
+<h3>Receiver health</h3>
+<table>
+<tr><td>Last successful Github call</td><td>{{if .Health.LastSuccess.IsZero}}none{{else}}{{.Health.LastSuccess.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+{{if .HealthError}}<tr><td>Last failed Github call</td><td class="error">{{.Health.LastError.Format "2006-01-02 15:04:05 MST"}}: {{.Health.Error}}</td></tr>{{end}}
+{{range .Rates}}<tr><td>Rate limit remaining ({{.API}})</td><td>{{.Remaining}} of {{.Limit}}, reset at {{.Reset.Format "15:04:05 MST"}}</td></tr>{{end}}
+</table>
+{{if .MetricsURL}}Receiver metrics: <a href="{{.MetricsURL}}">{{.MetricsURL}}</a>{{end}}
 </body></html>`
 )
 
 var (
 	listTemplate = template.Must(template.New("list").Parse(listRawHTMLTemplate))
+
+	// listSorts are the supported issue orders of the status page.
+	listSorts = []string{"number", "age", "title"}
 )
 
 // ListClient defines an interface for listing issues.
 type ListClient interface {
-	ListOpenIssues() ([]*github.Issue, error)
+	ListOpenIssues(ctx context.Context) ([]*github.Issue, error)
 }
 
 // ListHandler contains data needed for HTTP handlers.
 type ListHandler struct {
 	ListClient
+
+	// ResolvedLabel marks issues of resolved alerts, which are highlighted.
+	ResolvedLabel string
+
+	// Refresh is the interval of automatic page reloads. Zero disables reloads.
+	Refresh time.Duration
+
+	// MetricsAddr is the listen address of the metrics server, e.g. ":9990".
+	// When the host is empty, the host of the request is used. When empty, no
+	// metrics link is shown.
+	MetricsAddr string
+}
+
+// listPage is the data of the status page.
+type listPage struct {
+	Total       int
+	Repos       []*listRepo
+	Query       url.Values
+	Sort        string
+	Sorts       []string
+	Refresh     int
+	Health      Health
+	HealthError bool
+	Rates       []listRate
+	MetricsURL  string
+}
+
+// listRepo contains the open issues of one repository.
+type listRepo struct {
+	Name   string
+	Issues []*listIssue
 }
 
-// ServeHTTP lists open issues from github for view in a browser.
+// listIssue is an open issue on the status page.
+type listIssue struct {
+	Number   int
+	Title    string
+	URL      string
+	Labels   []string
+	Age      string
+	Resolved bool
+	created  time.Time
+}
+
+// listRate is the rate limit of one Github API.
+type listRate struct {
+	API string
+	github.Rate
+}
+
+// ServeHTTP renders open issues grouped by repository, with the health of the
+// receiver, for view in a browser. The query parameters filter the issues like
+// the APIHandler, and "sort" orders them by "number", "age" or "title".
 func (lh *ListHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if req.URL.Path != "/" || req.Method != http.MethodGet {
 		rw.WriteHeader(http.StatusMethodNotAllowed)
 		fmt.Fprintf(rw, "Wrong method\n")
 		return
 	}
-	issues, err := lh.ListOpenIssues()
+	q := req.URL.Query()
+	f, err := parseFilter(q)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "%s\n", err)
+		return
+	}
+	page := &listPage{
+		Query:   q,
+		Sort:    q.Get("sort"),
+		Sorts:   listSorts,
+		Refresh: int(lh.Refresh.Seconds()),
+		Health:  CurrentHealth(),
+	}
+	if page.Sort == "" {
+		page.Sort = listSorts[0]
+	}
+	issues, err := lh.ListOpenIssues(req.Context())
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, "%s\n", err)
 		return
 	}
+	repos := make(map[string]*listRepo)
+	for _, issue := range issues {
+		if !f.Match(issue) {
+			continue
+		}
+		name := RepoName(issue)
+		if repos[name] == nil {
+			repos[name] = &listRepo{Name: name}
+			page.Repos = append(page.Repos, repos[name])
+		}
+		repos[name].Issues = append(repos[name].Issues, lh.newListIssue(issue, f.Now))
+		page.Total++
+	}
+	sort.Slice(page.Repos, func(i, j int) bool { return page.Repos[i].Name < page.Repos[j].Name })
+	for _, r := range page.Repos {
+		sortListIssues(r.Issues, page.Sort)
+	}
+	page.HealthError = page.Health.LastError.After(page.Health.LastSuccess)
+	for api, r := range page.Health.Rates {
+		page.Rates = append(page.Rates, listRate{API: api, Rate: r})
+	}
+	sort.Slice(page.Rates, func(i, j int) bool { return page.Rates[i].API < page.Rates[j].API })
+	page.MetricsURL = lh.metricsURL(req)
+
 	var buf bytes.Buffer
-	err = listTemplate.Execute(&buf, &issues)
+	err = listTemplate.Execute(&buf, page)
 	if err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, "%s\n", err)
@@ -74,3 +208,69 @@ func (lh *ListHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 	rw.Write(buf.Bytes())
 }
+
+// newListIssue returns the status page representation of the issue.
+func (lh *ListHandler) newListIssue(issue *github.Issue, now time.Time) *listIssue {
+	i := &listIssue{
+		Number:  issue.GetNumber(),
+		Title:   issue.GetTitle(),
+		URL:     issue.GetHTMLURL(),
+		created: issue.GetCreatedAt(),
+	}
+	if !i.created.IsZero() {
+		i.Age = formatAge(now.Sub(i.created))
+	}
+	for _, l := range issue.Labels {
+		i.Labels = append(i.Labels, l.GetName())
+		if lh.ResolvedLabel != "" && l.GetName() == lh.ResolvedLabel {
+			i.Resolved = true
+		}
+	}
+	return i
+}
+
+// metricsURL returns the URL of the metrics server for the request, or an
+// empty string if MetricsAddr is not set.
+func (lh *ListHandler) metricsURL(req *http.Request) string {
+	if lh.MetricsAddr == "" {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(lh.MetricsAddr)
+	if err != nil {
+		return ""
+	}
+	if host == "" {
+		if host, _, err = net.SplitHostPort(req.Host); err != nil {
+			host = req.Host
+		}
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/metrics"
+}
+
+// sortListIssues sorts the issues by "number", "age" (oldest first) or
+// "title".
+func sortListIssues(issues []*listIssue, by string) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		switch by {
+		case "age":
+			return issues[i].created.Before(issues[j].created)
+		case "title":
+			return issues[i].Title < issues[j].Title
+		default:
+			return issues[i].Number < issues[j].Number
+		}
+	})
+}
+
+// formatAge returns the duration rounded to days and hours, hours and
+// minutes, or minutes.
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd%dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
@@ -15,11 +15,14 @@
 package issues
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/github"
 )
@@ -29,7 +32,7 @@ type fakeClient struct {
 	err    error
 }
 
-func (f *fakeClient) ListOpenIssues() ([]*github.Issue, error) {
+func (f *fakeClient) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
 	return f.issues, f.err
 }
 func TestListHandler_ServeHTTP(t *testing.T) {
@@ -107,3 +110,144 @@ func TestListHandler_ServeHTTP(t *testing.T) {
 		})
 	}
 }
+
+func TestListHandler_StatusPage(t *testing.T) {
+	now := time.Now()
+	issue := func(repo string, number int, title string, age time.Duration, labels ...string) *github.Issue {
+		i := &github.Issue{
+			Number:        github.Int(number),
+			Title:         github.String(title),
+			HTMLURL:       github.String(fmt.Sprintf("https://github.com/org/%s/issues/%d", repo, number)),
+			RepositoryURL: github.String("https://api.github.com/repos/org/" + repo),
+		}
+		created := now.Add(-age)
+		i.CreatedAt = &created
+		for _, l := range labels {
+			i.Labels = append(i.Labels, github.Label{Name: github.String(l)})
+		}
+		return i
+	}
+	client := &fakeClient{
+		issues: []*github.Issue{
+			issue("zeta", 3, "ZetaDown", time.Hour+5*time.Minute),
+			issue("alpha", 2, "BetaDown", 50*time.Hour, "alert:boom:", "resolved"),
+			issue("alpha", 1, "GammaDown", 7*time.Minute, "alert:boom:"),
+		},
+	}
+	recordHealth("core", &github.Response{Rate: github.Rate{Limit: 5000, Remaining: 4321}}, nil)
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		// want must appear in the page in this order.
+		want    []string
+		notWant []string
+	}{
+		{
+			name:           "grouped-by-repo",
+			url:            "/",
+			expectedStatus: http.StatusOK,
+			want:           []string{"alpha (2)", "<td>1</td>", "GammaDown", "BetaDown", "zeta (1)", "ZetaDown"},
+		},
+		{
+			name:           "labels-and-age",
+			url:            "/",
+			expectedStatus: http.StatusOK,
+			want:           []string{`<span class="badge">alert:boom:</span>`, "7m", "2d2h", "1h5m"},
+		},
+		{
+			name:           "resolved-highlighted",
+			url:            "/",
+			expectedStatus: http.StatusOK,
+			want:           []string{`<tr class="resolved">`, "BetaDown</a> (resolved)"},
+		},
+		{
+			name:           "health",
+			url:            "/",
+			expectedStatus: http.StatusOK,
+			want:           []string{`content="60"`, "Rate limit remaining (core)", "4321 of 5000", "http://example.com:9990/metrics"},
+			notWant:        []string{"Last successful Github call</td><td>none"},
+		},
+		{
+			name:           "sort-by-age",
+			url:            "/?sort=age",
+			expectedStatus: http.StatusOK,
+			want:           []string{`<option value="age" selected>`, "BetaDown", "GammaDown"},
+		},
+		{
+			name:           "sort-by-number",
+			url:            "/?sort=number",
+			expectedStatus: http.StatusOK,
+			want:           []string{"GammaDown", "BetaDown"},
+		},
+		{
+			name:           "filter-repo",
+			url:            "/?repo=zeta",
+			expectedStatus: http.StatusOK,
+			want:           []string{"Open Issues (1)", "ZetaDown"},
+			notWant:        []string{"alpha", "BetaDown"},
+		},
+		{
+			name:           "filter-title",
+			url:            "/?title=^Gamma",
+			expectedStatus: http.StatusOK,
+			want:           []string{"GammaDown"},
+			notWant:        []string{"BetaDown", "ZetaDown"},
+		},
+		{
+			name:           "bad-filter",
+			url:            "/?title=(",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://example.com:9393"+tt.url, nil)
+			lh := &ListHandler{
+				ListClient:    client,
+				ResolvedLabel: "resolved",
+				Refresh:       time.Minute,
+				MetricsAddr:   ":9990",
+			}
+			listTemplate = template.Must(template.New("list").Parse(listRawHTMLTemplate))
+			lh.ServeHTTP(rw, req)
+			if rw.Code != tt.expectedStatus {
+				t.Fatalf("ListHandler wrong status; want %d, got %d", tt.expectedStatus, rw.Code)
+			}
+			body := rw.Body.String()
+			last := -1
+			for _, s := range tt.want {
+				i := strings.Index(body, s)
+				if i < 0 {
+					t.Errorf("ListHandler page missing %q", s)
+				} else if i < last {
+					t.Errorf("ListHandler page has %q out of order", s)
+				}
+				last = i
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("ListHandler page contains %q", s)
+				}
+			}
+		})
+	}
+}
+
+func Test_formatAge(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 30 * time.Second, want: "0m"},
+		{d: 59 * time.Minute, want: "59m"},
+		{d: 5*time.Hour + 12*time.Minute, want: "5h12m"},
+		{d: 76 * time.Hour, want: "3d4h"},
+	}
+	for _, tt := range tests {
+		if got := formatAge(tt.d); got != tt.want {
+			t.Errorf("formatAge(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -18,16 +18,23 @@
 package issues
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
@@ -70,8 +77,80 @@ var (
 		},
 		[]string{"status"},
 	)
+	operationDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "issues_api_duration_seconds",
+			Help: "A histogram of API operation latencies.",
+		},
+		// The operation, e.g. "create" or "search", and the repository of the
+		// issue, or "" for operations that are not on a single repository.
+		[]string{"operation", "repo"},
+	)
+	operationErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "issues_api_errors_total",
+			Help: "Number of failed API operations by cause.",
+		},
+		// The cause is one of "rate_limit", "timeout", "5xx", "4xx" or "network".
+		[]string{"operation", "repo", "cause"},
+	)
 )
 
+// Health describes the recent API calls of all Clients, and the requests
+// recorded by RecordRequest.
+type Health struct {
+	// LastSuccess is the time of the last successful API call.
+	LastSuccess time.Time
+	// LastError is the time of the last failed API call, and Error its error.
+	LastError time.Time
+	Error     string
+	// Failures is the number of consecutive failed API calls.
+	Failures int
+	// Unauthorized is true if the last API call was rejected because the
+	// token is invalid.
+	Unauthorized bool
+	// Rates contains the last known rate limits, by API, e.g. "search".
+	Rates map[string]github.Rate
+}
+
+// health records the API calls of all Clients.
+var health = struct {
+	sync.Mutex
+	Health
+}{Health: Health{Rates: make(map[string]github.Rate)}}
+
+// CurrentHealth returns the health of recent Github API calls.
+func CurrentHealth() Health {
+	health.Lock()
+	defer health.Unlock()
+	h := health.Health
+	h.Rates = make(map[string]github.Rate, len(health.Rates))
+	for api, r := range health.Rates {
+		h.Rates[api] = r
+	}
+	return h
+}
+
+// recordHealth records the outcome of an API call.
+func recordHealth(api string, resp *github.Response, err error) {
+	health.Lock()
+	defer health.Unlock()
+	now := time.Now()
+	if err != nil {
+		health.LastError = now
+		health.Error = err.Error()
+		health.Failures++
+		health.Unauthorized = resp != nil && resp.Response != nil && resp.StatusCode == http.StatusUnauthorized
+	} else {
+		health.LastSuccess = now
+		health.Failures = 0
+		health.Unauthorized = false
+	}
+	if resp != nil && resp.Rate.Limit > 0 {
+		health.Rates[api] = resp.Rate
+	}
+}
+
 // A Client manages communication with the Github API.
 type Client struct {
 	// githubClient is an authenticated client for accessing the github API.
@@ -92,7 +171,7 @@ func NewClient(org, authToken, alertLabel string) *Client {
 	)
 
 	client := &Client{
-		GithubClient: github.NewClient(oauth2.NewClient(ctx, tokenSource)),
+		GithubClient: github.NewClient(newHTTPClient(ctx, tokenSource)),
 		org:          org,
 		alertLabel:   alertLabel,
 	}
@@ -112,7 +191,7 @@ func NewEnterpriseClient(baseURL, uploadURL, org, authToken, alertLabel string)
 		uploadURL = baseURL
 	}
 
-	githubClient, err := github.NewEnterpriseClient(baseURL, uploadURL, oauth2.NewClient(ctx, tokenSource))
+	githubClient, err := github.NewEnterpriseClient(baseURL, uploadURL, newHTTPClient(ctx, tokenSource))
 
 	client := &Client{
 		GithubClient: githubClient,
@@ -122,44 +201,59 @@ func NewEnterpriseClient(baseURL, uploadURL, org, authToken, alertLabel string)
 	return client, err
 }
 
+// newHTTPClient creates an HTTP client that authenticates every request using
+// the token source, and traces every request.
+func newHTTPClient(ctx context.Context, tokenSource oauth2.TokenSource) *http.Client {
+	client := oauth2.NewClient(ctx, tokenSource)
+	client.Transport = &tracing.Transport{Base: client.Transport}
+	return client
+}
+
 // CreateIssue creates a new Github issue. New issues are unassigned. Issues are
 // labeled with with an alert named alertLabel. Labels are created automatically
 // if they do not already exist in a repo.
-func (c *Client) CreateIssue(repo, title, body string, extra []string) (*github.Issue, error) {
-	labels := make([]string, len(extra)+1)
-	labels[0] = c.alertLabel
-	for i := range extra {
-		labels[i+1] = extra[i]
-	}
-	// Construct a minimal github issue request.
-	issueReq := github.IssueRequest{
-		Title:  &title,
-		Body:   &body,
-		Labels: &labels, // Search using: label:alertLabel
-	}
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	issueReq := c.newIssueRequest(title, body, extra)
 
 	// Enforce a timeout on the issue creation.
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
+	ctx, span := c.startSpan(ctx, "CreateIssue", attribute.String("github.repo", repo))
 
 	// Create the issue.
 	// See also: https://developer.github.com/v3/issues/#create-an-issue
 	// See also: https://godoc.org/github.com/google/go-github/github#IssuesService.Create
+	start := time.Now()
 	issue, resp, err := c.GithubClient.Issues.Create(
-		ctx, c.org, repo, &issueReq)
-	updateRateMetrics("issues", resp, err)
+		ctx, c.org, repo, issueReq)
+	updateMetrics("create", "issues", repo, start, resp, err)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("Error in CreateIssue: response: %v", err)
+		logging.FromContext(ctx).Error("Failed to create issue", "repo", repo, "title", title, "error", err)
 		return nil, err
 	}
 	return issue, nil
 }
 
+// newIssueRequest constructs a minimal github issue request for a new issue.
+func (c *Client) newIssueRequest(title, body string, extra []string) *github.IssueRequest {
+	labels := make([]string, len(extra)+1)
+	labels[0] = c.alertLabel
+	for i := range extra {
+		labels[i+1] = extra[i]
+	}
+	return &github.IssueRequest{
+		Title:  &title,
+		Body:   &body,
+		Labels: &labels, // Search using: label:alertLabel
+	}
+}
+
 // LabelIssue adds or removes a label from an issue. This call is idempotent;
 // it returns errors if there's a network error, but
 // no error is returned if trying to add a label that's already present or
 // remove one that's absent.
-func (c *Client) LabelIssue(issue *github.Issue, label string, add bool) error {
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
 	// The GitHub API is weird about which actions are idempotent:
 	// - Adding a label that exists in the project is idempotent.
 	// - Adding a label that doesn't exist will silently create it.
@@ -172,24 +266,28 @@ func (c *Client) LabelIssue(issue *github.Issue, label string, add bool) error {
 		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
 
 	org, repo, err := getOrgAndRepoFromIssue(issue)
 	if err != nil {
 		return err
 	}
+	ctx, span := c.startIssueSpan(ctx, "LabelIssue", org, repo, issue)
+	span.SetAttributes(attribute.String("github.label", label), attribute.Bool("github.add", add))
 
 	var resp *github.Response
+	start := time.Now()
 	if add {
 		_, resp, err = c.GithubClient.Issues.AddLabelsToIssue(ctx, org, repo, *issue.Number, []string{label})
 	} else {
 		resp, err = c.GithubClient.Issues.RemoveLabelForIssue(ctx, org, repo, *issue.Number, label)
-		if resp.StatusCode == http.StatusNotFound {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
 			err = nil
 		}
 	}
-	updateRateMetrics("issues", resp, err)
+	updateMetrics("label", "issues", repo, start, resp, err)
+	tracing.End(span, err)
 
 	return err
 }
@@ -198,13 +296,14 @@ func (c *Client) LabelIssue(issue *github.Issue, label string, add bool) error {
 // client organization. Because ListOpenIssues uses the Github Search API,
 // the *github.Issue instances returned will contain partial information.
 // See also: https://developer.github.com/v3/search/#search-issues
-func (c *Client) ListOpenIssues() ([]*github.Issue, error) {
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
 	var allIssues []*github.Issue
 
+	ctx, span := c.startSpan(ctx, "ListOpenIssues")
 	sopts := &github.SearchOptions{}
 	for {
 		// Enforce a timeout on the issue listing.
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 		defer cancel()
 
 		// Github issues are either "open" or "closed". Closed issues have either been
@@ -213,16 +312,18 @@ func (c *Client) ListOpenIssues() ([]*github.Issue, error) {
 		// number of issues returned.
 		//
 		// The search depends on all relevant issues including the alertLabel label.
+		start := time.Now()
 		issues, resp, err := c.GithubClient.Search.Issues(
 			ctx, `is:issue in:title is:open org:`+c.org+` label:"`+c.alertLabel+`"`, sopts)
-		updateRateMetrics("search", resp, err)
+		updateMetrics("search", "search", "", start, resp, err)
 		if err != nil {
-			log.Printf("Failed to list open github issues: %v\n", err)
+			logging.FromContext(ctx).Error("Failed to list open github issues", "error", err)
+			tracing.End(span, err)
 			return nil, err
 		}
 		// Collect 'em all.
 		for i := range issues.Issues {
-			log.Println("ListOpenIssues:", issues.Issues[i].GetTitle())
+			logging.FromContext(ctx).Debug("Found open issue", "title", issues.Issues[i].GetTitle())
 			allIssues = append(allIssues, &issues.Issues[i])
 		}
 
@@ -232,12 +333,14 @@ func (c *Client) ListOpenIssues() ([]*github.Issue, error) {
 		}
 		sopts.ListOptions.Page = resp.NextPage
 	}
+	span.SetAttributes(attribute.Int("github.issues", len(allIssues)))
+	tracing.End(span, nil)
 	return allIssues, nil
 }
 
 // CloseIssue changes the issue state to "closed" unconditionally. If the issue
 // is already close, then this should have no effect.
-func (c *Client) CloseIssue(issue *github.Issue) (*github.Issue, error) {
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
 	issueReq := github.IssueRequest{
 		State: github.String("closed"),
 	}
@@ -246,22 +349,280 @@ func (c *Client) CloseIssue(issue *github.Issue) (*github.Issue, error) {
 		return nil, err
 	}
 	// Enforce a timeout on the issue edit.
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
 	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "CloseIssue", org, repo, issue)
 
 	// Edits the issue to have "closed" state.
 	// See also: https://developer.github.com/v3/issues/#edit-an-issue
 	// See also: https://godoc.org/github.com/google/go-github/github#IssuesService.Edit
+	start := time.Now()
 	closedIssue, resp, err := c.GithubClient.Issues.Edit(
 		ctx, org, repo, *issue.Number, &issueReq)
-	updateRateMetrics("issues", resp, err)
+	updateMetrics("close", "issues", repo, start, resp, err)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("Failed to close issue: %v", err)
+		logging.FromContext(ctx).Error("Failed to close issue", "url", issue.GetHTMLURL(), "error", err)
 		return nil, err
 	}
 	return closedIssue, nil
 }
 
+// GetIssue returns the current state of the issue, including who closed it.
+func (c *Client) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	// Enforce a timeout on the issue get.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "GetIssue", org, repo, issue)
+
+	// See also: https://developer.github.com/v3/issues/#get-a-single-issue
+	start := time.Now()
+	current, resp, err := c.GithubClient.Issues.Get(ctx, org, repo, issue.GetNumber())
+	updateMetrics("get", "issues", repo, start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to get issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	return current, nil
+}
+
+// CheckRateLimits gets the current rate limits, which does not count against
+// them, and records them in the health of API calls.
+func (c *Client) CheckRateLimits(ctx context.Context) error {
+	// Enforce a timeout on the rate limit check.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startSpan(ctx, "CheckRateLimits")
+
+	// See also: https://developer.github.com/v3/rate_limit/
+	start := time.Now()
+	limits, resp, err := c.GithubClient.RateLimits(ctx)
+	updateMetrics("rate_limits", "core", "", start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	// Every API but search shares the core rate limit. The core limit is
+	// always recorded, even before any call of those APIs.
+	health.Lock()
+	defer health.Unlock()
+	if limits.Core != nil {
+		health.Rates["core"] = *limits.Core
+		for api := range health.Rates {
+			if api != "search" {
+				health.Rates[api] = *limits.Core
+			}
+		}
+	}
+	if limits.Search != nil {
+		health.Rates["search"] = *limits.Search
+	}
+	return nil
+}
+
+// ReopenIssue changes the issue state to "open" unconditionally.
+func (c *Client) ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	issueReq := github.IssueRequest{
+		State: github.String("open"),
+	}
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	// Enforce a timeout on the issue edit.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "ReopenIssue", org, repo, issue)
+
+	start := time.Now()
+	reopenedIssue, resp, err := c.GithubClient.Issues.Edit(
+		ctx, org, repo, issue.GetNumber(), &issueReq)
+	updateMetrics("edit", "issues", repo, start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to reopen issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	return reopenedIssue, nil
+}
+
+// RetitleIssue changes the title of the issue.
+func (c *Client) RetitleIssue(ctx context.Context, issue *github.Issue, title string) (*github.Issue, error) {
+	issueReq := github.IssueRequest{
+		Title: &title,
+	}
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	// Enforce a timeout on the issue edit.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "RetitleIssue", org, repo, issue)
+
+	start := time.Now()
+	retitledIssue, resp, err := c.GithubClient.Issues.Edit(
+		ctx, org, repo, issue.GetNumber(), &issueReq)
+	updateMetrics("edit", "issues", repo, start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to retitle issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	return retitledIssue, nil
+}
+
+// CommentIssue adds a comment with the given Markdown body to the issue.
+func (c *Client) CommentIssue(ctx context.Context, issue *github.Issue, body string) error {
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return err
+	}
+	// Enforce a timeout on the comment creation.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "CommentIssue", org, repo, issue)
+
+	// See also: https://developer.github.com/v3/issues/comments/#create-a-comment
+	start := time.Now()
+	_, resp, err := c.GithubClient.Issues.CreateComment(
+		ctx, org, repo, issue.GetNumber(), &github.IssueComment{Body: &body})
+	updateMetrics("comment", "issues", repo, start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to comment on issue", "url", issue.GetHTMLURL(), "error", err)
+		return err
+	}
+	return nil
+}
+
+// AssignIssue adds the users to the assignees of the issue. Github ignores
+// users that cannot be assigned.
+func (c *Client) AssignIssue(ctx context.Context, issue *github.Issue, users []string) error {
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return err
+	}
+	// Enforce a timeout on the issue edit.
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "AssignIssue", org, repo, issue)
+
+	// See also: https://developer.github.com/v3/issues/assignees/#add-assignees-to-an-issue
+	start := time.Now()
+	_, resp, err := c.GithubClient.Issues.AddAssignees(ctx, org, repo, issue.GetNumber(), users)
+	updateMetrics("assign", "issues", repo, start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to assign issue", "url", issue.GetHTMLURL(), "error", err)
+		return err
+	}
+	return nil
+}
+
+// IsCollaborator reports whether the user is a collaborator of the issue
+// repository.
+func (c *Client) IsCollaborator(ctx context.Context, issue *github.Issue, user string) (bool, error) {
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	ctx, span := c.startIssueSpan(ctx, "IsCollaborator", org, repo, issue)
+
+	// See also: https://developer.github.com/v3/repos/collaborators/#check-if-a-user-is-a-collaborator
+	start := time.Now()
+	ok, resp, err := c.GithubClient.Repositories.IsCollaborator(ctx, org, repo, user)
+	updateMetrics("collaborator", "repos", repo, start, resp, err)
+	tracing.End(span, err)
+	return ok, err
+}
+
+// IsTeamMember reports whether the user is an active member of the team with
+// the given slug in the client organization.
+func (c *Client) IsTeamMember(ctx context.Context, team, user string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	// The go-github team methods use the deprecated team ID routes, so use the
+	// team slug route directly.
+	// See also: https://docs.github.com/en/rest/teams/members#get-team-membership-for-a-user
+	u := fmt.Sprintf("orgs/%s/teams/%s/memberships/%s", url.PathEscape(c.org), url.PathEscape(team), url.PathEscape(user))
+	req, err := c.GithubClient.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	ctx, span := c.startSpan(ctx, "IsTeamMember", attribute.String("github.team", team))
+	membership := &github.Membership{}
+	start := time.Now()
+	resp, err := c.GithubClient.Do(ctx, req, membership)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// The user is not a member of the team.
+		err = nil
+	}
+	updateMetrics("team", "teams", "", start, resp, err)
+	tracing.End(span, err)
+	if err != nil {
+		return false, err
+	}
+	return membership.GetState() == "active", nil
+}
+
+// NewCreateIssueRequest returns the API request that CreateIssue sends,
+// without sending it.
+func (c *Client) NewCreateIssueRequest(repo, title, body string, extra []string) (*http.Request, error) {
+	u := fmt.Sprintf("repos/%v/%v/issues", c.org, repo)
+	return c.GithubClient.NewRequest(http.MethodPost, u, c.newIssueRequest(title, body, extra))
+}
+
+// NewLabelIssueRequest returns the API request that LabelIssue sends, without
+// sending it. The request is nil if LabelIssue sends no request.
+func (c *Client) NewLabelIssueRequest(issue *github.Issue, label string, add bool) (*http.Request, error) {
+	if label == "" {
+		return nil, nil
+	}
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("repos/%v/%v/issues/%d/labels", org, repo, issue.GetNumber())
+	if add {
+		return c.GithubClient.NewRequest(http.MethodPost, u, []string{label})
+	}
+	return c.GithubClient.NewRequest(http.MethodDelete, u+"/"+label, nil)
+}
+
+// NewCloseIssueRequest returns the API request that CloseIssue sends, without
+// sending it.
+func (c *Client) NewCloseIssueRequest(issue *github.Issue) (*http.Request, error) {
+	org, repo, err := getOrgAndRepoFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("repos/%v/%v/issues/%d", org, repo, issue.GetNumber())
+	return c.GithubClient.NewRequest(http.MethodPatch, u, &github.IssueRequest{State: github.String("closed")})
+}
+
+// startSpan starts the span of the named client operation. The span is the
+// parent of the spans of the API requests of the operation.
+func (c *Client) startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "github."+op, append(attrs, attribute.String("github.org", c.org))...)
+}
+
+// startIssueSpan starts the span of the named client operation on the issue.
+func (c *Client) startIssueSpan(ctx context.Context, op, org, repo string, issue *github.Issue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "github."+op,
+		attribute.String("github.org", org),
+		attribute.String("github.repo", repo),
+		attribute.Int("github.issue", issue.GetNumber()))
+}
+
 // getOrgAndRepoFromIssue reads the issue RepositoryURL and extracts the
 // owner and repo names. Issues returned by the Search API contain partial
 // records.
@@ -291,16 +652,74 @@ func getOrgAndRepoFromIssue(issue *github.Issue) (string, string, error) {
 	return "", "", fmt.Errorf("issue has invalid RepositoryURL path values")
 }
 
+// updateMetrics records the outcome and latency of an API operation started
+// at start, and the rate limits of the response. The repo is empty for
+// operations that are not on a single repository. The response may be nil,
+// e.g. after a network error.
+func updateMetrics(op, api, repo string, start time.Time, resp *github.Response, err error) {
+	operationDuration.WithLabelValues(op, repo).Observe(time.Since(start).Seconds())
+	if err != nil {
+		operationErrors.WithLabelValues(op, repo, errorCause(resp, err)).Inc()
+	}
+	updateRateMetrics(api, resp, err)
+}
+
+// RecordRequest records the outcome and latency of a request started at start
+// to the API of another issue tracker, e.g. GitLab, in the same metrics and
+// Health as the API calls of a Client. These APIs report no rate limits. The
+// response may be nil, e.g. after a network error.
+func RecordRequest(op string, start time.Time, resp *http.Response, err error) {
+	operationDuration.WithLabelValues(op, "").Observe(time.Since(start).Seconds())
+	r := &github.Response{Response: resp}
+	if err != nil {
+		operationErrors.WithLabelValues(op, "", errorCause(r, err)).Inc()
+	}
+	recordHealth("", r, err)
+	if resp == nil {
+		operationCount.WithLabelValues("none").Inc()
+		return
+	}
+	operationCount.WithLabelValues(resp.Status).Inc()
+}
+
+// errorCause classifies the cause of a failed API operation as one of
+// "rate_limit", "timeout", "5xx", "4xx" or "network".
+func errorCause(resp *github.Response, err error) string {
+	switch err.(type) {
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return "rate_limit"
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	if resp != nil && resp.Response != nil {
+		switch {
+		case resp.StatusCode >= 500:
+			return "5xx"
+		case resp.StatusCode >= 400:
+			return "4xx"
+		}
+	}
+	return "network"
+}
+
 func updateRateMetrics(api string, resp *github.Response, err error) {
+	recordHealth(api, resp, err)
+	// If the err is a RateLimitError, then increment the rateError counter.
+	if _, ok := err.(*github.RateLimitError); ok {
+		slog.Warn("Hit rate limit", "api", api)
+		rateErrorCount.Inc()
+	}
+	if resp == nil || resp.Response == nil {
+		// There is no response after a network error.
+		operationCount.WithLabelValues("none").Inc()
+		return
+	}
 	// Update rate limit metrics.
 	rateLimit.WithLabelValues(api).Set(float64(resp.Rate.Limit))
 	rateRemaining.WithLabelValues(api).Set(float64(resp.Rate.Remaining))
 	rateResetTime.WithLabelValues(api).Set(float64(resp.Rate.Reset.UTC().Unix()))
 	// Count the number of API operations per HTTP Status.
 	operationCount.WithLabelValues(resp.Status).Inc()
-	// If the err is a RateLimitError, then increment the rateError counter.
-	if _, ok := err.(*github.RateLimitError); ok {
-		log.Println("Hit rate limit!")
-		rateErrorCount.Inc()
-	}
 }
@@ -15,6 +15,7 @@
 package issues_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -28,6 +29,10 @@ import (
 	"github.com/google/go-github/github"
 	"github.com/m-lab/alertmanager-github-receiver/issues"
 	"github.com/m-lab/go/rtx"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Global vars for tests.
@@ -125,9 +130,9 @@ func TestClient_CreateIssue(t *testing.T) {
 				}
 			})
 
-			got, err := c.CreateIssue(tt.repo, tt.title, tt.body, tt.extra)
+			got, err := c.CreateIssue(context.Background(), tt.repo, tt.title, tt.body, tt.extra)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Client.CreateIssue() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Client.CreateIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
@@ -207,13 +212,13 @@ func TestClient_ListOpenIssues(t *testing.T) {
 				w.Write([]byte(listResults))
 			})
 
-			got, err := c.ListOpenIssues()
+			got, err := c.ListOpenIssues(context.Background())
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Client.ListOpenIssues() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Client.ListOpenIssues(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Client.ListOpenIssues() = %v, want %v", got, tt.want)
+				t.Errorf("Client.ListOpenIssues(context.Background()) = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -291,7 +296,7 @@ func TestClient_LabelIssue(t *testing.T) {
 				w.Write([]byte(`[{}]`))
 			})
 
-			err := c.LabelIssue(tt.issue, tt.label, tt.addLabel)
+			err := c.LabelIssue(context.Background(), tt.issue, tt.label, tt.addLabel)
 			if err != nil {
 				if tt.errorSubstr == "" {
 					t.Error(err)
@@ -399,13 +404,295 @@ func TestClient_CloseIssue(t *testing.T) {
 				fmt.Fprintf(w, `{"number":1, "repository_url":"%s"}`, tt.issue.GetRepositoryURL())
 			})
 
-			got, err := c.CloseIssue(tt.issue)
+			got, err := c.CloseIssue(context.Background(), tt.issue)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Client.CloseIssue() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Client.CloseIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Client.CloseIssue() = %v, want %v", got, tt.want)
+				t.Errorf("Client.CloseIssue(context.Background()) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_GetIssue(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+
+	testMux.HandleFunc("/repos/fake-org/fake-repo/issues/1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("wrong method; got %s, want GET", r.Method)
+		}
+		fmt.Fprint(w, `{"number":1, "state":"closed", "closed_by": {"login": "octocat"}}`)
+	})
+
+	issue := &github.Issue{
+		Number:        github.Int(1),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+	}
+	got, err := c.GetIssue(context.Background(), issue)
+	if err != nil {
+		t.Fatalf("Client.GetIssue(context.Background()) error = %v", err)
+	}
+	if got.GetState() != "closed" || got.GetClosedBy().GetLogin() != "octocat" {
+		t.Errorf("Client.GetIssue(context.Background()) = %v, want issue closed by octocat", got)
+	}
+	issue.Number = github.Int(2)
+	if _, err := c.GetIssue(context.Background(), issue); err == nil {
+		t.Errorf("Client.GetIssue(context.Background()) got nil error for missing issue, want error")
+	}
+	if _, err := c.GetIssue(context.Background(), &github.Issue{}); err == nil {
+		t.Errorf("Client.GetIssue(context.Background()) got nil error for empty RepositoryURL, want error")
+	}
+}
+
+func TestClient_CheckRateLimits(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+
+	reset := time.Now().Add(time.Hour).Unix()
+	testMux.HandleFunc("/rate_limit", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"resources": {"core": {"limit": 5000, "remaining": 0, "reset": %d},
+			"search": {"limit": 30, "remaining": 0, "reset": %d}}}`, reset, reset)
+	})
+
+	if err := c.CheckRateLimits(context.Background()); err != nil {
+		t.Fatalf("Client.CheckRateLimits() error = %v", err)
+	}
+	hl := issues.CurrentHealth()
+	if hl.Failures != 0 || hl.LastSuccess.IsZero() {
+		t.Errorf("Client.CheckRateLimits() health = %+v, want success", hl)
+	}
+	// The search rate limit is only in the response body.
+	if r := issues.CurrentHealth().Rates["search"]; r.Limit != 30 || r.Remaining != 0 {
+		t.Errorf("Client.CheckRateLimits() search rate = %+v, want 0 of 30 remaining", r)
+	}
+	// An exhausted core rate limit is recorded before any other call.
+	if r := issues.CurrentHealth().Rates["core"]; r.Limit != 5000 || r.Remaining != 0 {
+		t.Errorf("Client.CheckRateLimits() core rate = %+v, want 0 of 5000 remaining", r)
+	}
+}
+
+func TestClient_ReopenIssue(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+
+	testMux.HandleFunc("/repos/fake-org/fake-repo/issues/1", func(w http.ResponseWriter, r *http.Request) {
+		v := &github.IssueRequest{}
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil || v.GetState() != "open" {
+			t.Errorf("wrong issue request; got %v, want state open", v)
+		}
+		fmt.Fprint(w, `{"number":1, "state":"open"}`)
+	})
+
+	issue := &github.Issue{
+		Number:        github.Int(1),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+	}
+	got, err := c.ReopenIssue(context.Background(), issue)
+	if err != nil {
+		t.Fatalf("Client.ReopenIssue(context.Background()) error = %v", err)
+	}
+	if got.GetState() != "open" {
+		t.Errorf("Client.ReopenIssue(context.Background()) = %v, want open issue", got)
+	}
+	issue.Number = github.Int(2)
+	if _, err := c.ReopenIssue(context.Background(), issue); err == nil {
+		t.Errorf("Client.ReopenIssue(context.Background()) got nil error for missing issue, want error")
+	}
+	if _, err := c.ReopenIssue(context.Background(), &github.Issue{}); err == nil {
+		t.Errorf("Client.ReopenIssue(context.Background()) got nil error for empty RepositoryURL, want error")
+	}
+}
+
+func TestClient_RetitleIssue(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+
+	testMux.HandleFunc("/repos/fake-org/fake-repo/issues/1", func(w http.ResponseWriter, r *http.Request) {
+		v := &github.IssueRequest{}
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil || v.GetTitle() != "new title" || v.State != nil {
+			t.Errorf("wrong issue request; got %v, want only title", v)
+		}
+		fmt.Fprint(w, `{"number":1, "title":"new title"}`)
+	})
+
+	issue := &github.Issue{
+		Number:        github.Int(1),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+	}
+	got, err := c.RetitleIssue(context.Background(), issue, "new title")
+	if err != nil {
+		t.Fatalf("Client.RetitleIssue(context.Background()) error = %v", err)
+	}
+	if got.GetTitle() != "new title" {
+		t.Errorf("Client.RetitleIssue(context.Background()) = %v, want new title", got)
+	}
+	issue.Number = github.Int(2)
+	if _, err := c.RetitleIssue(context.Background(), issue, "new title"); err == nil {
+		t.Errorf("Client.RetitleIssue(context.Background()) got nil error for missing issue, want error")
+	}
+	if _, err := c.RetitleIssue(context.Background(), &github.Issue{}, "new title"); err == nil {
+		t.Errorf("Client.RetitleIssue(context.Background()) got nil error for empty RepositoryURL, want error")
+	}
+}
+
+func TestClient_CommentIssue(t *testing.T) {
+	tests := []struct {
+		name    string
+		issue   *github.Issue
+		wantErr bool
+	}{
+		{
+			name: "success",
+			issue: &github.Issue{
+				Number:        github.Int(1),
+				RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+			},
+		},
+		{
+			name:    "error-empty-repository-url",
+			issue:   &github.Issue{Number: github.Int(1)},
+			wantErr: true,
+		},
+		{
+			name: "error-comment-returns-error",
+			issue: &github.Issue{
+				Number:        github.Int(2),
+				RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+			c.GithubClient.BaseURL = setupServer()
+			defer teardownServer()
+
+			testMux.HandleFunc("/repos/fake-org/fake-repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+				v := &github.IssueComment{}
+				if err := json.NewDecoder(r.Body).Decode(v); err != nil || v.GetBody() != "fake comment" {
+					t.Errorf("wrong comment; got %v, want 'fake comment'", v)
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"id":1}`)
+			})
+
+			err := c.CommentIssue(context.Background(), tt.issue, "fake comment")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CommentIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient_AssignIssue(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+
+	testMux.HandleFunc("/repos/fake-org/fake-repo/issues/1/assignees", func(w http.ResponseWriter, r *http.Request) {
+		v := &struct {
+			Assignees []string `json:"assignees"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil || !reflect.DeepEqual(v.Assignees, []string{"octocat"}) {
+			t.Errorf("wrong assignees; got %v, want [octocat]", v.Assignees)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"number":1}`)
+	})
+
+	issue := &github.Issue{
+		Number:        github.Int(1),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+	}
+	if err := c.AssignIssue(context.Background(), issue, []string{"octocat"}); err != nil {
+		t.Errorf("Client.AssignIssue(context.Background()) error = %v", err)
+	}
+	issue.Number = github.Int(2)
+	if err := c.AssignIssue(context.Background(), issue, []string{"octocat"}); err == nil {
+		t.Errorf("Client.AssignIssue(context.Background()) got nil error for missing issue, want error")
+	}
+	if err := c.AssignIssue(context.Background(), &github.Issue{}, []string{"octocat"}); err == nil {
+		t.Errorf("Client.AssignIssue(context.Background()) got nil error for empty RepositoryURL, want error")
+	}
+}
+
+func TestClient_permissions(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+
+	testMux.HandleFunc("/repos/fake-org/fake-repo/collaborators/octocat", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	testMux.HandleFunc("/orgs/fake-org/teams/oncall/memberships/octocat", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"state":"active","role":"member"}`)
+	})
+	testMux.HandleFunc("/orgs/fake-org/teams/oncall/memberships/invited", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"state":"pending","role":"member"}`)
+	})
+	testMux.HandleFunc("/orgs/fake-org/teams/broken/memberships/octocat", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	issue := &github.Issue{
+		Number:        github.Int(1),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+	}
+	tests := []struct {
+		name    string
+		check   func() (bool, error)
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:  "collaborator",
+			check: func() (bool, error) { return c.IsCollaborator(context.Background(), issue, "octocat") },
+			want:  true,
+		},
+		{
+			name:  "not-collaborator",
+			check: func() (bool, error) { return c.IsCollaborator(context.Background(), issue, "mallory") },
+		},
+		{
+			name:    "collaborator-bad-issue",
+			check:   func() (bool, error) { return c.IsCollaborator(context.Background(), &github.Issue{}, "octocat") },
+			wantErr: true,
+		},
+		{
+			name:  "team-member",
+			check: func() (bool, error) { return c.IsTeamMember(context.Background(), "oncall", "octocat") },
+			want:  true,
+		},
+		{
+			name:  "team-pending-member",
+			check: func() (bool, error) { return c.IsTeamMember(context.Background(), "oncall", "invited") },
+		},
+		{
+			name:  "not-team-member",
+			check: func() (bool, error) { return c.IsTeamMember(context.Background(), "oncall", "mallory") },
+		},
+		{
+			name:    "team-error",
+			check:   func() (bool, error) { return c.IsTeamMember(context.Background(), "broken", "octocat") },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.check()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -427,16 +714,16 @@ func TestClient_rateLimit(t *testing.T) {
 
 	// Call once with fresh client to populate the x-ratelimit values returned
 	// by the server.
-	_, err := c.CreateIssue("fake-repo", "fake-title", "fake-body", nil)
+	_, err := c.CreateIssue(context.Background(), "fake-repo", "fake-title", "fake-body", nil)
 	if err != nil {
-		t.Errorf("Client.CreateIssue() error = %v, wantErr nil", err)
+		t.Errorf("Client.CreateIssue(context.Background()) error = %v, wantErr nil", err)
 		return
 	}
 
 	// Use the same client again, and expect an error due to rate limits.
-	_, err = c.CreateIssue("fake-repo", "fake-title", "fake-body", nil)
+	_, err = c.CreateIssue(context.Background(), "fake-repo", "fake-title", "fake-body", nil)
 	if err == nil {
-		t.Errorf("Client.CreateIssue() got nil, want rate error")
+		t.Errorf("Client.CreateIssue(context.Background()) got nil, want rate error")
 		return
 	}
 }
@@ -455,3 +742,68 @@ func TestClient_newEnterpriseClient(t *testing.T) {
 		return
 	}
 }
+
+func TestClient_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "alert")
+	c.GithubClient.BaseURL = setupServer()
+	defer teardownServer()
+	testMux.HandleFunc("/search/issues", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `<https://api.github.com/resource?page=2>; rel="next"`)
+		}
+		w.Write([]byte(listResults))
+	})
+	if _, err := c.ListOpenIssues(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every page is requested within its own span.
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3: %v", len(spans), spans)
+	}
+	list := spans[2]
+	if list.Name != "github.ListOpenIssues" {
+		t.Errorf("got span %q, want github.ListOpenIssues", list.Name)
+	}
+	for i, page := range []string{"", "2"} {
+		s := spans[i]
+		if s.Name != "HTTP GET" || s.Parent.SpanID() != list.SpanContext.SpanID() {
+			t.Errorf("got span %q with parent %s, want HTTP GET child of %s", s.Name, s.Parent.SpanID(), list.SpanContext.SpanID())
+		}
+		got := ""
+		for _, kv := range s.Attributes {
+			if kv.Key == "github.page" {
+				got = kv.Value.AsString()
+			}
+		}
+		if got != page {
+			t.Errorf("span %d has page %q, want %q", i, got, page)
+		}
+	}
+}
+
+func TestClient_networkError(t *testing.T) {
+	c := issues.NewClient("fake-org", "FAKE-AUTH-TOKEN", "alert")
+	c.GithubClient.BaseURL = setupServer()
+	// Requests to a closed server fail without a response.
+	teardownServer()
+
+	issue := &github.Issue{
+		Number:        github.Int(1),
+		RepositoryURL: github.String("https://api.github.com/repos/fake-org/fake-repo"),
+	}
+	if err := c.LabelIssue(context.Background(), issue, "resolved", false); err == nil {
+		t.Errorf("Client.LabelIssue() error = nil, want network error")
+	}
+	if _, err := c.CreateIssue(context.Background(), "fake-repo", "fake-title", "fake-body", nil); err == nil {
+		t.Errorf("Client.CreateIssue() error = nil, want network error")
+	}
+	if _, err := c.ListOpenIssues(context.Background()); err == nil {
+		t.Errorf("Client.ListOpenIssues() error = nil, want network error")
+	}
+}
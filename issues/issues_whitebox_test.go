@@ -1,8 +1,13 @@
 package issues
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/google/go-github/github"
 	"github.com/m-lab/go/prometheusx/promtest"
 )
 
@@ -11,5 +16,42 @@ func TestMetrics(t *testing.T) {
 	rateRemaining.WithLabelValues("x")
 	rateResetTime.WithLabelValues("x")
 	operationCount.WithLabelValues("x")
+	operationDuration.WithLabelValues("x", "x")
+	operationErrors.WithLabelValues("x", "x", "x")
 	promtest.LintMetrics(t)
 }
+
+func Test_errorCause(t *testing.T) {
+	status := func(code int) *github.Response {
+		return &github.Response{Response: &http.Response{StatusCode: code}}
+	}
+	tests := []struct {
+		name string
+		resp *github.Response
+		err  error
+		want string
+	}{
+		{name: "rate-limit", resp: status(403), err: &github.RateLimitError{}, want: "rate_limit"},
+		{name: "abuse-rate-limit", resp: status(403), err: &github.AbuseRateLimitError{}, want: "rate_limit"},
+		{name: "timeout", err: context.DeadlineExceeded, want: "timeout"},
+		{name: "server-error", resp: status(502), err: errors.New("bad gateway"), want: "5xx"},
+		{name: "client-error", resp: status(422), err: errors.New("validation failed"), want: "4xx"},
+		{name: "network", err: errors.New("connection refused"), want: "network"},
+		{name: "empty-response", resp: &github.Response{}, err: errors.New("EOF"), want: "network"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorCause(tt.resp, tt.err); got != tt.want {
+				t.Errorf("errorCause() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_updateMetrics_noResponse(t *testing.T) {
+	// A network error has no response, and must not panic.
+	updateMetrics("create", "issues", "repo", time.Now(), nil, errors.New("connection refused"))
+	if h := CurrentHealth(); h.Failures == 0 {
+		t.Errorf("CurrentHealth().Failures = 0, want the failure recorded")
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package jira implements issue operations using the Jira Cloud or Jira
+// Server REST API.
+//
+// Jira tickets are converted to *github.Issue values so that the Client
+// satisfies the same interface as the Github client. The "repo" of an issue
+// is a Jira project key. The issue Number is the numeric part of the ticket
+// key (e.g. 123 for "OPS-123"), and the RepositoryURL is the API URL of the
+// project, e.g. https://example.atlassian.net/rest/api/2/project/OPS
+package jira
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest"
+	"github.com/m-lab/alertmanager-github-receiver/logging"
+)
+
+// ComponentPrefix marks labels that are mapped to Jira components instead of
+// Jira labels. For example, the label "component:storage" adds the issue to
+// the "storage" component.
+const ComponentPrefix = "component:"
+
+// pageSize is the number of issues requested per search page.
+const pageSize = 100
+
+// timeLayout is the format of timestamps returned by the Jira API.
+const timeLayout = "2006-01-02T15:04:05.000-0700"
+
+// A Client manages communication with the Jira API.
+type Client struct {
+	// BaseURL is the Jira site URL, e.g. https://example.atlassian.net/
+	BaseURL *url.URL
+	// HTTPClient is used for all requests to the Jira API.
+	HTTPClient *http.Client
+	// IssueType is the name of the issue type of new tickets, e.g. "Task".
+	IssueType string
+	// ResolvedStatus is the name of the status that tickets are transitioned
+	// to when they are closed, e.g. "Done".
+	ResolvedStatus string
+	// user is the account email for Jira Cloud basic authentication. When
+	// empty, the authToken is used as a bearer token (Jira Server/Data Center
+	// personal access token).
+	user string
+	// authToken is an API token or personal access token.
+	authToken string
+	// alertLabel is the label applied to all alerts. It is also used as the
+	// label to search to discover all existing alerts.
+	alertLabel string
+}
+
+type named struct {
+	Name string `json:"name"`
+}
+
+// status is a Jira workflow status. Every status belongs to one of the
+// categories "new", "indeterminate" or "done".
+type status struct {
+	Name           string `json:"name"`
+	StatusCategory struct {
+		Key string `json:"key"`
+	} `json:"statusCategory"`
+}
+
+// text is a Jira rich text field. The v2 API uses plain strings, and the v3
+// API uses Atlassian Document Format documents, from which the text is read.
+type text string
+
+// UnmarshalJSON decodes a string or a document.
+func (t *text) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = text(s)
+		return nil
+	}
+	doc := &adfNode{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return err
+	}
+	*t = text(doc.text())
+	return nil
+}
+
+// adfNode is a node of an Atlassian Document Format document.
+// See also: https://developer.atlassian.com/cloud/jira/platform/apis/document/structure/
+type adfNode struct {
+	Type    string    `json:"type"`
+	Text    string    `json:"text"`
+	Content []adfNode `json:"content"`
+}
+
+// text returns the text of the node. The blocks of a document, like
+// paragraphs and list items, are separated by newlines.
+func (n *adfNode) text() string {
+	switch n.Type {
+	case "text":
+		return n.Text
+	case "hardBreak":
+		return "\n"
+	}
+	sep := "\n"
+	if n.Type == "paragraph" || n.Type == "heading" || n.Type == "codeBlock" {
+		sep = ""
+	}
+	parts := make([]string, len(n.Content))
+	for i := range n.Content {
+		parts[i] = n.Content[i].text()
+	}
+	return strings.Join(parts, sep)
+}
+
+type project struct {
+	Key string `json:"key"`
+}
+
+// fields is the subset of Jira issue fields used by the Client.
+type fields struct {
+	Project     *project `json:"project,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+	Description text     `json:"description,omitempty"`
+	IssueType   *named   `json:"issuetype,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Components  []named  `json:"components,omitempty"`
+	Status      *status  `json:"status,omitempty"`
+	Created     string   `json:"created,omitempty"`
+	Updated     string   `json:"updated,omitempty"`
+}
+
+// jiraIssue is the subset of a Jira issue used by the Client.
+// See also: https://developer.atlassian.com/cloud/jira/platform/rest/v2/api-group-issues/
+type jiraIssue struct {
+	ID     string `json:"id,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Fields fields `json:"fields"`
+}
+
+// NewClient creates a Client for the Jira site at baseURL. When user is not
+// empty, requests use basic authentication with the user and authToken (Jira
+// Cloud). Otherwise, the authToken is sent as a bearer token (Jira Server).
+func NewClient(baseURL, user, authToken, alertLabel, issueType, resolvedStatus string) (*Client, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("jira base URL is required")
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		BaseURL:        u,
+		HTTPClient:     rest.NewHTTPClient(),
+		IssueType:      issueType,
+		ResolvedStatus: resolvedStatus,
+		user:           user,
+		authToken:      authToken,
+		alertLabel:     alertLabel,
+	}
+	return client, nil
+}
+
+// CreateIssue creates a new ticket in the Jira project with the key given by
+// repo. Tickets are labeled with the alertLabel and any extra labels. Extra
+// labels starting with ComponentPrefix are added as components instead.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	labels, components := splitLabels(append([]string{c.alertLabel}, extra...))
+	issueReq := &jiraIssue{
+		Fields: fields{
+			Project:     &project{Key: repo},
+			Summary:     title,
+			Description: text(toWiki(body)),
+			IssueType:   &named{Name: c.IssueType},
+			Labels:      labels,
+			Components:  components,
+		},
+	}
+	// See also: https://developer.atlassian.com/cloud/jira/platform/rest/v2/api-group-issues/#api-rest-api-2-issue-post
+	created := &jiraIssue{}
+	_, err := c.do(ctx, http.MethodPost, "rest/api/2/issue", issueReq, created)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to create issue", "repo", repo, "title", title, "error", err)
+		return nil, err
+	}
+	// The create response only includes the new issue id and key.
+	created.Fields = issueReq.Fields
+	created.Fields.Status = &status{Name: "open"}
+	return c.toGithubIssue(created), nil
+}
+
+// LabelIssue adds or removes a label from a ticket. Labels starting with
+// ComponentPrefix add or remove a component instead. This call is idempotent.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
+	if label == "" {
+		return nil
+	}
+	key, err := getKeyFromIssue(issue)
+	if err != nil {
+		return err
+	}
+	op := "remove"
+	if add {
+		op = "add"
+	}
+	field, value := "labels", interface{}(sanitizeLabel(label))
+	if strings.HasPrefix(label, ComponentPrefix) {
+		field, value = "components", named{Name: strings.TrimPrefix(label, ComponentPrefix)}
+	}
+	update := map[string]interface{}{
+		"update": map[string][]map[string]interface{}{
+			field: {{op: value}},
+		},
+	}
+	// See also: https://developer.atlassian.com/cloud/jira/platform/rest/v2/api-group-issues/#api-rest-api-2-issue-issueidorkey-put
+	_, err = c.do(ctx, http.MethodPut, "rest/api/2/issue/"+key, update, nil)
+	return err
+}
+
+// ListOpenIssues returns all tickets with the alertLabel whose status is not in
+// the "done" category, discovered using a JQL search.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	jql := fmt.Sprintf("labels = %q AND statusCategory != Done ORDER BY created ASC", sanitizeLabel(c.alertLabel))
+	fields := []string{"project", "summary", "description", "labels", "components", "status", "created", "updated"}
+	if c.user == "" {
+		// Jira Server does not provide the Jira Cloud search API.
+		return c.searchServer(ctx, jql, fields)
+	}
+	var allIssues []*github.Issue
+	for token := ""; ; {
+		searchReq := map[string]interface{}{
+			"jql":        jql,
+			"maxResults": pageSize,
+			"fields":     fields,
+		}
+		if token != "" {
+			searchReq["nextPageToken"] = token
+		}
+		// See also: https://developer.atlassian.com/cloud/jira/platform/rest/v3/api-group-issue-search/#api-rest-api-3-search-jql-post
+		result := &struct {
+			Issues        []*jiraIssue
+			NextPageToken string
+			IsLast        bool
+		}{}
+		_, err := c.do(ctx, http.MethodPost, "rest/api/3/search/jql", searchReq, result)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to list open jira issues", "error", err)
+			return nil, err
+		}
+		for i := range result.Issues {
+			allIssues = append(allIssues, c.toGithubIssue(result.Issues[i]))
+		}
+		// Continue loading the next page until all issues are received.
+		if result.IsLast || result.NextPageToken == "" {
+			break
+		}
+		token = result.NextPageToken
+	}
+	return allIssues, nil
+}
+
+// searchServer returns all tickets matching the JQL search, using the Jira
+// Server search API.
+func (c *Client) searchServer(ctx context.Context, jql string, fields []string) ([]*github.Issue, error) {
+	var allIssues []*github.Issue
+	for startAt := 0; ; {
+		searchReq := map[string]interface{}{
+			"jql":        jql,
+			"startAt":    startAt,
+			"maxResults": pageSize,
+			"fields":     fields,
+		}
+		// See also: https://docs.atlassian.com/software/jira/docs/api/REST/latest/#api/2/search-searchUsingSearchRequest
+		result := &struct {
+			StartAt int
+			Total   int
+			Issues  []*jiraIssue
+		}{}
+		_, err := c.do(ctx, http.MethodPost, "rest/api/2/search", searchReq, result)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to list open jira issues", "error", err)
+			return nil, err
+		}
+		for i := range result.Issues {
+			allIssues = append(allIssues, c.toGithubIssue(result.Issues[i]))
+		}
+		// Continue loading the next page until all issues are received.
+		startAt += len(result.Issues)
+		if len(result.Issues) == 0 || startAt >= result.Total {
+			break
+		}
+	}
+	return allIssues, nil
+}
+
+// CloseIssue transitions the ticket to the ResolvedStatus. Jira workflows do
+// not have a fixed "closed" state, so the transition is discovered from the
+// transitions available for the ticket.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	key, err := getKeyFromIssue(issue)
+	if err != nil {
+		return nil, err
+	}
+	// See also: https://developer.atlassian.com/cloud/jira/platform/rest/v2/api-group-issues/#api-rest-api-2-issue-issueidorkey-transitions-get
+	transitions := &struct {
+		Transitions []struct {
+			ID   string
+			Name string
+			To   named
+		}
+	}{}
+	_, err = c.do(ctx, http.MethodGet, "rest/api/2/issue/"+key+"/transitions", nil, transitions)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to close issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	id := ""
+	for _, t := range transitions.Transitions {
+		if strings.EqualFold(t.To.Name, c.ResolvedStatus) || strings.EqualFold(t.Name, c.ResolvedStatus) {
+			id = t.ID
+			break
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("issue %s has no transition to status %q", key, c.ResolvedStatus)
+	}
+	// See also: https://developer.atlassian.com/cloud/jira/platform/rest/v2/api-group-issues/#api-rest-api-2-issue-issueidorkey-transitions-post
+	transition := map[string]interface{}{
+		"transition": map[string]string{"id": id},
+	}
+	_, err = c.do(ctx, http.MethodPost, "rest/api/2/issue/"+key+"/transitions", transition, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to close issue", "url", issue.GetHTMLURL(), "error", err)
+		return nil, err
+	}
+	closed := *issue
+	closed.State = github.String("closed")
+	return &closed, nil
+}
+
+// do sends an API request with an optional JSON body to the path relative to
+// the client BaseURL. A successful JSON response is decoded into result, when
+// result is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) (*http.Response, error) {
+	req, err := rest.NewRequest(ctx, method, c.BaseURL, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.authToken)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+	return rest.Do(c.HTTPClient, req, result)
+}
+
+// toGithubIssue converts a Jira issue to the equivalent Github issue.
+func (c *Client) toGithubIssue(i *jiraIssue) *github.Issue {
+	var labels []github.Label
+	for n := range i.Fields.Labels {
+		labels = append(labels, github.Label{Name: github.String(i.Fields.Labels[n])})
+	}
+	for n := range i.Fields.Components {
+		labels = append(labels, github.Label{Name: github.String(ComponentPrefix + i.Fields.Components[n].Name)})
+	}
+	state := "open"
+	if s := i.Fields.Status; s != nil && (s.StatusCategory.Key == "done" || strings.EqualFold(s.Name, c.ResolvedStatus)) {
+		state = "closed"
+	}
+	projectKey, number := splitKey(i.Key)
+	gi := &github.Issue{
+		Number: github.Int(number),
+		Title:  github.String(i.Fields.Summary),
+		Body:   github.String(fromWiki(string(i.Fields.Description))),
+		State:  github.String(state),
+		Labels: labels,
+	}
+	if id, err := strconv.ParseInt(i.ID, 10, 64); err == nil {
+		gi.ID = github.Int64(id)
+	}
+	if u, err := c.BaseURL.Parse("browse/" + i.Key); err == nil {
+		gi.HTMLURL = github.String(u.String())
+	}
+	if u, err := c.BaseURL.Parse("rest/api/2/project/" + projectKey); err == nil && projectKey != "" {
+		gi.RepositoryURL = github.String(u.String())
+	}
+	if t, err := time.Parse(timeLayout, i.Fields.Created); err == nil {
+		gi.CreatedAt = &t
+	}
+	if t, err := time.Parse(timeLayout, i.Fields.Updated); err == nil {
+		gi.UpdatedAt = &t
+	}
+	return gi
+}
+
+var (
+	htmlComment     = regexp.MustCompile(`(?s)<!--.*?-->`)
+	noformatComment = regexp.MustCompile(`(?s)\{noformat\}\n(<!--.*?-->)\n\{noformat\}`)
+)
+
+// toWiki puts every HTML comment of a Markdown issue body, like the alert
+// metadata, in a {noformat} block. Jira wiki markup has no comments, so the
+// comment is shown either way, but the block keeps Jira from formatting its
+// text.
+func toWiki(body string) string {
+	return htmlComment.ReplaceAllString(body, "{noformat}\n$0\n{noformat}")
+}
+
+// fromWiki returns the HTML comments of a description created by toWiki to
+// the body, so that the alert metadata can be read back.
+func fromWiki(description string) string {
+	return noformatComment.ReplaceAllString(description, "$1")
+}
+
+// splitLabels separates Jira labels from components. Spaces are not allowed
+// in Jira labels, so they are replaced.
+func splitLabels(all []string) ([]string, []named) {
+	var labels []string
+	var components []named
+	for _, l := range all {
+		if strings.HasPrefix(l, ComponentPrefix) {
+			components = append(components, named{Name: strings.TrimPrefix(l, ComponentPrefix)})
+			continue
+		}
+		labels = append(labels, sanitizeLabel(l))
+	}
+	return labels, components
+}
+
+// sanitizeLabel replaces characters that are not allowed in Jira labels.
+func sanitizeLabel(label string) string {
+	return strings.ReplaceAll(label, " ", "_")
+}
+
+// splitKey returns the project key and number of a ticket key like "OPS-123".
+func splitKey(key string) (string, int) {
+	i := strings.LastIndex(key, "-")
+	if i < 0 {
+		return "", 0
+	}
+	n, _ := strconv.Atoi(key[i+1:])
+	return key[:i], n
+}
+
+// getKeyFromIssue reads the issue RepositoryURL and Number and returns the
+// Jira ticket key, e.g. "OPS-123".
+func getKeyFromIssue(issue *github.Issue) (string, error) {
+	repoURL := issue.GetRepositoryURL()
+	if repoURL == "" {
+		return "", fmt.Errorf("issue has invalid RepositoryURL value")
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Split(strings.TrimSuffix(u.Path, "/"), "/")
+	if len(fields) < 2 || fields[len(fields)-2] != "project" || issue.GetNumber() == 0 {
+		return "", fmt.Errorf("issue has invalid RepositoryURL path values")
+	}
+	return fields[len(fields)-1] + "-" + strconv.Itoa(issue.GetNumber()), nil
+}
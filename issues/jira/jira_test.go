@@ -0,0 +1,489 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package jira_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/issues/jira"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest/resttest"
+	"github.com/m-lab/alertmanager-github-receiver/metadata"
+)
+
+const (
+	result = `{
+		"id": "10042",
+		"key": "OPS-42",
+		"fields": {
+			"summary": "DiskRunningFull",
+			"description": "fake issue body",
+			"labels": ["alert:boom:"],
+			"components": [{"name": "storage"}],
+			"status": {"name": "To Do"},
+			"created": "2020-06-01T10:00:00.000+0000",
+			"updated": "2020-06-01T11:00:00.000+0000"
+		}
+	}`
+)
+
+func newIssue(base *url.URL, state string, labels ...string) *github.Issue {
+	i := &github.Issue{
+		ID:            github.Int64(10042),
+		Number:        github.Int(42),
+		Title:         github.String("DiskRunningFull"),
+		Body:          github.String("fake issue body"),
+		State:         github.String(state),
+		HTMLURL:       github.String(base.String() + "browse/OPS-42"),
+		RepositoryURL: github.String(base.String() + "rest/api/2/project/OPS"),
+	}
+	for _, l := range labels {
+		i.Labels = append(i.Labels, github.Label{Name: github.String(l)})
+	}
+	return i
+}
+
+func newClient(t *testing.T, base *url.URL) *jira.Client {
+	c, err := jira.NewClient(base.String(), "user@example.com", "FAKE-AUTH-TOKEN", "alert:boom:", "Task", "Done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func checkAuth(t *testing.T, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "user@example.com" || pass != "FAKE-AUTH-TOKEN" {
+		t.Errorf("Request does not contain basic auth credentials")
+	}
+}
+
+func TestClient_CreateIssue(t *testing.T) {
+	tests := []struct {
+		name           string
+		extra          []string
+		wantLabels     []string
+		wantComponents []string
+		wantErr        bool
+	}{
+		{
+			name:       "success",
+			extra:      []string{"extra label"},
+			wantLabels: []string{"alert:boom:", "extra_label"},
+		},
+		{
+			name:           "success-components",
+			extra:          []string{"component:storage"},
+			wantLabels:     []string{"alert:boom:"},
+			wantComponents: []string{"storage"},
+		},
+		{
+			name:       "create-returns-error",
+			wantLabels: []string{"alert:boom:"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/")
+			c := newClient(t, base)
+
+			mux.HandleFunc("/rest/api/2/issue", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				v := struct {
+					Fields struct {
+						Project     struct{ Key string }
+						Summary     string
+						Description string
+						IssueType   struct{ Name string }
+						Labels      []string
+						Components  []struct{ Name string }
+					}
+				}{}
+				json.NewDecoder(r.Body).Decode(&v)
+				f := v.Fields
+				if f.Project.Key != "OPS" || f.Summary != "DiskRunningFull" || f.IssueType.Name != "Task" {
+					t.Errorf("Request = %+v, want project, summary and issue type", f)
+				}
+				if !reflect.DeepEqual(f.Labels, tt.wantLabels) {
+					t.Errorf("Request labels = %v, want %v", f.Labels, tt.wantLabels)
+				}
+				var components []string
+				for _, c := range f.Components {
+					components = append(components, c.Name)
+				}
+				if !reflect.DeepEqual(components, tt.wantComponents) {
+					t.Errorf("Request components = %v, want %v", components, tt.wantComponents)
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"errorMessages":[],"errors":{"project":"project is required"}}`)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprint(w, `{"id":"10042","key":"OPS-42","self":"`+base.String()+`rest/api/2/issue/10042"}`)
+			})
+
+			got, err := c.CreateIssue(context.Background(), "OPS", "DiskRunningFull", "fake issue body", tt.extra)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CreateIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			labels := tt.wantLabels
+			for _, c := range tt.wantComponents {
+				labels = append(labels, jira.ComponentPrefix+c)
+			}
+			want := newIssue(base, "open", labels...)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.CreateIssue(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestClient_CreateIssue_metadata(t *testing.T) {
+	mux, base := resttest.NewServer(t, "/")
+	c := newClient(t, base)
+	meta, err := metadata.Format(&metadata.Metadata{GroupKey: "{}:{alertname=\"DiskRunningFull\"}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "fake issue body\n" + meta
+
+	mux.HandleFunc("/rest/api/2/issue", func(w http.ResponseWriter, r *http.Request) {
+		v := struct{ Fields struct{ Description string } }{}
+		json.NewDecoder(r.Body).Decode(&v)
+		want := "fake issue body\n\n{noformat}\n" + strings.TrimSpace(meta) + "\n{noformat}\n"
+		if v.Fields.Description != want {
+			t.Errorf("Request description = %q, want %q", v.Fields.Description, want)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id":"10042","key":"OPS-42"}`)
+	})
+
+	got, err := c.CreateIssue(context.Background(), "OPS", "DiskRunningFull", body, nil)
+	if err != nil {
+		t.Fatalf("Client.CreateIssue() error = %v", err)
+	}
+	if got.GetBody() != body {
+		t.Errorf("Client.CreateIssue() body = %q, want %q", got.GetBody(), body)
+	}
+	if m, err := metadata.Parse(got.GetBody()); err != nil || m.GroupKey != "{}:{alertname=\"DiskRunningFull\"}" {
+		t.Errorf("metadata.Parse() = %v, %v, want the group key", m, err)
+	}
+}
+
+func TestClient_ListOpenIssues(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{
+			name: "success",
+		},
+		{
+			name:    "list-returns-error",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/")
+			c := newClient(t, base)
+
+			mux.HandleFunc("/rest/api/3/search/jql", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				v := struct {
+					JQL           string
+					NextPageToken string
+				}{}
+				json.NewDecoder(r.Body).Decode(&v)
+				if want := `labels = "alert:boom:" AND statusCategory != Done ORDER BY created ASC`; v.JQL != want {
+					t.Errorf("Request JQL = %q, want %q", v.JQL, want)
+				}
+				if tt.wantErr {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"errorMessages":["bad jql"]}`)
+					return
+				}
+				if v.NextPageToken == "" {
+					fmt.Fprintf(w, `{"nextPageToken": "page-2", "isLast": false, "issues": [%s]}`, result)
+					return
+				}
+				// The v3 API returns descriptions as documents.
+				doc := `{"type": "doc", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "fake issue body"}]}]}`
+				fmt.Fprintf(w, `{"isLast": true, "issues": [%s]}`, strings.Replace(result, `"fake issue body"`, doc, 1))
+			})
+
+			got, err := c.ListOpenIssues(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.ListOpenIssues(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			issue := newIssue(base, "open", "alert:boom:", "component:storage")
+			created := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+			updated := created.Add(time.Hour)
+			issue.CreatedAt = &created
+			issue.UpdatedAt = &updated
+			if len(got) != 2 {
+				t.Fatalf("Client.ListOpenIssues(context.Background()) returned %d issues, want 2", len(got))
+			}
+			// Compare times separately since the parsed locations differ.
+			for _, g := range got {
+				if !g.GetCreatedAt().Equal(created) || !g.GetUpdatedAt().Equal(updated) {
+					t.Errorf("Client.ListOpenIssues(context.Background()) times = %v %v, want %v %v", g.CreatedAt, g.UpdatedAt, created, updated)
+				}
+				g.CreatedAt, g.UpdatedAt = issue.CreatedAt, issue.UpdatedAt
+				if !reflect.DeepEqual(g, issue) {
+					t.Errorf("Client.ListOpenIssues(context.Background()) = %v, want %v", g, issue)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_LabelIssue(t *testing.T) {
+	base, _ := url.Parse("http://jira.example.com/")
+	tests := []struct {
+		name        string
+		issue       *github.Issue
+		label       string
+		add         bool
+		httpCode    int
+		wantUpdate  string
+		errorSubstr string
+	}{
+		{
+			name:       "success-label",
+			issue:      newIssue(base, "open"),
+			label:      "resolved",
+			add:        true,
+			wantUpdate: `{"update":{"labels":[{"add":"resolved"}]}}`,
+		},
+		{
+			name:       "success-unlabel",
+			issue:      newIssue(base, "open"),
+			label:      "resolved",
+			wantUpdate: `{"update":{"labels":[{"remove":"resolved"}]}}`,
+		},
+		{
+			name:       "success-component",
+			issue:      newIssue(base, "open"),
+			label:      "component:storage",
+			add:        true,
+			wantUpdate: `{"update":{"components":[{"add":{"name":"storage"}}]}}`,
+		},
+		{
+			name:  "success-noop-label",
+			issue: newIssue(base, "open"),
+		},
+		{
+			name:        "failure-label-bad",
+			issue:       newIssue(base, "open"),
+			label:       "resolved",
+			add:         true,
+			httpCode:    http.StatusBadRequest,
+			wantUpdate:  `{"update":{"labels":[{"add":"resolved"}]}}`,
+			errorSubstr: "fake error",
+		},
+		{
+			name:        "failure-bad-issue",
+			issue:       &github.Issue{Number: github.Int(42)},
+			label:       "resolved",
+			errorSubstr: "invalid RepositoryURL",
+		},
+		{
+			name: "failure-bad-repository-url",
+			issue: &github.Issue{
+				Number:        github.Int(42),
+				RepositoryURL: github.String("http://jira.example.com/rest/api/2/OPS"),
+			},
+			label:       "resolved",
+			errorSubstr: "invalid RepositoryURL path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/")
+			c := newClient(t, base)
+
+			update := ""
+			mux.HandleFunc("/rest/api/2/issue/OPS-42", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				if r.Method != http.MethodPut {
+					t.Errorf("Request method = %q, want PUT", r.Method)
+				}
+				b, _ := io.ReadAll(r.Body)
+				update = string(b)
+				if tt.httpCode != 0 {
+					w.WriteHeader(tt.httpCode)
+					fmt.Fprint(w, `{"errorMessages":["fake error"]}`)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			err := c.LabelIssue(context.Background(), tt.issue, tt.label, tt.add)
+			if err != nil {
+				if tt.errorSubstr == "" {
+					t.Error(err)
+				} else if !strings.Contains(err.Error(), tt.errorSubstr) {
+					t.Errorf("error %q but want %q", err.Error(), tt.errorSubstr)
+				}
+			} else if tt.errorSubstr != "" {
+				t.Errorf("no error but want %q", tt.errorSubstr)
+			}
+			if update != tt.wantUpdate {
+				t.Errorf("Client.LabelIssue(context.Background()) update = %s, want %s", update, tt.wantUpdate)
+			}
+		})
+	}
+}
+
+func TestClient_CloseIssue(t *testing.T) {
+	tests := []struct {
+		name           string
+		issue          func(base *url.URL) *github.Issue
+		transitions    string
+		wantTransition string
+		transitionErr  bool
+		wantErr        bool
+	}{
+		{
+			name:           "success",
+			issue:          func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+			transitions:    `{"transitions":[{"id":"11","name":"Start","to":{"name":"In Progress"}},{"id":"31","name":"Resolve","to":{"name":"Done"}}]}`,
+			wantTransition: "31",
+		},
+		{
+			name:        "error-no-transition",
+			issue:       func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+			transitions: `{"transitions":[{"id":"11","name":"Start","to":{"name":"In Progress"}}]}`,
+			wantErr:     true,
+		},
+		{
+			name:    "error-empty-repository-url",
+			issue:   func(base *url.URL) *github.Issue { return &github.Issue{} },
+			wantErr: true,
+		},
+		{
+			name:        "error-list-transitions",
+			issue:       func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+			transitions: `error`,
+			wantErr:     true,
+		},
+		{
+			name:           "error-transition-returns-error",
+			issue:          func(base *url.URL) *github.Issue { return newIssue(base, "open") },
+			transitions:    `{"transitions":[{"id":"31","name":"Resolve","to":{"name":"Done"}}]}`,
+			wantTransition: "31",
+			transitionErr:  true,
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux, base := resttest.NewServer(t, "/")
+			c := newClient(t, base)
+
+			transition := ""
+			mux.HandleFunc("/rest/api/2/issue/OPS-42/transitions", func(w http.ResponseWriter, r *http.Request) {
+				checkAuth(t, r)
+				if r.Method == http.MethodGet {
+					if tt.transitions == "error" {
+						w.WriteHeader(http.StatusNotFound)
+					}
+					fmt.Fprint(w, tt.transitions)
+					return
+				}
+				v := struct{ Transition struct{ ID string } }{}
+				json.NewDecoder(r.Body).Decode(&v)
+				transition = v.Transition.ID
+				if tt.transitionErr {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			got, err := c.CloseIssue(context.Background(), tt.issue(base))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Client.CloseIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if transition != tt.wantTransition {
+				t.Errorf("Client.CloseIssue(context.Background()) transition = %q, want %q", transition, tt.wantTransition)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := newIssue(base, "closed"); !reflect.DeepEqual(got, want) {
+				t.Errorf("Client.CloseIssue(context.Background()) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	c, err := jira.NewClient("https://example.atlassian.net", "", "FAKE-AUTH-TOKEN", "alert", "Task", "Done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BaseURL.String() != "https://example.atlassian.net/" {
+		t.Errorf("NewClient() BaseURL = %q, want trailing slash", c.BaseURL)
+	}
+	if _, err = jira.NewClient("", "", "FAKE-AUTH-TOKEN", "alert", "Task", "Done"); err == nil {
+		t.Errorf("NewClient() got nil error for empty URL, want error")
+	}
+	if _, err = jira.NewClient("invalidURLEscape%zz", "", "FAKE-AUTH-TOKEN", "alert", "Task", "Done"); err == nil {
+		t.Errorf("NewClient() got nil error, want error")
+	}
+}
+
+func TestClient_bearerAuth(t *testing.T) {
+	mux, base := resttest.NewServer(t, "/")
+	c, _ := jira.NewClient(base.String(), "", "FAKE-AUTH-TOKEN", "alert", "Task", "Done")
+
+	mux.HandleFunc("/rest/api/2/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer FAKE-AUTH-TOKEN" {
+			t.Errorf("Request does not contain bearer token")
+		}
+		// Jira Server uses the v2 search API.
+		issue := strings.Replace(result, `{"name": "To Do"}`, `{"name": "Closed", "statusCategory": {"key": "done"}}`, 1)
+		fmt.Fprintf(w, `{"startAt": 0, "total": 1, "issues": [%s]}`, issue)
+	})
+	got, err := c.ListOpenIssues(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].GetState() != "closed" {
+		t.Errorf("Client.ListOpenIssues(context.Background()) = %v, want one closed issue", got)
+	}
+}
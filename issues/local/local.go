@@ -13,79 +13,290 @@
 // limitations under the License.
 //////////////////////////////////////////////////////////////////////////////
 
-// Package local provides in memory operations on GitHub issues.
+// Package local provides local operations on GitHub issues, stored in memory
+// and optionally persisted to a file.
 package local
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/github"
 )
 
-// Client manages operations on the in memory store.
+// repositoryURLPrefix is the prefix of the RepositoryURL of local issues. The
+// full URL has the same path form as a Github API repository URL.
+const repositoryURLPrefix = "local:///repos/"
+
+// store contains all issues, open and closed, in creation order.
+type store struct {
+	Issues []*github.Issue `json:"issues"`
+}
+
+// Client manages operations on the local store. Client is safe for concurrent
+// use. Like Github, the issues of every repository are numbered sequentially,
+// and closed issues are preserved.
 type Client struct {
-	issues map[string]*github.Issue
+	// mu protects all fields below.
+	mu sync.Mutex
+	// file is the path where the store is saved after every change. When empty
+	// the store is only kept in memory.
+	file  string
+	store store
+	// now returns the current time.
+	now func() time.Time
 }
 
-// NewClient creates a Client.
+// NewClient creates a Client that keeps all issues in memory.
 func NewClient() *Client {
-	return &Client{
-		issues: make(map[string]*github.Issue),
+	return &Client{now: time.Now}
+}
+
+// NewFileClient creates a Client that saves all issues to the named file. If
+// the file exists, previously saved issues are loaded from it.
+func NewFileClient(file string) (*Client, error) {
+	c := &Client{file: file, now: time.Now}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	if err := json.Unmarshal(b, &c.store); err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", file, err)
+	}
+	return c, nil
 }
 
-// CreateIssue adds a new issue to the in memory store.
-func (c *Client) CreateIssue(repo, title, body string, extra []string) (*github.Issue, error) {
-	c.issues[title] = &github.Issue{
-		Title: &title,
-		Body:  &body,
+// CreateIssue adds a new open issue to the local store.
+func (c *Client) CreateIssue(ctx context.Context, repo, title, body string, extra []string) (*github.Issue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now().UTC()
+	repoURL := repositoryURLPrefix + repo
+	number := 1
+	for _, stored := range c.store.Issues {
+		// Stores saved before per repository numbering may skip numbers.
+		if stored.GetRepositoryURL() == repoURL && stored.GetNumber() >= number {
+			number = stored.GetNumber() + 1
+		}
+	}
+	issue := &github.Issue{
+		Number:        github.Int(number),
+		Title:         &title,
+		Body:          &body,
+		State:         github.String("open"),
+		RepositoryURL: github.String(repoURL),
+		CreatedAt:     &now,
+		UpdatedAt:     &now,
+	}
+	for i := range extra {
+		issue.Labels = append(issue.Labels, github.Label{Name: github.String(extra[i])})
+	}
+	c.store.Issues = append(c.store.Issues, issue)
+	if err := c.save(); err != nil {
+		// Undo the change so that memory matches the saved state.
+		c.store.Issues = c.store.Issues[:len(c.store.Issues)-1]
+		return nil, err
 	}
-	return c.issues[title], nil
+	return copyIssue(issue), nil
 }
 
-// LabelIssue idempotently adds or removes a label in the in memory store.
-func (c *Client) LabelIssue(issue *github.Issue, label string, add bool) error {
+// LabelIssue idempotently adds or removes a label in the local store.
+func (c *Client) LabelIssue(ctx context.Context, issue *github.Issue, label string, add bool) error {
 	if label == "" {
 		return nil
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	memIssue, ok := c.issues[issue.GetTitle()]
-	if !ok {
+	stored := c.find(issue)
+	if stored == nil {
 		return fmt.Errorf("Unknown issue: %s", issue.GetTitle())
 	}
-	found := false
-	for i, issueLabel := range memIssue.Labels {
-		if *issueLabel.Name == label {
-			found = true
-			if !add {
-				memIssue.Labels = append(memIssue.Labels[:i], memIssue.Labels[i+1:]...)
-			}
+	found := -1
+	for i := range stored.Labels {
+		if stored.Labels[i].GetName() == label {
+			found = i
 			break
 		}
 	}
-	if add && !found {
-		newLabel := github.Label{Name: &label}
-		memIssue.Labels = append(memIssue.Labels, newLabel)
+	if add == (found >= 0) {
+		// Nothing to change.
+		return nil
+	}
+	orig := *stored
+	labels := make([]github.Label, 0, len(stored.Labels)+1)
+	if add {
+		labels = append(append(labels, stored.Labels...), github.Label{Name: &label})
+	} else {
+		labels = append(append(labels, stored.Labels[:found]...), stored.Labels[found+1:]...)
+	}
+	now := c.now().UTC()
+	stored.Labels = labels
+	stored.UpdatedAt = &now
+	if err := c.save(); err != nil {
+		*stored = orig
+		return err
 	}
 	return nil
 }
 
-// ListOpenIssues returns all issues in the memory store.
-func (c *Client) ListOpenIssues() ([]*github.Issue, error) {
+// ListOpenIssues returns all open issues in the local store.
+func (c *Client) ListOpenIssues(ctx context.Context) ([]*github.Issue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	var allIssues []*github.Issue
-	for title := range c.issues {
-		log.Println("ListOpenIssues:", title)
-		allIssues = append(allIssues, c.issues[title])
+	for _, issue := range c.store.Issues {
+		if issue.GetState() == "open" {
+			allIssues = append(allIssues, copyIssue(issue))
+		}
 	}
 	return allIssues, nil
 }
 
-// CloseIssue removes the issue from the in memory store.
-func (c *Client) CloseIssue(issue *github.Issue) (*github.Issue, error) {
-	if _, ok := c.issues[issue.GetTitle()]; !ok {
+// CloseIssue marks the issue closed in the local store. Closed issues are
+// preserved, but are no longer returned by ListOpenIssues.
+func (c *Client) CloseIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := c.find(issue)
+	if stored == nil {
 		return nil, fmt.Errorf("Unknown issue:%s", issue.GetTitle())
 	}
-	delete(c.issues, issue.GetTitle())
-	return issue, nil
+	if stored.GetState() == "closed" {
+		return copyIssue(stored), nil
+	}
+	orig := *stored
+	now := c.now().UTC()
+	stored.State = github.String("closed")
+	stored.ClosedAt = &now
+	stored.UpdatedAt = &now
+	if err := c.save(); err != nil {
+		*stored = orig
+		return nil, err
+	}
+	return copyIssue(stored), nil
+}
+
+// ReopenIssue marks a closed issue open again in the local store.
+func (c *Client) ReopenIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := c.find(issue)
+	if stored == nil {
+		return nil, fmt.Errorf("Unknown issue:%s", issue.GetTitle())
+	}
+	if stored.GetState() == "open" {
+		return copyIssue(stored), nil
+	}
+	orig := *stored
+	now := c.now().UTC()
+	stored.State = github.String("open")
+	stored.ClosedAt = nil
+	stored.UpdatedAt = &now
+	if err := c.save(); err != nil {
+		*stored = orig
+		return nil, err
+	}
+	return copyIssue(stored), nil
+}
+
+// GetIssue returns the stored issue.
+func (c *Client) GetIssue(ctx context.Context, issue *github.Issue) (*github.Issue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := c.find(issue)
+	if stored == nil {
+		return nil, fmt.Errorf("Unknown issue:%s", issue.GetTitle())
+	}
+	return copyIssue(stored), nil
+}
+
+// RetitleIssue changes the title of the issue in the local store.
+func (c *Client) RetitleIssue(ctx context.Context, issue *github.Issue, title string) (*github.Issue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored := c.find(issue)
+	if stored == nil {
+		return nil, fmt.Errorf("Unknown issue:%s", issue.GetTitle())
+	}
+	orig := *stored
+	now := c.now().UTC()
+	stored.Title = github.String(title)
+	stored.UpdatedAt = &now
+	if err := c.save(); err != nil {
+		*stored = orig
+		return nil, err
+	}
+	return copyIssue(stored), nil
+}
+
+// find returns the stored issue with the same repository and number as the
+// given issue. If the issue has no number, find returns the open issue with
+// the same title. The caller must hold c.mu.
+func (c *Client) find(issue *github.Issue) *github.Issue {
+	if n := issue.GetNumber(); n != 0 {
+		for _, stored := range c.store.Issues {
+			if stored.GetNumber() == n && stored.GetRepositoryURL() == issue.GetRepositoryURL() {
+				return stored
+			}
+		}
+		return nil
+	}
+	for _, stored := range c.store.Issues {
+		if stored.GetState() == "open" && stored.GetTitle() == issue.GetTitle() {
+			return stored
+		}
+	}
+	return nil
+}
+
+// save writes the store to the client file, if any. The file is replaced
+// atomically so that a crash never leaves a partially written store. The
+// caller must hold c.mu.
+func (c *Client) save() error {
+	if c.file == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(&c.store, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file)
+}
+
+// copyIssue returns a copy of the issue that shares no mutable state with the
+// stored issue.
+func copyIssue(issue *github.Issue) *github.Issue {
+	c := *issue
+	if issue.Labels != nil {
+		c.Labels = make([]github.Label, len(issue.Labels))
+		copy(c.Labels, issue.Labels)
+	}
+	return &c
 }
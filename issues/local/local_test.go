@@ -16,12 +16,47 @@
 package local
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/github"
+	"github.com/m-lab/alertmanager-github-receiver/alerts"
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/prometheus/alertmanager/notify/webhook"
+	"github.com/prometheus/alertmanager/template"
 )
 
+var fakeNow = time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+
+func newFakeClient() *Client {
+	c := NewClient()
+	c.now = func() time.Time { return fakeNow }
+	return c
+}
+
+func newWantIssue(title, body string) *github.Issue {
+	return &github.Issue{
+		Number:        github.Int(1),
+		Title:         github.String(title),
+		Body:          github.String(body),
+		State:         github.String("open"),
+		RepositoryURL: github.String("local:///repos/fake-repo"),
+		CreatedAt:     &fakeNow,
+		UpdatedAt:     &fakeNow,
+	}
+}
+
 func TestMemoryClient(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -50,34 +85,26 @@ func TestMemoryClient(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewClient()
-			got, err := c.CreateIssue("fake-repo", tt.title, tt.body, nil)
+			c := newFakeClient()
+			got, err := c.CreateIssue(context.Background(), "fake-repo", tt.title, tt.body, nil)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Client.CreateIssue() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Client.CreateIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			wantIssue := &github.Issue{
-				Title: &tt.title,
-				Body:  &tt.body,
-			}
+			wantIssue := newWantIssue(tt.title, tt.body)
 			if !reflect.DeepEqual(got, wantIssue) {
-				t.Errorf("Client.CreateIssue() = %v, want %v", got, wantIssue)
+				t.Errorf("Client.CreateIssue(context.Background()) = %v, want %v", got, wantIssue)
 			}
 
-			wantList := []*github.Issue{
-				{
-					Title: &tt.title,
-					Body:  &tt.body,
-				},
-			}
+			wantList := []*github.Issue{newWantIssue(tt.title, tt.body)}
 			listAndCheck(t, c, tt.wantErr, wantList)
 
-			err = c.LabelIssue(tt.labelIssue, "", true)
+			err = c.LabelIssue(context.Background(), tt.labelIssue, "", true)
 			if (err != nil) != tt.wantErr {
 				t.Error(err)
 			}
 
-			err = c.LabelIssue(tt.labelIssue, tt.label, true)
+			err = c.LabelIssue(context.Background(), tt.labelIssue, tt.label, true)
 			if (err != nil) != tt.wantLabelErr {
 				t.Error(err)
 			}
@@ -86,7 +113,7 @@ func TestMemoryClient(t *testing.T) {
 			}
 			listAndCheck(t, c, tt.wantErr, wantList)
 
-			err = c.LabelIssue(tt.labelIssue, tt.label, false)
+			err = c.LabelIssue(context.Background(), tt.labelIssue, tt.label, false)
 			if (err != nil) != tt.wantLabelErr {
 				t.Error(err)
 			}
@@ -95,32 +122,307 @@ func TestMemoryClient(t *testing.T) {
 			}
 			listAndCheck(t, c, tt.wantErr, wantList)
 
-			closed, err := c.CloseIssue(got)
+			closed, err := c.CloseIssue(context.Background(), got)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Client.CloseIssue() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Client.CloseIssue(context.Background()) error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(closed, got) {
-				t.Errorf("Client.CloseIssue() = %v, want %v", closed, got)
+			wantClosed := wantList[0]
+			wantClosed.State = github.String("closed")
+			wantClosed.ClosedAt = &fakeNow
+			if !reflect.DeepEqual(closed, wantClosed) {
+				t.Errorf("Client.CloseIssue(context.Background()) = %v, want %v", closed, wantClosed)
 			}
+			listAndCheck(t, c, tt.wantErr, nil)
 
-			_, err = c.CloseIssue(&github.Issue{
+			_, err = c.CloseIssue(context.Background(), &github.Issue{
 				Title: github.String("cannot-close-missing-issue"),
 			})
 			if err == nil {
-				t.Errorf("Client.CloseIssue(), got nil, want error")
+				t.Errorf("Client.CloseIssue(context.Background()), got nil, want error")
 			}
 		})
 	}
 }
 
 func listAndCheck(t *testing.T, c *Client, wantErr bool, wantList []*github.Issue) {
-	list, err := c.ListOpenIssues()
+	list, err := c.ListOpenIssues(context.Background())
 	if (err != nil) != wantErr {
-		t.Errorf("Client.ListOpenIssues() error = %v, wantErr %v", err, wantErr)
+		t.Errorf("Client.ListOpenIssues(context.Background()) error = %v, wantErr %v", err, wantErr)
 		return
 	}
 	if !reflect.DeepEqual(list, wantList) {
-		t.Errorf("Client.ListOpenIssues() =\n%v\n, want\n%v", list, wantList)
+		t.Errorf("Client.ListOpenIssues(context.Background()) =\n%v\n, want\n%v", list, wantList)
+	}
+}
+
+func TestFileClient(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "issues.json")
+	c, err := NewFileClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.CreateIssue(context.Background(), "repo1", "alert1", "body1", []string{"extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.CreateIssue(context.Background(), "repo2", "alert2", "body2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LabelIssue(context.Background(), first, "resolved", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CloseIssue(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the saved issues into a new client.
+	c, err = NewFileClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := c.ListOpenIssues(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every repository numbers its issues from 1.
+	if len(list) != 1 || list[0].GetTitle() != "alert2" || list[0].GetNumber() != 1 ||
+		list[0].GetRepositoryURL() != "local:///repos/repo2" {
+		t.Errorf("ListOpenIssues() = %v, want open issue repo2#1 alert2", list)
+	}
+
+	// Closed issues are preserved and numbering continues.
+	closed := c.store.Issues[0]
+	if closed.GetState() != "closed" || closed.ClosedAt == nil || len(closed.Labels) != 2 {
+		t.Errorf("saved issue = %v, want closed issue with 2 labels", closed)
+	}
+	third, err := c.CreateIssue(context.Background(), "repo1", "alert1", "body1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.GetNumber() != 2 {
+		t.Errorf("CreateIssue() number = %d, want 2", third.GetNumber())
+	}
+
+	// Issues are found by repository and number.
+	if err = c.LabelIssue(context.Background(), list[0], "x", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.store.Issues[1]; len(got.Labels) != 1 || got.Labels[0].GetName() != "x" {
+		t.Errorf("LabelIssue() labeled %v, want repo2#1", c.store.Issues)
+	}
+	if len(c.store.Issues[0].Labels) != 2 {
+		t.Errorf("LabelIssue() labeled repo1#1 = %v, want unchanged", c.store.Issues[0])
+	}
+
+	// Operations on a missing issue number fail.
+	if err = c.LabelIssue(context.Background(), &github.Issue{Number: github.Int(4)}, "x", true); err == nil {
+		t.Errorf("LabelIssue() got nil error for missing issue, want error")
+	}
+}
+
+func TestClient_GetIssue(t *testing.T) {
+	c := newFakeClient()
+	issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert1", "body1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CloseIssue(context.Background(), issue); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetIssue(context.Background(), issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetState() != "closed" || got.GetNumber() != 1 {
+		t.Errorf("GetIssue() = %v, want closed issue #1", got)
+	}
+	if _, err = c.GetIssue(context.Background(), &github.Issue{Number: github.Int(2)}); err == nil {
+		t.Errorf("GetIssue() got nil error for missing issue, want error")
+	}
+}
+
+func TestClient_ReopenIssue(t *testing.T) {
+	c := newFakeClient()
+	issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert1", "body1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CloseIssue(context.Background(), issue); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := c.ReopenIssue(context.Background(), issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.GetState() != "open" || reopened.ClosedAt != nil || reopened.GetNumber() != 1 {
+		t.Errorf("ReopenIssue() = %v, want open issue #1", reopened)
+	}
+	// Reopening an open issue has no effect.
+	if _, err = c.ReopenIssue(context.Background(), issue); err != nil {
+		t.Errorf("ReopenIssue() error = %v for open issue, want nil", err)
+	}
+	if _, err = c.ReopenIssue(context.Background(), &github.Issue{Number: github.Int(2)}); err == nil {
+		t.Errorf("ReopenIssue() got nil error for missing issue, want error")
+	}
+}
+
+func TestClient_RetitleIssue(t *testing.T) {
+	c := newFakeClient()
+	issue, err := c.CreateIssue(context.Background(), "fake-repo", "alert1", "body1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retitled, err := c.RetitleIssue(context.Background(), issue, "alert1 on host1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retitled.GetTitle() != "alert1 on host1" || retitled.GetNumber() != 1 {
+		t.Errorf("RetitleIssue() = %v, want issue #1 with new title", retitled)
+	}
+	if _, err = c.RetitleIssue(context.Background(), &github.Issue{Number: github.Int(2)}, "x"); err == nil {
+		t.Errorf("RetitleIssue() got nil error for missing issue, want error")
+	}
+}
+
+func TestNewFileClient_errors(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileClient(corrupt); err == nil {
+		t.Errorf("NewFileClient() got nil error for corrupt file, want error")
+	}
+	if _, err := NewFileClient(dir); err == nil {
+		t.Errorf("NewFileClient() got nil error for directory, want error")
+	}
+
+	// Changes that cannot be saved are not applied.
+	c, err := NewFileClient(filepath.Join(dir, "missing", "issues.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CreateIssue(context.Background(), "repo", "alert", "body", nil); err == nil {
+		t.Errorf("CreateIssue() got nil error for unwritable file, want error")
+	}
+	if len(c.store.Issues) != 0 {
+		t.Errorf("CreateIssue() stored %d issues after error, want 0", len(c.store.Issues))
+	}
+}
+
+func TestNewFileClient_globalNumbers(t *testing.T) {
+	// Stores saved before per repository numbering numbered all issues in
+	// one sequence.
+	file := filepath.Join(t.TempDir(), "issues.json")
+	old := `{"issues": [
+		{"number": 1, "title": "alert1", "state": "open", "repository_url": "local:///repos/repo1"},
+		{"number": 2, "title": "alert2", "state": "open", "repository_url": "local:///repos/repo2"}
+	]}`
+	if err := os.WriteFile(file, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewFileClient(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for repo, want := range map[string]int{"repo1": 2, "repo2": 3, "repo3": 1} {
+		issue, err := c.CreateIssue(context.Background(), repo, "alert", "body", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if issue.GetNumber() != want {
+			t.Errorf("CreateIssue(%q) number = %d, want %d", repo, issue.GetNumber(), want)
+		}
+	}
+}
+
+// TestClient_concurrentHandlers exercises the Client through the real HTTP
+// handlers from many goroutines at once. Run with -race to detect unsafe
+// concurrent access.
+func TestClient_concurrentHandlers(t *testing.T) {
+	// Run handlers in parallel even on a single CPU so that the race detector
+	// observes truly concurrent accesses.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+
+	c := NewClient()
+	rh, err := alerts.NewReceiver(c, "fake-repo", true, "resolved", []string{"extra"}, alerts.DefaultTitleTmpl, alerts.DefaultAlertTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", &issues.ListHandler{ListClient: c})
+	mux.Handle("/v1/receiver", rh)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	post := func(alertname, status string) error {
+		msg := &webhook.Message{
+			Data: &template.Data{
+				Status:      status,
+				GroupLabels: template.KV{"alertname": alertname},
+			},
+		}
+		b, _ := json.Marshal(msg)
+		resp, err := http.Post(srv.URL+"/v1/receiver", "application/json", bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("POST %s %s: %s", alertname, status, resp.Status)
+		}
+		return nil
+	}
+	get := func() error {
+		resp, err := http.Get(srv.URL + "/")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET /: %s", resp.Status)
+		}
+		return nil
+	}
+
+	const workers = 16
+	const rounds = 25
+	errs := make(chan error, workers*rounds*2)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				alertname := fmt.Sprintf("Alert%d", (w+r)%4)
+				status := "firing"
+				if r%3 == 2 {
+					status = "resolved"
+				}
+				errs <- post(alertname, status)
+				errs <- get()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Every stored issue must be consistent, regardless of interleaving.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, issue := range c.store.Issues {
+		if issue.GetNumber() != i+1 {
+			t.Errorf("issue %d has number %d", i+1, issue.GetNumber())
+		}
+		if issue.GetState() == "closed" && issue.ClosedAt == nil {
+			t.Errorf("issue %d closed without ClosedAt", i+1)
+		}
 	}
 }
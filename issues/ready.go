@@ -0,0 +1,149 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package issues
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultProbeInterval is the default minimum time between the Github API
+// probes of a ReadyHandler.
+const DefaultProbeInterval = time.Minute
+
+// A Prober checks that the Github API can be used, and records the outcome
+// like any other API call.
+type Prober interface {
+	CheckRateLimits(ctx context.Context) error
+}
+
+// Readiness is the JSON response of the ReadyHandler.
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// Reasons explains why the receiver is not ready.
+	Reasons     []string  `json:"reasons"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   time.Time `json:"lastError"`
+	Failures    int       `json:"failures"`
+}
+
+// ReadyHandler reports whether the receiver can use its issue tracker, based on
+// the recent API calls of all Clients and the requests recorded by
+// RecordRequest. Only the Github API has rate limits.
+type ReadyHandler struct {
+	// MaxFailures is the number of consecutive failed API calls after which
+	// the receiver is not ready. Zero ignores failures.
+	MaxFailures int
+	// Prober, if set, checks the Github API when the receiver is not ready, or
+	// when no API call was made for ProbeInterval, so that the receiver
+	// becomes ready again without waiting for an alert.
+	Prober Prober
+	// ProbeInterval is the minimum time between probes. Zero uses
+	// DefaultProbeInterval.
+	ProbeInterval time.Duration
+
+	// mu protects lastProbe.
+	mu        sync.Mutex
+	lastProbe time.Time
+}
+
+// ServeHTTP responds with the Readiness as JSON, and status 503 if the
+// receiver is not ready.
+func (h *ReadyHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(rw, "Wrong method\n")
+		return
+	}
+	hl := CurrentHealth()
+	if h.shouldProbe(hl, time.Now()) {
+		if err := h.Prober.CheckRateLimits(req.Context()); err != nil {
+			slog.Warn("Readiness probe failed", "error", err)
+		}
+		hl = CurrentHealth()
+	}
+	r := &Readiness{
+		Reasons:     hl.unready(h.MaxFailures, time.Now()),
+		LastSuccess: hl.LastSuccess,
+		LastError:   hl.LastError,
+		Failures:    hl.Failures,
+	}
+	r.Ready = len(r.Reasons) == 0
+	rw.Header().Set("Content-Type", "application/json")
+	if !r.Ready {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(rw).Encode(r); err != nil {
+		slog.Error("Failed to write readiness", "error", err)
+	}
+}
+
+// shouldProbe reports whether the Prober should check the Github API now,
+// because the health is failing or stale and the last probe is older than
+// the ProbeInterval.
+func (h *ReadyHandler) shouldProbe(hl Health, now time.Time) bool {
+	if h.Prober == nil {
+		return false
+	}
+	interval := h.ProbeInterval
+	if interval == 0 {
+		interval = DefaultProbeInterval
+	}
+	last := hl.LastSuccess
+	if hl.LastError.After(last) {
+		last = hl.LastError
+	}
+	if now.Sub(last) < interval && len(hl.unready(h.MaxFailures, now)) == 0 {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Sub(h.lastProbe) < interval {
+		return false
+	}
+	h.lastProbe = now
+	return true
+}
+
+// unready returns the reasons why the issue tracker API cannot be used, if any.
+func (h Health) unready(maxFailures int, now time.Time) []string {
+	reasons := []string{}
+	if h.Unauthorized {
+		reasons = append(reasons, fmt.Sprintf("token is invalid: %s", h.Error))
+	}
+	apis := make([]string, 0, len(h.Rates))
+	for api := range h.Rates {
+		apis = append(apis, api)
+	}
+	sort.Strings(apis)
+	for _, api := range apis {
+		r := h.Rates[api]
+		if r.Remaining == 0 && r.Reset.After(now) {
+			reasons = append(reasons, fmt.Sprintf("github %s rate limit exhausted until %s",
+				api, r.Reset.UTC().Format(time.RFC3339)))
+		}
+	}
+	if maxFailures > 0 && h.Failures >= maxFailures {
+		reasons = append(reasons, fmt.Sprintf("last %d API calls failed: %s", h.Failures, h.Error))
+	}
+	return reasons
+}
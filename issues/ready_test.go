@@ -0,0 +1,215 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package issues
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// resetHealth forgets all recorded API calls.
+func resetHealth() {
+	health.Lock()
+	defer health.Unlock()
+	health.Health = Health{Rates: make(map[string]github.Rate)}
+}
+
+func TestReadyHandler_ServeHTTP(t *testing.T) {
+	reset := time.Now().Add(time.Hour)
+	response := func(status, remaining int, reset time.Time) *github.Response {
+		return &github.Response{
+			Response: &http.Response{StatusCode: status},
+			Rate:     github.Rate{Limit: 5000, Remaining: remaining, Reset: github.Timestamp{Time: reset}},
+		}
+	}
+	type call struct {
+		resp *github.Response
+		err  error
+	}
+	tests := []struct {
+		name           string
+		method         string
+		calls          []call
+		expectedStatus int
+		wantReasons    []string
+	}{
+		{
+			name:           "no-calls",
+			expectedStatus: http.StatusOK,
+			wantReasons:    []string{},
+		},
+		{
+			name: "success",
+			calls: []call{
+				{resp: response(http.StatusOK, 4000, reset)},
+			},
+			expectedStatus: http.StatusOK,
+			wantReasons:    []string{},
+		},
+		{
+			name: "invalid-token",
+			calls: []call{
+				{resp: response(http.StatusUnauthorized, 4000, reset), err: fmt.Errorf("Bad credentials")},
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			wantReasons:    []string{"token is invalid: Bad credentials"},
+		},
+		{
+			name: "rate-limit-exhausted",
+			calls: []call{
+				{resp: response(http.StatusOK, 0, reset)},
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			wantReasons:    []string{"github search rate limit exhausted until " + reset.UTC().Format(time.RFC3339)},
+		},
+		{
+			name: "rate-limit-reset",
+			calls: []call{
+				{resp: response(http.StatusOK, 0, time.Now().Add(-time.Minute))},
+			},
+			expectedStatus: http.StatusOK,
+			wantReasons:    []string{},
+		},
+		{
+			name: "some-failures",
+			calls: []call{
+				{err: fmt.Errorf("timeout")},
+				{err: fmt.Errorf("timeout")},
+			},
+			expectedStatus: http.StatusOK,
+			wantReasons:    []string{},
+		},
+		{
+			name: "too-many-failures",
+			calls: []call{
+				{err: fmt.Errorf("timeout")},
+				{err: fmt.Errorf("timeout")},
+				{err: fmt.Errorf("connection refused")},
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			wantReasons:    []string{"last 3 API calls failed: connection refused"},
+		},
+		{
+			name: "recovered",
+			calls: []call{
+				{resp: response(http.StatusUnauthorized, 4000, reset), err: fmt.Errorf("Bad credentials")},
+				{err: fmt.Errorf("timeout")},
+				{err: fmt.Errorf("timeout")},
+				{resp: response(http.StatusOK, 4000, reset)},
+			},
+			expectedStatus: http.StatusOK,
+			wantReasons:    []string{},
+		},
+		{
+			name:           "bad-method",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetHealth()
+			defer resetHealth()
+			for _, c := range tt.calls {
+				recordHealth("search", c.resp, c.err)
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rw := httptest.NewRecorder()
+			h := &ReadyHandler{MaxFailures: 3}
+			h.ServeHTTP(rw, httptest.NewRequest(method, "/readyz", nil))
+			if rw.Code != tt.expectedStatus {
+				t.Errorf("ReadyHandler wrong status; want %d, got %d", tt.expectedStatus, rw.Code)
+			}
+			if tt.wantReasons == nil {
+				return
+			}
+			got := &Readiness{}
+			if err := json.Unmarshal(rw.Body.Bytes(), got); err != nil {
+				t.Fatalf("ReadyHandler returned invalid JSON: %s", err)
+			}
+			if got.Ready != (tt.expectedStatus == http.StatusOK) {
+				t.Errorf("ReadyHandler ready = %t with status %d", got.Ready, rw.Code)
+			}
+			if !reflect.DeepEqual(got.Reasons, tt.wantReasons) {
+				t.Errorf("ReadyHandler reasons = %q, want %q", got.Reasons, tt.wantReasons)
+			}
+		})
+	}
+}
+
+// fakeProber records the outcome of its probes like a Client.
+type fakeProber struct {
+	err    error
+	probes int
+}
+
+func (p *fakeProber) CheckRateLimits(ctx context.Context) error {
+	p.probes++
+	var resp *github.Response
+	if p.err == nil {
+		resp = &github.Response{Response: &http.Response{StatusCode: http.StatusOK}}
+	}
+	recordHealth("core", resp, p.err)
+	return p.err
+}
+
+func TestReadyHandler_probe(t *testing.T) {
+	resetHealth()
+	defer resetHealth()
+	serve := func(h *ReadyHandler) int {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rw.Code
+	}
+
+	// Without any API calls, the first request probes the API.
+	p := &fakeProber{}
+	h := &ReadyHandler{MaxFailures: 1, Prober: p, ProbeInterval: time.Hour}
+	if code := serve(h); code != http.StatusOK || p.probes != 1 {
+		t.Errorf("ReadyHandler status %d after %d probes, want %d after 1", code, p.probes, http.StatusOK)
+	}
+	// Recent successful calls are not probed.
+	if code := serve(h); code != http.StatusOK || p.probes != 1 {
+		t.Errorf("ReadyHandler status %d after %d probes, want %d after 1", code, p.probes, http.StatusOK)
+	}
+
+	// A failing receiver probes at most once per interval.
+	recordHealth("search", nil, fmt.Errorf("timeout"))
+	p = &fakeProber{err: fmt.Errorf("connection refused")}
+	h = &ReadyHandler{MaxFailures: 1, Prober: p, ProbeInterval: time.Hour}
+	for i := 0; i < 2; i++ {
+		if code := serve(h); code != http.StatusServiceUnavailable || p.probes != 1 {
+			t.Errorf("ReadyHandler status %d after %d probes, want %d after 1", code, p.probes, http.StatusServiceUnavailable)
+		}
+	}
+
+	// It becomes ready again when a later probe succeeds.
+	p.err = nil
+	h.ProbeInterval = time.Nanosecond
+	if code := serve(h); code != http.StatusOK || p.probes != 2 {
+		t.Errorf("ReadyHandler status %d after %d probes, want %d after 2", code, p.probes, http.StatusOK)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package rest sends JSON requests to the REST APIs of the issue trackers
+// other than Github, i.e. GitLab, Gitea and Jira.
+//
+// Every request is traced, and recorded in the issues API metrics and Health,
+// so that /readyz reports failing requests like failing Github API calls.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/m-lab/alertmanager-github-receiver/tracing"
+)
+
+// Timeout is the maximum duration of every request.
+const Timeout = 15 * time.Second
+
+// NewHTTPClient returns an HTTP client that traces every request.
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: &tracing.Transport{}}
+}
+
+// NewRequest returns an API request with an optional JSON body to the path
+// relative to base. The caller adds the authentication headers.
+func NewRequest(ctx context.Context, method string, base *url.URL, path string, body interface{}) (*http.Request, error) {
+	u, err := base.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	var buf io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		buf = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// Do sends the request using client, within Timeout. A successful JSON
+// response is decoded into result, when result is not nil. Any other status is
+// an error. The outcome is recorded with issues.RecordRequest, by method.
+func Do(client *http.Client, req *http.Request, result interface{}) (*http.Response, error) {
+	start := time.Now()
+	resp, err := do(client, req, result)
+	issues.RecordRequest(strings.ToLower(req.Method), start, resp, err)
+	return resp, err
+}
+
+func do(client *http.Client, req *http.Request, result interface{}) (*http.Response, error) {
+	// Enforce a timeout on every API operation.
+	ctx, cancel := context.WithTimeout(req.Context(), Timeout)
+	defer cancel()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, fmt.Errorf("%s %s: %d %s", req.Method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package rest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/alertmanager-github-receiver/issues"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest"
+	"github.com/m-lab/alertmanager-github-receiver/issues/rest/resttest"
+)
+
+func TestDo(t *testing.T) {
+	mux, base := resttest.NewServer(t, "/api/")
+	mux.HandleFunc("/api/ok", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Request Content-Type = %q, want application/json", r.Header.Get("Content-Type"))
+		}
+		fmt.Fprint(w, `{"name": "fake"}`)
+	})
+	mux.HandleFunc("/api/denied", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "bad token")
+	})
+	client := rest.NewHTTPClient()
+
+	req, err := rest.NewRequest(context.Background(), http.MethodGet, base, "denied", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rest.Do(client, req, nil)
+	if err == nil || !strings.Contains(err.Error(), "401 bad token") {
+		t.Errorf("Do() error = %v, want 401 bad token", err)
+	}
+	if h := issues.CurrentHealth(); h.Failures != 1 || !h.Unauthorized {
+		t.Errorf("Do() Health = %+v, want 1 unauthorized failure", h)
+	}
+
+	req, err = rest.NewRequest(context.Background(), http.MethodPost, base, "ok", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]string{}
+	if _, err := rest.Do(client, req, &result); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if result["name"] != "fake" {
+		t.Errorf("Do() result = %v, want name fake", result)
+	}
+	if h := issues.CurrentHealth(); h.Failures != 0 || h.Unauthorized || h.LastSuccess.IsZero() {
+		t.Errorf("Do() Health = %+v, want a success", h)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package resttest provides a fake REST API server for the tests of the
+// clients of issue trackers other than Github.
+package resttest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// NewServer starts a test HTTP server, which is closed when the test ends.
+// Tests register handlers on the returned mux which provide mock responses for
+// the API methods used by the method under test. The returned URL is the
+// server URL followed by the API path prefix, e.g. "/api/v4/".
+func NewServer(t *testing.T, prefix string) (*http.ServeMux, *url.URL) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// Test server URL is guaranteed to parse successfully.
+	u, _ := url.Parse(srv.URL + prefix)
+	return mux, u
+}
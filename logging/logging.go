@@ -0,0 +1,76 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package logging creates structured loggers, and carries the logger of a
+// request in its context, so that all log records of the request share its
+// request ID.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+)
+
+// RequestIDHeader is the response header that contains the request ID.
+const RequestIDHeader = "X-Request-Id"
+
+// contextKey is the context key of the logger.
+type contextKey struct{}
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// New creates a logger that writes JSON records at or above the level to w.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// WithLogger returns a copy of ctx that carries the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of ctx whose logger adds a new request ID to
+// every record, and the request ID.
+func WithRequestID(ctx context.Context) (context.Context, string) {
+	id := NewRequestID()
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogger(ctx, FromContext(ctx).With("request_id", id)), id
+}
+
+// RequestID returns the request ID of ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request ID of 16 hex digits.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	// crypto/rand.Read never returns an error.
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
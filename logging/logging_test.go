@@ -0,0 +1,59 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := WithLogger(context.Background(), New(&buf, slog.LevelInfo))
+	ctx, id := WithRequestID(ctx)
+	if len(id) != 16 {
+		t.Errorf("WithRequestID() id = %q, want 16 hex digits", id)
+	}
+	if got := RequestID(ctx); got != id {
+		t.Errorf("RequestID() = %q, want %q", got, id)
+	}
+	FromContext(ctx).Info("handled", "alert", "DiskRunningFull")
+	FromContext(ctx).Debug("not logged")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want 1: %q", len(lines), buf.String())
+	}
+	rec := map[string]string{}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["request_id"] != id || rec["msg"] != "handled" || rec["alert"] != "DiskRunningFull" || rec["level"] != "INFO" {
+		t.Errorf("got record %v, want request_id %q", rec, id)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != slog.Default() {
+		t.Errorf("FromContext() = %v, want the default logger", got)
+	}
+	if NewRequestID() == NewRequestID() {
+		t.Errorf("NewRequestID() returned the same ID twice")
+	}
+}
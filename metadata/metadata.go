@@ -0,0 +1,119 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+// Package metadata embeds a description of the alert group of an issue in the
+// issue body, and reads it back.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+const (
+	metadataPrefix = "<!-- alertmanager-github-receiver: "
+	metadataSuffix = " -->"
+)
+
+// ErrNoMetadata is returned by Parse when an issue body has no
+// metadata.
+var ErrNoMetadata = errors.New("issue body has no alert metadata")
+
+// Metadata describes the alert group of an issue. Metadata is embedded in
+// every issue body as an HTML comment, which Github does not render, so that
+// later operations on the issue can find the alerts that created it.
+type Metadata struct {
+	GroupKey     string            `json:"groupKey"`
+	Receiver     string            `json:"receiver"`
+	GroupLabels  map[string]string `json:"groupLabels"`
+	CommonLabels map[string]string `json:"commonLabels"`
+	ExternalURL  string            `json:"externalURL"`
+}
+
+// New returns the metadata of the alert group in the webhook message.
+func New(msg *webhook.Message) *Metadata {
+	return &Metadata{
+		GroupKey:     msg.GroupKey,
+		Receiver:     msg.Receiver,
+		GroupLabels:  msg.GroupLabels,
+		CommonLabels: msg.CommonLabels,
+		ExternalURL:  msg.ExternalURL,
+	}
+}
+
+// Matchers returns the labels that identify the alerts of the group: the
+// group labels, or the common labels when the alerts are not grouped.
+func (m *Metadata) Matchers() map[string]string {
+	if len(m.GroupLabels) != 0 {
+		return m.GroupLabels
+	}
+	return m.CommonLabels
+}
+
+// AlertName returns the alertname of the alert group.
+func (m *Metadata) AlertName() string {
+	if name, ok := m.GroupLabels["alertname"]; ok {
+		return name
+	}
+	return m.CommonLabels["alertname"]
+}
+
+// Message returns a firing webhook message for the alert group of the
+// metadata. The message has no alerts.
+func (m *Metadata) Message() *webhook.Message {
+	return &webhook.Message{
+		Data: &amtemplate.Data{
+			Receiver:     m.Receiver,
+			Status:       "firing",
+			GroupLabels:  m.GroupLabels,
+			CommonLabels: m.CommonLabels,
+			ExternalURL:  m.ExternalURL,
+		},
+		GroupKey: m.GroupKey,
+	}
+}
+
+// Format returns the metadata as an HTML comment to append to an issue body.
+func Format(m *Metadata) (string, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	// Marshal escapes "<" and ">", so the JSON cannot end the comment early.
+	return "\n" + metadataPrefix + string(b) + metadataSuffix + "\n", nil
+}
+
+// Parse returns the metadata embedded in the issue body by Format, or
+// ErrNoMetadata.
+func Parse(body string) (*Metadata, error) {
+	start := strings.LastIndex(body, metadataPrefix)
+	if start < 0 {
+		return nil, ErrNoMetadata
+	}
+	rest := body[start+len(metadataPrefix):]
+	end := strings.Index(rest, metadataSuffix)
+	if end < 0 {
+		return nil, ErrNoMetadata
+	}
+	m := &Metadata{}
+	if err := json.Unmarshal([]byte(rest[:end]), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+
+package metadata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/alertmanager/notify/webhook"
+	amtmpl "github.com/prometheus/alertmanager/template"
+)
+
+func TestMetadata(t *testing.T) {
+	msg := &webhook.Message{
+		Data: &amtmpl.Data{
+			Receiver:     "github",
+			GroupLabels:  amtmpl.KV{"alertname": "DiskRunningFull"},
+			CommonLabels: amtmpl.KV{"alertname": "DiskRunningFull", "note": "--> <b>"},
+			ExternalURL:  "http://localhost:9093",
+		},
+		GroupKey: `{}:{alertname="DiskRunningFull"}`,
+	}
+	want := New(msg)
+	meta, err := Format(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		body    string
+		want    *Metadata
+		wantErr bool
+	}{
+		{
+			name: "success",
+			body: "issue body" + meta,
+			want: want,
+		},
+		{
+			name: "success-last-metadata",
+			body: "quoted " + metadataPrefix + "{}" + metadataSuffix + " body" + meta,
+			want: want,
+		},
+		{
+			name:    "error-no-metadata",
+			body:    "issue body",
+			wantErr: true,
+		},
+		{
+			name:    "error-unterminated",
+			body:    "issue body\n" + metadataPrefix + "{}",
+			wantErr: true,
+		},
+		{
+			name:    "error-bad-json",
+			body:    "issue body\n" + metadataPrefix + "{" + metadataSuffix,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadata_Matchers(t *testing.T) {
+	m := &Metadata{CommonLabels: map[string]string{"alertname": "foo", "dev": "sda1"}}
+	if got := m.Matchers(); !reflect.DeepEqual(got, m.CommonLabels) {
+		t.Errorf("Matchers() = %v, want common labels %v", got, m.CommonLabels)
+	}
+	m.GroupLabels = map[string]string{"alertname": "foo"}
+	if got := m.Matchers(); !reflect.DeepEqual(got, m.GroupLabels) {
+		t.Errorf("Matchers() = %v, want group labels %v", got, m.GroupLabels)
+	}
+}
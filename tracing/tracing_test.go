@@ -0,0 +1,82 @@
+// Copyright 2017 alertmanager-github-receiver Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//////////////////////////////////////////////////////////////////////////////
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTransport(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	client := &http.Client{Transport: &Transport{}}
+	tests := []struct {
+		path       string
+		wantStatus codes.Code
+	}{
+		{path: "/ok", wantStatus: codes.Unset},
+		{path: "/fail", wantStatus: codes.Error},
+	}
+	for _, tt := range tests {
+		exporter.Reset()
+		ctx, parent := Start(context.Background(), "parent")
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+tt.path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		End(parent, nil)
+
+		spans := exporter.GetSpans()
+		if len(spans) != 2 {
+			t.Fatalf("%s: got %d spans, want 2", tt.path, len(spans))
+		}
+		s := spans[0]
+		if s.Name != "HTTP GET" || s.SpanKind != trace.SpanKindClient || s.Status.Code != tt.wantStatus {
+			t.Errorf("%s: got span %q kind %v status %v, want HTTP GET client span with status %v",
+				tt.path, s.Name, s.SpanKind, s.Status.Code, tt.wantStatus)
+		}
+		if s.Parent.SpanID() != spans[1].SpanContext.SpanID() {
+			t.Errorf("%s: request span is not a child of the parent span", tt.path)
+		}
+	}
+
+	// Network errors are recorded on the span.
+	srv.Close()
+	exporter.Reset()
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("Get() of a closed server succeeded")
+	}
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Status.Code != codes.Error {
+		t.Errorf("got spans %v, want one failed span", spans)
+	}
+}